@@ -6,6 +6,7 @@ import (
 	"medical-records-app/internal/database"
 	"medical-records-app/internal/router"
 	"os"
+	_ "time/tzdata" // reminders use IANA timezones; the alpine image ships without zoneinfo
 
 	"github.com/joho/godotenv"
 	"gorm.io/gorm"
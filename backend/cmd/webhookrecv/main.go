@@ -0,0 +1,60 @@
+// Command webhookrecv is a local webhook receiver for development. It checks
+// signatures, prints each delivery and can answer with a chosen status code
+// to exercise retries and dead-lettering.
+//
+//	go run ./cmd/webhookrecv -addr :9000 -secret whsec_...
+//
+// Register http://localhost:9000/ as an endpoint (private targets must be
+// allowed, which is the default outside production) and use the test-fire
+// endpoint to send a delivery.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"medical-records-app/internal/webhook"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	secret := flag.String("secret", os.Getenv("WEBHOOK_SECRET"), "endpoint signing secret (default $WEBHOOK_SECRET)")
+	status := flag.Int("status", http.StatusOK, "status code to respond with, e.g. 500 to test retries")
+	tolerance := flag.Duration("tolerance", 5*time.Minute, "maximum age of the signed timestamp")
+	flag.Parse()
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		verdict := "signature not checked (no -secret)"
+		if *secret != "" {
+			if err := webhook.Verify(*secret, r.Header.Get(webhook.HeaderSignature), r.Header.Get(webhook.HeaderTimestamp), body, *tolerance); err != nil {
+				log.Printf("REJECTED %s %s: %v", r.Header.Get(webhook.HeaderEvent), r.Header.Get(webhook.HeaderID), err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			verdict = "signature ok"
+		}
+
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, body, "", "  "); err != nil {
+			pretty.Write(body)
+		}
+		log.Printf("%s delivery %s (%s)\n%s", r.Header.Get(webhook.HeaderEvent), r.Header.Get(webhook.HeaderID), verdict, pretty.String())
+
+		w.WriteHeader(*status)
+		io.WriteString(w, http.StatusText(*status))
+	})
+
+	log.Printf("Webhook receiver listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -11,9 +11,10 @@ import (
 var jwtSecret []byte
 
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	Role   string    `json:"role"`
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID uuid.UUID `json:"sid"` // sign-in session, checked for revocation on every request
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
 	jwt.RegisteredClaims
 }
 
@@ -21,12 +22,14 @@ func SetJWTSecret(secret string) {
 	jwtSecret = []byte(secret)
 }
 
-func GenerateToken(userID uuid.UUID, email, role string, expirationHours int) (string, error) {
-	expirationTime := time.Now().Add(time.Duration(expirationHours) * time.Hour)
+// GenerateToken issues an access token for one of the user's sessions
+func GenerateToken(userID, sessionID uuid.UUID, email, role string, ttl time.Duration) (string, time.Time, error) {
+	expirationTime := time.Now().Add(ttl)
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:    userID,
+		SessionID: sessionID,
+		Email:     email,
+		Role:      role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -37,7 +40,8 @@ func GenerateToken(userID uuid.UUID, email, role string, expirationHours int) (s
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	signed, err := token.SignedString(jwtSecret)
+	return signed, expirationTime, err
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
@@ -57,6 +61,165 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	// Share viewer sessions, email verification links and MFA challenges are
+	// signed with the same key but name an audience; access tokens never do
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+const shareSessionAudience = "share-viewer"
+
+// ShareSessionClaims identify a viewer who has unlocked a protected share,
+// or who has just opened a share and had the view counted
+type ShareSessionClaims struct {
+	ShareID uuid.UUID `json:"share_id"`
+	Viewed  bool      `json:"viewed,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateShareSessionToken issues a short-lived token that lets the bearer
+// view one protected share without re-entering its code. viewed marks a
+// session issued by a counted view.
+func GenerateShareSessionToken(shareID uuid.UUID, viewed bool, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := &ShareSessionClaims{
+		ShareID: shareID,
+		Viewed:  viewed,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "medical-records-app",
+			Subject:   shareID.String(),
+			Audience:  jwt.ClaimStrings{shareSessionAudience},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(jwtSecret)
+	return signed, expiresAt, err
+}
+
+func ValidateShareSessionToken(tokenString string) (*ShareSessionClaims, error) {
+	claims := &ShareSessionClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	}, jwt.WithAudience(shareSessionAudience))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
 	return claims, nil
 }
 
+const emailVerificationAudience = "email-verification"
+
+// EmailVerificationClaims prove that whoever holds the token received mail
+// at Email. The subject is the user ID.
+type EmailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// GenerateEmailVerificationToken signs a link token confirming the user's
+// email address. It stops working if the address changes.
+func GenerateEmailVerificationToken(userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	claims := &EmailVerificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "medical-records-app",
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+func ValidateEmailVerificationToken(tokenString string) (*EmailVerificationClaims, error) {
+	claims := &EmailVerificationClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	}, jwt.WithAudience(emailVerificationAudience))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+const mfaChallengeAudience = "mfa-challenge"
+
+// MFAChallengeClaims say the user got their password right and still owes a
+// second factor. The subject is the user ID. PasswordVersion is the version
+// of the password that was checked, so the challenge dies if it's changed.
+type MFAChallengeClaims struct {
+	PasswordVersion int `json:"pwv"`
+	jwt.RegisteredClaims
+}
+
+// GenerateMFAChallengeToken signs a challenge for a user who entered
+// version passwordVersion of their password
+func GenerateMFAChallengeToken(userID uuid.UUID, passwordVersion int, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := &MFAChallengeClaims{
+		PasswordVersion: passwordVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "medical-records-app",
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(jwtSecret)
+	return signed, expiresAt, err
+}
+
+// ValidateMFAChallengeToken returns the claims of a challenge token; the
+// caller checks the password version against the user's
+func ValidateMFAChallengeToken(tokenString string) (*MFAChallengeClaims, error) {
+	claims := &MFAChallengeClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	}, jwt.WithAudience(mfaChallengeAudience))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMFAChallengeToken(t *testing.T) {
+	SetJWTSecret("test-secret")
+	defer SetJWTSecret("")
+
+	userID := uuid.New()
+	token, _, err := GenerateMFAChallengeToken(userID, 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateMFAChallengeToken(token)
+	if err != nil {
+		t.Fatalf("ValidateMFAChallengeToken: %v", err)
+	}
+	if claims.Subject != userID.String() || claims.PasswordVersion != 3 {
+		t.Errorf("claims = %s version %d, want %s version 3", claims.Subject, claims.PasswordVersion, userID)
+	}
+
+	// A challenge is not an access token
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("ValidateToken accepted a challenge token")
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateRandomToken returns a URL-safe token with n bytes of entropy from
+// the operating system's CSPRNG
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of a token. Tokens are high-entropy, so a
+// fast unsalted hash is enough to make stored values useless if leaked.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashCode returns the hex HMAC-SHA256 of a short code bound to the subject
+// it was issued for. Six-digit or recovery codes are small enough to brute
+// force from a plain hash, so the server secret keys it: a leaked table is
+// useless without the secret too.
+func HashCode(subject, code string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("code:" + subject + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
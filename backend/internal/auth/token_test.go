@@ -0,0 +1,23 @@
+package auth
+
+import "testing"
+
+func TestHashCode(t *testing.T) {
+	SetJWTSecret("first-secret")
+	hash := HashCode("subject", "123456")
+	if hash != HashCode("subject", "123456") {
+		t.Fatal("HashCode isn't deterministic")
+	}
+	if hash == HashToken("subject:123456") {
+		t.Error("HashCode is the unkeyed hash")
+	}
+	if hash == HashCode("other-subject", "123456") {
+		t.Error("HashCode doesn't bind the code to its subject")
+	}
+
+	SetJWTSecret("second-secret")
+	defer SetJWTSecret("")
+	if hash == HashCode("subject", "123456") {
+		t.Error("HashCode doesn't depend on the server secret")
+	}
+}
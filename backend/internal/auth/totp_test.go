@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key from the RFC 6238 test vectors
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B gives eight digits; six-digit codes are the last six
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+		step, ok := ValidateTOTP(rfcSecret, tt.want, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/30 {
+			t.Errorf("ValidateTOTP(%s) at %d = %d, %v; want %d, true", tt.want, tt.unix, step, ok, tt.unix/30)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	nowStep := now.Unix() / 30
+	tests := []struct {
+		name   string
+		offset time.Duration // when the code was generated, relative to now
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -30 * time.Second, true},
+		{"next step", 30 * time.Second, true},
+		{"two steps back", -60 * time.Second, false},
+		{"two steps ahead", 60 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(rfcSecret, now.Add(tt.offset))
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := ValidateTOTP(rfcSecret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != nowStep+int64(tt.offset/(30*time.Second)) {
+				t.Errorf("step = %d, want the step the code was made for", step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"spaces are ignored", rfcSecret, " 005 924 ", true},
+		{"lower-case secret", strings.ToLower(rfcSecret), "005924", true},
+		{"wrong code", rfcSecret, "005925", false},
+		{"too short", rfcSecret, "05924", false},
+		{"too long", rfcSecret, "0005924", false},
+		{"empty", rfcSecret, "", false},
+		{"bad secret", "not base32!", "005924", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok != tt.ok {
+				t.Errorf("ValidateTOTP(%q) ok = %v, want %v", tt.code, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not unpadded base32: %v", secret, err)
+	}
+	if len(key) != totpSecretSize {
+		t.Errorf("secret is %d bytes, want %d", len(key), totpSecretSize)
+	}
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateTOTP(secret, code, now); !ok {
+		t.Error("a code from a new secret doesn't validate")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := TOTPURI("JBSWY3DPEHPK3PXP", "Medical Records App", "ana@example.com")
+	want := "otpauth://totp/Medical%20Records%20App:ana@example.com?issuer=Medical+Records+App&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("TOTPURI = %s, want %s", got, want)
+	}
+}
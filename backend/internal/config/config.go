@@ -3,6 +3,7 @@ package config
 import (
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +14,10 @@ type Config struct {
 	AWS      AWSConfig
 	SMTP     SMTPConfig
 	SMS      SMSConfig
+	Notification NotificationConfig
+	Webhook  WebhookConfig
+	Verification VerificationConfig
+	MFA      MFAConfig
 }
 
 type DatabaseConfig struct {
@@ -25,14 +30,17 @@ type DatabaseConfig struct {
 }
 
 type ServerConfig struct {
-	Port string
-	Host string
-	Env  string
+	Port      string
+	Host      string
+	Env       string
+	PublicURL string // externally reachable base URL of the API, used in links such as calendar feeds
+	FrontendURL string // base URL of the web app, used in links such as share links
 }
 
 type JWTConfig struct {
-	Secret         string
-	ExpirationHours int
+	Secret             string
+	AccessTokenMinutes int // lifetime of access tokens; clients renew them with a refresh token
+	RefreshTokenDays   int // how long a session lasts without being refreshed
 }
 
 type AWSConfig struct {
@@ -55,6 +63,56 @@ type SMSConfig struct {
 	TwilioAccountSID string
 	TwilioAuthToken  string
 	TwilioPhoneNumber string
+	TwilioAPIURL     string
+}
+
+type NotificationConfig struct {
+	LogFile          string // development sink used when SMTP/SMS are not configured (never in production)
+	MaxAttempts      int
+	RetryBaseSeconds int
+}
+
+type WebhookConfig struct {
+	MaxAttempts         int
+	RetryBaseSeconds    int
+	TimeoutSeconds      int
+	AllowPrivateTargets bool // allow loopback/private URLs, e.g. a local test receiver
+}
+
+type VerificationConfig struct {
+	EmailTokenHours         int      // how long an email verification link works
+	PhoneCodeMinutes        int      // how long an SMS verification code works
+	PasswordResetMinutes    int      // how long a password reset link works
+	ResendCooldownSeconds   int      // minimum time between verification or reset messages
+	MaxSendsPerDay          int      // verification or reset messages per user per 24 hours
+	RequireVerifiedEmailFor []string // features unverified accounts can't use: sharing, webhooks
+}
+
+// RequiresVerifiedEmail reports whether feature is limited to accounts with
+// a verified email address
+func (c VerificationConfig) RequiresVerifiedEmail(feature string) bool {
+	for _, f := range c.RequireVerifiedEmailFor {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+type MFAConfig struct {
+	ChallengeMinutes int      // how long the second step of a two-step login can take
+	RequireFor       []string // features that need a second factor in the session: sharing, export
+}
+
+// RequiresMFA reports whether feature needs a session that was verified with
+// a second factor
+func (c MFAConfig) RequiresMFA(feature string) bool {
+	for _, f := range c.RequireFor {
+		if f == feature {
+			return true
+		}
+	}
+	return false
 }
 
 func Load() *Config {
@@ -83,10 +141,13 @@ func Load() *Config {
 			Port: getEnv("SERVER_PORT", "8080"),
 			Host: getEnv("SERVER_HOST", "localhost"),
 			Env:  getEnv("APP_ENV", "development"),
+			PublicURL: strings.TrimRight(getEnv("PUBLIC_URL", "http://localhost:8080"), "/"),
+			FrontendURL: strings.TrimRight(getEnv("FRONTEND_URL", "http://localhost:3000"), "/"),
 		},
 		JWT: JWTConfig{
-			Secret:          getEnv("JWT_SECRET", "change-me-in-production"),
-			ExpirationHours: getEnvAsInt("JWT_EXPIRATION_HOURS", 24),
+			Secret:             getEnv("JWT_SECRET", "change-me-in-production"),
+			AccessTokenMinutes: getEnvAsInt("JWT_ACCESS_TOKEN_MINUTES", 15),
+			RefreshTokenDays:   getEnvAsInt("JWT_REFRESH_TOKEN_DAYS", 30),
 		},
 		AWS: AWSConfig{
 			AccessKeyID:     getEnv("AWS_ACCESS_KEY_ID", ""),
@@ -106,6 +167,30 @@ func Load() *Config {
 			TwilioAccountSID: getEnv("TWILIO_ACCOUNT_SID", ""),
 			TwilioAuthToken:  getEnv("TWILIO_AUTH_TOKEN", ""),
 			TwilioPhoneNumber: getEnv("TWILIO_PHONE_NUMBER", ""),
+			TwilioAPIURL:     getEnv("TWILIO_API_URL", "https://api.twilio.com"),
+		},
+		Notification: NotificationConfig{
+			LogFile:          getEnv("NOTIFICATION_LOG_FILE", ""),
+			MaxAttempts:      getEnvAsInt("NOTIFICATION_MAX_ATTEMPTS", 5),
+			RetryBaseSeconds: getEnvAsInt("NOTIFICATION_RETRY_BASE_SECONDS", 30),
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts:         getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			RetryBaseSeconds:    getEnvAsInt("WEBHOOK_RETRY_BASE_SECONDS", 30),
+			TimeoutSeconds:      getEnvAsInt("WEBHOOK_TIMEOUT_SECONDS", 10),
+			AllowPrivateTargets: getEnvAsBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", getEnv("APP_ENV", "development") != "production"),
+		},
+		Verification: VerificationConfig{
+			EmailTokenHours:         getEnvAsInt("EMAIL_VERIFICATION_TOKEN_HOURS", 24),
+			PhoneCodeMinutes:        getEnvAsInt("PHONE_VERIFICATION_CODE_MINUTES", 10),
+			PasswordResetMinutes:    getEnvAsInt("PASSWORD_RESET_TOKEN_MINUTES", 60),
+			ResendCooldownSeconds:   getEnvAsInt("VERIFICATION_RESEND_COOLDOWN_SECONDS", 60),
+			MaxSendsPerDay:          getEnvAsInt("VERIFICATION_MAX_SENDS_PER_DAY", 5),
+			RequireVerifiedEmailFor: getEnvAsList("REQUIRE_VERIFIED_EMAIL_FOR", "sharing"),
+		},
+		MFA: MFAConfig{
+			ChallengeMinutes: getEnvAsInt("MFA_CHALLENGE_MINUTES", 5),
+			RequireFor:       getEnvAsList("REQUIRE_MFA_FOR", "none"),
 		},
 	}
 }
@@ -122,8 +207,35 @@ func getEnvAsInt(key string, defaultValue int) int {
 	if value == "" {
 		return defaultValue
 	}
-	// Simple conversion - in production, use strconv.Atoi with error handling
-	return defaultValue
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvAsList splits a comma-separated variable; set it to "none" for an
+// empty list
+func getEnvAsList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" && item != "none" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return b
 }
 
 // parseDatabaseURL parses a PostgreSQL connection URL
@@ -38,20 +38,55 @@ func RunMigrations(db *gorm.DB) error {
 	// Auto-migrate all models
 	err := db.AutoMigrate(
 		&User{},
+		&UserPreference{},
 		&HealthInsurance{},
 		&Prescription{},
 		&Appointment{},
 		&LabReport{},
 		&Medication{},
 		&Reminder{},
+		&ReminderEvent{},
+		&EmergencyContact{},
 		&SharedRecord{},
 		&AuditLog{},
+		&OneTimeCode{},
+		&AuthSession{},
+		&RefreshToken{},
+		&PasswordResetToken{},
+		&MFARecoveryCode{},
+		&NotificationDelivery{},
+		&UserEvent{},
+		&WebhookEndpoint{},
+		&WebhookDelivery{},
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if err := migrateShareTokens(db); err != nil {
+		return fmt.Errorf("failed to migrate share tokens: %w", err)
+	}
+
 	return nil
 }
 
+// migrateShareTokens replaces plaintext share tokens from older versions with
+// their hashes. Existing links keep working because lookups hash the token
+// they are given the same way (hex SHA-256, as auth.HashToken).
+func migrateShareTokens(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&SharedRecord{}, "share_token") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE shared_records
+			SET token_hash = encode(sha256(convert_to(share_token, 'UTF8')), 'hex'),
+				token_prefix = left(share_token, 8)
+			WHERE share_token IS NOT NULL AND share_token <> ''
+				AND (token_hash IS NULL OR token_hash = '')`).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&SharedRecord{}, "share_token")
+	})
+}
+
@@ -13,12 +13,21 @@ type User struct {
 	Email             string    `gorm:"uniqueIndex;not null" json:"email"`
 	Phone             string    `gorm:"index" json:"phone"`
 	PasswordHash      string    `gorm:"not null" json:"-"`
+	PasswordVersion   int       `gorm:"default:0" json:"-"` // bumped on every reset or change; two-factor challenges carry it
 	FirstName         string    `json:"first_name"`
 	LastName          string    `json:"last_name"`
 	DateOfBirth       *time.Time `json:"date_of_birth"`
 	IsEmailVerified   bool      `gorm:"default:false" json:"is_email_verified"`
 	IsPhoneVerified   bool      `gorm:"default:false" json:"is_phone_verified"`
-	Role              string    `gorm:"default:patient" json:"role"` // patient, doctor, caregiver
+	Role              string    `gorm:"default:patient" json:"role"` // patient, doctor, caregiver, admin
+	CalendarTokenHash string    `gorm:"index" json:"-"` // SHA-256 of the calendar feed token
+	CalendarTokenCreatedAt *time.Time `json:"-"`
+	MFAEnabled        bool      `gorm:"default:false" json:"mfa_enabled"`
+	MFAEnabledAt      *time.Time `json:"mfa_enabled_at,omitempty"`
+	TOTPSecret        string    `json:"-"` // base32; set while enrolling and once enabled
+	TOTPLastStep      int64     `json:"-"` // last time step a code was accepted for, so codes can't be replayed
+	MFAFailedAttempts int       `gorm:"default:0" json:"-"`
+	MFALockedUntil    *time.Time `json:"-"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -31,6 +40,35 @@ type User struct {
 	Medications       []Medication      `gorm:"foreignKey:UserID" json:"medications,omitempty"`
 	Reminders         []Reminder        `gorm:"foreignKey:UserID" json:"reminders,omitempty"`
 	SharedRecords     []SharedRecord    `gorm:"foreignKey:UserID" json:"shared_records,omitempty"`
+	Preferences       *UserPreference   `gorm:"foreignKey:UserID" json:"preferences,omitempty"`
+	EmergencyContacts []EmergencyContact `gorm:"foreignKey:UserID" json:"emergency_contacts,omitempty"`
+}
+
+// UserEvent is a real-time event for a user, kept for a while so clients can
+// resume their event stream with Last-Event-ID
+type UserEvent struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Type      string    `gorm:"not null" json:"type"`
+	Data      string    `gorm:"type:text" json:"data"` // JSON payload
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// UserPreference holds a user's timezone and notification settings
+type UserPreference struct {
+	ID                     uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID                 uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex" json:"user_id"`
+	Timezone               string     `gorm:"not null;default:UTC" json:"timezone"` // IANA timezone
+	Channels               string     `gorm:"type:text" json:"channels"` // JSON object: notification kind -> ["email", "sms"]
+	QuietHoursStart        string     `json:"quiet_hours_start"` // HH:MM local time, empty = no quiet hours
+	QuietHoursEnd          string     `json:"quiet_hours_end"`
+	AppointmentLeadMinutes int        `gorm:"default:1440" json:"appointment_lead_minutes"`
+	RefillLeadDays         int        `gorm:"default:7" json:"refill_lead_days"`
+	DeliveryMode           string     `gorm:"default:immediate" json:"delivery_mode"` // immediate, digest
+	DigestHour             int        `gorm:"default:8" json:"digest_hour"` // local hour the daily digest is sent
+	LastDigestAt           *time.Time `json:"last_digest_at"`
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
 }
 
 // HealthInsurance stores insurance information
@@ -65,6 +103,7 @@ type Prescription struct {
 	AttachmentURL     string    `json:"attachment_url"` // S3 URL for PDF/photo
 	AttachmentType    string    `json:"attachment_type"` // pdf, jpg, png
 	IsActive          bool      `gorm:"default:true" json:"is_active"`
+	Tags              Tags      `gorm:"type:jsonb;not null;default:'[]'" json:"tags"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -84,6 +123,8 @@ type Appointment struct {
 	Notes             string    `gorm:"type:text" json:"notes"`
 	IsCompleted       bool      `gorm:"default:false" json:"is_completed"`
 	ReminderSent      bool      `gorm:"default:false" json:"reminder_sent"`
+	ExternalUID       string    `gorm:"index" json:"external_uid,omitempty"` // iCalendar UID when imported from an .ics file
+	Tags              Tags      `gorm:"type:jsonb;not null;default:'[]'" json:"tags"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -101,6 +142,7 @@ type LabReport struct {
 	ReportURL         string    `gorm:"not null" json:"report_url"` // S3 URL
 	ReportType        string    `json:"report_type"` // pdf, jpg, png
 	Notes             string    `gorm:"type:text" json:"notes"`
+	Tags              Tags      `gorm:"type:jsonb;not null;default:'[]'" json:"tags"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -121,6 +163,7 @@ type Medication struct {
 	LastRefillDate    *Date     `gorm:"type:date" json:"last_refill_date"`
 	NextRefillDate    *Date     `gorm:"type:date" json:"next_refill_date"`
 	RefillReminderDays int      `gorm:"default:7" json:"refill_reminder_days"`
+	RefillReminderSentFor *Date `gorm:"type:date" json:"-"` // NextRefillDate the last refill notification was for
 	IsActive          bool      `gorm:"default:true" json:"is_active"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
@@ -139,7 +182,20 @@ type Reminder struct {
 	ReminderType      string    `json:"reminder_type"` // checkup, vaccination, test, etc.
 	IsCompleted       bool      `gorm:"default:false" json:"is_completed"`
 	IsRecurring       bool      `gorm:"default:false" json:"is_recurring"`
-	RecurrenceInterval string   `json:"recurrence_interval"` // monthly, quarterly, yearly
+	RecurrenceInterval string   `json:"recurrence_interval"` // monthly, quarterly, yearly (legacy, used when RecurrenceRule is empty)
+	RecurrenceRule    string    `gorm:"type:text" json:"recurrence_rule"` // RFC 5545 RRULE, e.g. FREQ=WEEKLY;BYDAY=MO,TH;COUNT=10
+	RecurrenceExceptions string `gorm:"type:text" json:"recurrence_exceptions"` // JSON array of skipped occurrence datetimes
+	Timezone          string    `json:"timezone"` // IANA timezone the reminder's wall-clock time is in
+	SeriesID          *uuid.UUID `gorm:"type:uuid;index" json:"series_id"` // first reminder of a recurring series
+	SeriesStart       DateTime  `gorm:"type:timestamp" json:"series_start"` // DTSTART of the series, used for COUNT
+	ReminderSent      bool      `gorm:"default:false" json:"reminder_sent"`
+	NotifiedAt        *time.Time `json:"notified_at"` // when the due notification went out
+	SnoozedUntil      *time.Time `gorm:"index" json:"snoozed_until"`
+	AcknowledgedAt    *time.Time `json:"acknowledged_at"`
+	DismissedAt       *time.Time `json:"dismissed_at"`
+	IsCritical        bool      `gorm:"default:false" json:"is_critical"` // escalate to emergency contacts if not acknowledged
+	EscalateAfterMinutes int    `gorm:"default:0" json:"escalate_after_minutes"`
+	EscalatedAt       *time.Time `json:"escalated_at"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -147,21 +203,70 @@ type Reminder struct {
 	User              User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
 
+// ReminderEvent is the history of what happened to a reminder
+type ReminderEvent struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ReminderID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"reminder_id"`
+	UserID            uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Action            string     `gorm:"not null" json:"action"` // notified, snoozed, acknowledged, dismissed, completed, skipped, escalated
+	SnoozedUntil      *time.Time `json:"snoozed_until,omitempty"`
+	Details           string     `gorm:"type:text" json:"details,omitempty"`
+	CreatedAt         time.Time  `gorm:"index" json:"created_at"`
+}
+
+// EmergencyContact is a caregiver or relative notified when a critical
+// reminder goes unacknowledged
+type EmergencyContact struct {
+	ID                 uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID             uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name               string    `gorm:"not null" json:"name" binding:"required"`
+	Relationship       string    `json:"relationship"` // caregiver, spouse, parent, etc.
+	Email              string    `json:"email"`
+	Phone              string    `json:"phone"`
+	NotifyOnEscalation bool      `gorm:"default:true" json:"notify_on_escalation"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
 // SharedRecord represents a shared medical record with time-limited access
 type SharedRecord struct {
 	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	UserID            uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
-	ShareToken        string    `gorm:"uniqueIndex;not null" json:"share_token"`
-	RecordType        string    `gorm:"not null" json:"record_type"` // prescription, appointment, lab_report, bundle
-	RecordIDs         string    `gorm:"type:text" json:"record_ids"` // JSON array of record IDs
+	TokenHash         string    `gorm:"uniqueIndex" json:"-"` // SHA-256 of the share token; the token itself is only shown at creation
+	TokenPrefix       string    `json:"token_prefix"` // first characters of the token, to tell links apart
+	RecordType        string    `gorm:"not null" json:"record_type"` // prescription, appointment, lab_report, insurance, bundle
+	RecordIDs         string    `gorm:"type:text" json:"record_ids"` // JSON array of {type, id} references; empty for query shares
+	ShareMode         string    `gorm:"not null;default:static" json:"share_mode"` // static: the records in RecordIDs; query: whatever QueryFilter matches when viewed
+	QueryFilter       string    `gorm:"type:text" json:"query_filter,omitempty"` // JSON share query
 	ExpiresAt         time.Time `gorm:"not null;index" json:"expires_at"`
 	MaxAccessCount    int       `gorm:"default:0" json:"max_access_count"` // 0 = unlimited
 	CurrentAccessCount int      `gorm:"default:0" json:"current_access_count"`
 	AllowDownload     bool      `gorm:"default:false" json:"allow_download"`
 	RecipientEmail    string    `json:"recipient_email"`
 	RecipientPhone    string    `json:"recipient_phone"`
-	ShareMethod       string    `json:"share_method"` // email, sms, link
+	RecipientUserID   *uuid.UUID `gorm:"type:uuid;index" json:"-"` // account an in-app share was sent to; hidden so owners can't tell which emails have one
+	RecipientStatus   string    `json:"recipient_status,omitempty"` // pending, accepted, declined; in-app shares only
+	RespondedAt       *time.Time `json:"responded_at,omitempty"`
+	ShareMethod       string    `json:"share_method"` // email, sms, link, user (in-app, to a registered account)
+	DeliveryID        *uuid.UUID `gorm:"type:uuid" json:"delivery_id,omitempty"` // latest email/SMS delivery of the link
+	DeliveryStatus    string    `json:"delivery_status,omitempty"` // pending, retrying, sent, failed; empty for link shares
+	DeliveryError     string    `gorm:"type:text" json:"delivery_error,omitempty"`
+	DeliveredAt       *time.Time `json:"delivered_at,omitempty"`
+	SendCount         int       `gorm:"default:0" json:"send_count"`
+	Protection        string    `gorm:"default:none" json:"protection"` // none, pin, otp
+	PINHash           string    `json:"-"`
+	FailedAttempts    int       `gorm:"default:0" json:"failed_attempts"`
+	Lockouts          int       `gorm:"default:0" json:"lockouts"` // times wrong PINs or codes have locked the link; each lockout is twice as long
+	LockedUntil       *time.Time `json:"locked_until,omitempty"`
+	Redaction         string    `gorm:"type:text" json:"redaction,omitempty"` // JSON redaction profile; fields the viewer can't see
+	NotifyOwnerOnAccess bool    `gorm:"default:false" json:"notify_owner_on_access"` // tell the owner when the link is first opened and first downloaded from
+	FirstAccessedAt   *time.Time `json:"first_accessed_at,omitempty"`
+	FirstDownloadedAt *time.Time `json:"first_downloaded_at,omitempty"`
 	IsActive          bool      `gorm:"default:true" json:"is_active"`
+	DeactivatedAt     *time.Time `json:"deactivated_at,omitempty"`
+	DeactivationReason string   `json:"deactivation_reason,omitempty"` // revoked, expired, exhausted
+	Status            string    `gorm:"-" json:"status,omitempty"` // active, expired, exhausted, revoked; filled in when listing
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 
@@ -176,8 +281,132 @@ type AuditLog struct {
 	IPAddress         string    `json:"ip_address"`
 	UserAgent         string    `json:"user_agent"`
 	AccessedAt        time.Time `gorm:"not null" json:"accessed_at"`
-	Action            string    `json:"action"` // viewed, downloaded
+	Action            string    `json:"action"` // viewed, previewed, downloaded, exported
+	RecordType        string    `json:"record_type,omitempty"` // record whose file was previewed or downloaded
+	RecordID          *uuid.UUID `gorm:"type:uuid" json:"record_id,omitempty"`
+	ViewerUserID      *uuid.UUID `gorm:"type:uuid;index" json:"viewer_user_id,omitempty"` // signed-in recipient of an in-app share
 
 	SharedRecord      SharedRecord `gorm:"foreignKey:SharedRecordID" json:"shared_record,omitempty"`
 }
 
+// OneTimeCode is a short numeric code sent to prove control of an email
+// address or phone number. Only a hash of the code is stored.
+type OneTimeCode struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Purpose           string    `gorm:"not null;index:idx_one_time_code_subject" json:"purpose"` // e.g. share_access
+	SubjectID         uuid.UUID `gorm:"type:uuid;not null;index:idx_one_time_code_subject" json:"subject_id"` // share or user the code unlocks
+	Target            string    `json:"target"` // email address or phone number the code was sent to
+	CodeHash          string    `gorm:"not null" json:"-"`
+	Attempts          int       `gorm:"default:0" json:"attempts"`
+	ExpiresAt         time.Time `gorm:"not null" json:"expires_at"`
+	ConsumedAt        *time.Time `json:"consumed_at,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// AuthSession is one sign-in on one device: a family of refresh tokens, each
+// replacing the last. Access tokens name their session, so revoking it signs
+// the device out straight away.
+type AuthSession struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	IPAddress         string    `json:"ip_address"`
+	UserAgent         string    `json:"user_agent"`
+	LastUsedAt        time.Time `json:"last_used_at"` // last sign-in or refresh
+	ExpiresAt         time.Time `gorm:"not null;index" json:"expires_at"` // when the current refresh token expires
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason     string    `json:"revoked_reason,omitempty"` // logout, reuse_detected, password_changed
+	MFAVerifiedAt     *time.Time `json:"mfa_verified_at,omitempty"` // when a second factor was checked for this session
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// RefreshToken is one opaque refresh token of a session. Only a hash of the
+// token is stored. A token is used once; presenting it again revokes the
+// session.
+type RefreshToken struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	SessionID         uuid.UUID `gorm:"type:uuid;not null;index" json:"session_id"`
+	TokenHash         string    `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt         time.Time `gorm:"not null;index" json:"expires_at"`
+	UsedAt            *time.Time `json:"used_at,omitempty"` // when it was exchanged for the next token
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// MFARecoveryCode is a one-time code that stands in for an authenticator
+// code. Only a hash of the code is stored.
+type MFARecoveryCode struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash          string    `gorm:"not null" json:"-"`
+	UsedAt            *time.Time `json:"used_at,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use token from a password reset link. Only
+// a hash of the token is stored.
+type PasswordResetToken struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	TokenHash         string    `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt         time.Time `gorm:"not null;index" json:"expires_at"`
+	UsedAt            *time.Time `json:"used_at,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// WebhookEndpoint is a URL that receives signed event payloads. Endpoints
+// without a user are global (admin-managed) and receive every user's events.
+type WebhookEndpoint struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      *uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	URL         string     `gorm:"not null" json:"url"`
+	Description string     `json:"description"`
+	EventTypes  string     `gorm:"type:text;not null" json:"event_types"` // JSON array of event types, "*" for all
+	Secret      string     `gorm:"not null" json:"-"` // HMAC signing key
+	IsActive    bool       `gorm:"default:true" json:"is_active"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// WebhookDelivery is one event sent (or being retried) to one endpoint
+type WebhookDelivery struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	EndpointID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"endpoint_id"`
+	EventID        int64      `gorm:"index" json:"event_id"` // UserEvent ID; 0 for test deliveries
+	EventType      string     `gorm:"not null" json:"event_type"`
+	Payload        string     `gorm:"type:text;not null" json:"payload"`
+	Status         string     `gorm:"not null;default:pending;index" json:"status"` // pending, retrying, delivered, dead
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	MaxAttempts    int        `gorm:"default:8" json:"max_attempts"`
+	NextAttemptAt  *time.Time `gorm:"index" json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `gorm:"type:text" json:"response_body"` // truncated
+	Error          string     `gorm:"type:text" json:"error"`
+	CreatedAt      time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// NotificationDelivery is the delivery log for outbound email and SMS
+type NotificationDelivery struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID            *uuid.UUID `gorm:"type:uuid;index" json:"user_id"` // account the notification was sent on behalf of
+	Channel           string     `gorm:"not null" json:"channel"` // email, sms
+	Recipient         string     `gorm:"not null" json:"recipient"`
+	Subject           string     `json:"subject"`
+	Body              string     `gorm:"type:text" json:"-"` // one-time codes and links in it are redacted
+	Redacted          bool       `gorm:"default:false" json:"redacted"` // secrets were kept out of the log, so only this process can retry it
+	Kind              string     `gorm:"index" json:"kind"` // share_link, reminder_due, appointment_upcoming, ...
+	RelatedType       string     `json:"related_type"` // shared_record, reminder, appointment
+	RelatedID         *uuid.UUID `gorm:"type:uuid;index" json:"related_id"`
+	Status            string     `gorm:"not null;default:pending;index" json:"status"` // pending, retrying, sent, failed
+	Attempts          int        `gorm:"default:0" json:"attempts"`
+	MaxAttempts       int        `gorm:"default:5" json:"max_attempts"`
+	NextAttemptAt     *time.Time `gorm:"index" json:"next_attempt_at"`
+	LastAttemptAt     *time.Time `json:"last_attempt_at"`
+	SentAt            *time.Time `json:"sent_at"`
+	ProviderMessageID string     `json:"provider_message_id"`
+	Error             string     `gorm:"type:text" json:"error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
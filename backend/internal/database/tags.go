@@ -0,0 +1,74 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tag limits
+const (
+	MaxTags      = 20
+	MaxTagLength = 32
+)
+
+// Tags are free-form labels on a record, e.g. "dental" or "cardiology",
+// stored as a JSON array. Tags are lowercase and unique.
+type Tags []string
+
+// Normalize lowercases and trims the tags, drops empty and duplicate ones,
+// and checks the limits
+func (t Tags) Normalize() (Tags, error) {
+	normalized := Tags{}
+	seen := make(map[string]bool, len(t))
+	for _, tag := range t {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > MaxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, MaxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > MaxTags {
+		return nil, fmt.Errorf("a record can have at most %d tags", MaxTags)
+	}
+	return normalized, nil
+}
+
+// Value implements driver.Valuer for database storage
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	encoded, err := json.Marshal([]string(t))
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// Scan implements sql.Scanner for database retrieval
+func (t *Tags) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*t = Tags{}
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", value)
+	}
+	var tags []string
+	if err := json.Unmarshal(raw, &tags); err != nil {
+		return err
+	}
+	*t = tags
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package events fans out per-user events to connected clients. Events are
+// persisted by the caller and handed to a Broker, either directly or through
+// the PostgreSQL LISTEN/NOTIFY bridge when several replicas are running.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types
+const (
+	ReminderDue         = "reminder.due"
+	ShareAccessed       = "share.accessed"
+	ShareReceived       = "share.received"  // a share was sent to the user's inbox
+	ShareResponded      = "share.responded" // the recipient of an in-app share accepted or declined it
+	PrescriptionCreated = "prescription.created"
+	PrescriptionUpdated = "prescription.updated"
+	AppointmentCreated  = "appointment.created"
+	AppointmentUpdated  = "appointment.updated"
+	LabReportCreated    = "lab_report.created"
+	LabReportUpdated    = "lab_report.updated"
+	ExportReady         = "export.ready"
+)
+
+// Types lists the event types clients and webhooks can subscribe to
+var Types = []string{
+	ReminderDue,
+	ShareAccessed,
+	ShareReceived,
+	ShareResponded,
+	PrescriptionCreated,
+	PrescriptionUpdated,
+	AppointmentCreated,
+	AppointmentUpdated,
+	LabReportCreated,
+	LabReportUpdated,
+	ExportReady,
+}
+
+// Event is a single notification for one user. IDs increase monotonically so
+// clients can resume with Last-Event-ID.
+type Event struct {
+	ID        int64           `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// subscriberBuffer is how many events a slow client may fall behind before it
+// is disconnected; it can catch up by reconnecting with Last-Event-ID
+const subscriberBuffer = 64
+
+// Subscription receives a user's events until Close is called or the broker
+// drops it for falling behind, in which case C is closed
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	userID uuid.UUID
+	broker *Broker
+	once   sync.Once
+}
+
+// Close unsubscribes; it is safe to call more than once
+func (s *Subscription) Close() {
+	s.broker.remove(s)
+}
+
+// Broker fans events out to the subscriptions of their user
+type Broker struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[uuid.UUID]map[*Subscription]struct{})}
+}
+
+// Subscribe registers a new subscription for the user's events
+func (b *Broker) Subscribe(userID uuid.UUID) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, userID: userID, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+// Dispatch delivers the event to every subscription of its user without
+// blocking. Subscriptions whose buffer is full are dropped.
+func (b *Broker) Dispatch(e Event) {
+	b.mu.Lock()
+	var slow []*Subscription
+	for sub := range b.subs[e.UserID] {
+		select {
+		case sub.ch <- e:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, sub := range slow {
+		sub.Close()
+	}
+}
+
+// Subscribers returns the number of open subscriptions
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, subs := range b.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	sub.once.Do(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if subs, ok := b.subs[sub.userID]; ok {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(b.subs, sub.userID)
+			}
+		}
+		close(sub.ch)
+	})
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Listen holds a connection from the pool, LISTENs on channel and calls
+// handle with each notification's payload. It reconnects with backoff until
+// ctx is cancelled. onConnect runs after every successful LISTEN, so callers
+// can catch up on anything missed while disconnected.
+func Listen(ctx context.Context, db *sql.DB, channel string, onConnect func(), handle func(payload string)) {
+	wait := time.Second
+	for {
+		err := listenOnce(ctx, db, channel, func() {
+			wait = time.Second
+			if onConnect != nil {
+				onConnect()
+			}
+		}, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Event listener on %q stopped: %v; reconnecting in %s", channel, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if wait < time.Minute {
+			wait *= 2
+		}
+	}
+}
+
+func listenOnce(ctx context.Context, db *sql.DB, channel string, onConnect func(), handle func(payload string)) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("LISTEN requires the pgx driver")
+		}
+		pgConn := stdConn.Conn()
+
+		if _, err := pgConn.Exec(ctx, fmt.Sprintf("LISTEN %q", channel)); err != nil {
+			return err
+		}
+		onConnect()
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				// Discard the connection rather than returning a LISTENing
+				// session to the pool
+				return fmt.Errorf("%w: %v", driver.ErrBadConn, err)
+			}
+			handle(notification.Payload)
+		}
+	})
+}
@@ -1,25 +1,38 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"medical-records-app/internal/auth"
 	"medical-records-app/internal/config"
+	"medical-records-app/internal/database"
 	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
-	userService *services.UserService
-	config      *config.Config
+	userService         *services.UserService
+	sessionService      *services.SessionService
+	verificationService *services.VerificationService
+	passwordService     *services.PasswordService
+	mfaService          *services.MFAService
+	config              *config.Config
 }
 
-func NewAuthHandler(userService *services.UserService, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(userService *services.UserService, sessionService *services.SessionService, verificationService *services.VerificationService, passwordService *services.PasswordService, mfaService *services.MFAService, cfg *config.Config) *AuthHandler {
 	auth.SetJWTSecret(cfg.JWT.Secret)
 	return &AuthHandler{
-		userService: userService,
-		config:      cfg,
+		userService:         userService,
+		sessionService:      sessionService,
+		verificationService: verificationService,
+		passwordService:     passwordService,
+		mfaService:          mfaService,
+		config:              cfg,
 	}
 }
 
@@ -36,9 +49,46 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// VerifyEmailRequest carries the token from an email verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ChangePhoneRequest carries the user's new phone number; empty removes it
+type ChangePhoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+// VerifyPhoneRequest carries the code texted to the user's phone
+type VerifyPhoneRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ForgotPasswordRequest names the account to send a reset link for
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest sets a new password with a reset link token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// ChangePasswordRequest replaces the signed-in user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+// RefreshRequest carries the refresh token to exchange or revoke
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 // Register handles user registration
 // @Summary Register a new user
-// @Description Create a new user account
+// @Description Create a new user account and email a link to verify the address
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -59,33 +109,32 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		status := http.StatusInternalServerError
 		if err.Error() == "user with this email already exists" {
 			status = http.StatusConflict
+		} else if errors.Is(err, services.ErrInvalidPhone) {
+			status = http.StatusBadRequest
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role, h.config.JWT.ExpirationHours)
+	tokens, err := h.sessionService.StartSession(user, c.ClientIP(), c.GetHeader("User-Agent"), false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "User registered successfully",
-		"user": gin.H{
-			"id":         user.ID,
-			"email":      user.Email,
-			"first_name": user.FirstName,
-			"last_name":  user.LastName,
-			"role":       user.Role,
-		},
-		"token": token,
-	})
+	response := sessionResponse("User registered successfully", user, tokens)
+	_, err = h.verificationService.SendEmailVerification(user)
+	if err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+	response["email_verification_sent"] = err == nil
+
+	c.JSON(http.StatusCreated, response)
 }
 
 // Login handles user login
 // @Summary Login user
-// @Description Authenticate user and return JWT token
+// @Description Authenticate user and return a short-lived access token and a refresh token. Users with two-factor authentication get mfa_required and an mfa_token instead, to exchange with a code at /auth/mfa/verify.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -106,23 +155,388 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role, h.config.JWT.ExpirationHours)
+	if user.MFAEnabled {
+		challenge, expiresAt, err := h.mfaService.StartChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Enter a code from your authenticator app",
+			"mfa_required":   true,
+			"mfa_token":      challenge,
+			"mfa_expires_at": expiresAt,
+		})
+		return
+	}
+
+	tokens, err := h.sessionService.StartSession(user, c.ClientIP(), c.GetHeader("User-Agent"), false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	c.JSON(http.StatusOK, sessionResponse("Login successful", user, tokens))
+}
+
+// Refresh exchanges a refresh token for new tokens
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token works once; presenting a used one again signs the whole session out, on the assumption that it was stolen.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest true "Refresh token"
+// @Success 200 {object} services.TokenPair
+// @Failure 401 {object} map[string]string
+// @Router /auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(req.RefreshToken, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout signs the session out
+// @Summary Logout
+// @Description Sign out the session a refresh token belongs to. Its refresh token and any access tokens issued for it stop working immediately.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest true "Refresh token of the session"
+// @Success 200 {object} map[string]string
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sessionService.Logout(req.RefreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// VerifyEmail verifies the user's email address
+// @Summary Verify email address
+// @Description Confirm an email address with the token from a verification link. The link only works for the address it was sent to.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body VerifyEmailRequest true "Verification token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /auth/verify-email [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.verificationService.VerifyEmail(req.Token)
+	if err != nil {
+		respondVerificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Email address verified",
+		"email":             user.Email,
+		"is_email_verified": user.IsEmailVerified,
+	})
+}
+
+// ResendEmailVerification emails the user a new verification link
+// @Summary Resend verification email
+// @Description Email a new verification link to the authenticated user. Limited to one a minute and a few a day by default.
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/verify-email/resend [post]
+func (h *AuthHandler) ResendEmailVerification(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if _, err := h.verificationService.SendEmailVerification(user); err != nil {
+		respondVerificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// ChangePhone replaces the user's phone number
+// @Summary Change phone number
+// @Description Replace the authenticated user's phone number, or remove it with an empty phone. A new number must be verified before SMS notifications are sent to it.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body ChangePhoneRequest true "New phone number"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /auth/phone [put]
+func (h *AuthHandler) ChangePhone(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req ChangePhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.verificationService.ChangePhone(userID, req.Phone)
+	if err != nil {
+		respondVerificationError(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
+		"phone":             user.Phone,
+		"is_phone_verified": user.IsPhoneVerified,
+	})
+}
+
+// SendPhoneCode texts the user a code to verify their phone number
+// @Summary Send phone verification code
+// @Description Text a one-time code to the authenticated user's phone number. Codes expire after a few minutes and allow five guesses; sending is limited to one a minute and a few a day by default.
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/verify-phone/send [post]
+func (h *AuthHandler) SendPhoneCode(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	sentTo, err := h.verificationService.SendPhoneCode(user)
+	if err != nil {
+		respondVerificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Verification code sent",
+		"sent_to": sentTo,
+	})
+}
+
+// VerifyPhone verifies the user's phone number
+// @Summary Verify phone number
+// @Description Confirm the authenticated user's phone number with the code texted to it
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body VerifyPhoneRequest true "Verification code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /auth/verify-phone [post]
+func (h *AuthHandler) VerifyPhone(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req VerifyPhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.verificationService.VerifyPhone(userID, req.Code)
+	if err != nil {
+		respondVerificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Phone number verified",
+		"phone":             user.Phone,
+		"is_phone_verified": user.IsPhoneVerified,
+	})
+}
+
+func respondVerificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidVerificationLink), errors.Is(err, services.ErrInvalidCode),
+		errors.Is(err, services.ErrInvalidPhone), errors.Is(err, services.ErrNoPhone):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailAlreadyVerified), errors.Is(err, services.ErrPhoneAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrVerificationCooldown), errors.Is(err, services.ErrVerificationLimit),
+		errors.Is(err, services.ErrCodeCooldown):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ForgotPassword emails a password reset link
+// @Summary Request password reset
+// @Description Email a single-use password reset link if the address belongs to an account. The response is the same whether it does or not.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Account email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Failures would tell whether the account exists, so they're only logged
+	if err := h.passwordService.RequestReset(req.Email); err != nil {
+		log.Printf("Failed to request password reset: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a reset link is on its way"})
+}
+
+// ResetPassword sets a new password with a reset link
+// @Summary Reset password
+// @Description Set a new password with the token from a reset link. The link works once, and every session of the account is signed out.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passwordService.ResetPassword(req.Token, req.Password); err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset; log in with your new password"})
+}
+
+// ChangePassword replaces the user's password
+// @Summary Change password
+// @Description Change the authenticated user's password. Every session is signed out, including this one; the response carries tokens for a new session.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/password/change [post]
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passwordService.ChangePassword(userID, req.CurrentPassword, req.NewPassword); err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return
+	}
+	// The new session keeps this one's second factor, if it had one
+	tokens, err := h.sessionService.StartSession(user, c.ClientIP(), c.GetHeader("User-Agent"), c.GetBool("mfa_verified"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionResponse("Password changed", user, tokens))
+}
+
+func respondPasswordError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidResetToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+	}
+}
+
+// sessionResponse is the body returned when a user signs in
+func sessionResponse(message string, user *database.User, tokens *services.TokenPair) gin.H {
+	return gin.H{
+		"message": message,
 		"user": gin.H{
-			"id":         user.ID,
-			"email":      user.Email,
-			"first_name": user.FirstName,
-			"last_name":  user.LastName,
-			"role":       user.Role,
+			"id":                user.ID,
+			"email":             user.Email,
+			"first_name":        user.FirstName,
+			"last_name":         user.LastName,
+			"role":              user.Role,
+			"is_email_verified": user.IsEmailVerified,
+			"is_phone_verified": user.IsPhoneVerified,
+			"mfa_enabled":       user.MFAEnabled,
 		},
-		"token": token,
-	})
+		"token":              tokens.AccessToken,
+		"expires_at":         tokens.AccessExpiresAt,
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+	}
 }
 
 // GetProfile returns the current user's profile
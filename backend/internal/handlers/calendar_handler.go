@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"bytes"
+	"medical-records-app/internal/config"
+	"medical-records-app/internal/events"
+	"medical-records-app/internal/ical"
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CalendarHandler struct {
+	calendarService *services.CalendarService
+	eventService    *services.EventService
+	config          *config.Config
+}
+
+func NewCalendarHandler(calendarService *services.CalendarService, eventService *services.EventService, cfg *config.Config) *CalendarHandler {
+	return &CalendarHandler{
+		calendarService: calendarService,
+		eventService:    eventService,
+		config:          cfg,
+	}
+}
+
+// GetSubscription returns the status of the calendar feed
+// @Summary Get calendar subscription
+// @Description Report whether an iCalendar feed token is active. The feed URL is only shown when the token is created.
+// @Tags calendar
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /calendar/subscription [get]
+func (h *CalendarHandler) GetSubscription(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	enabled, createdAt, err := h.calendarService.GetFeedStatus(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":    enabled,
+		"created_at": createdAt,
+	})
+}
+
+// RotateSubscription creates or replaces the calendar feed token
+// @Summary Rotate calendar feed token
+// @Description Issue a new secret feed URL for appointments and reminders. Any previous URL stops working.
+// @Tags calendar
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /calendar/subscription/rotate [post]
+func (h *CalendarHandler) RotateSubscription(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	token, err := h.calendarService.RotateFeedToken(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	feedURL := h.config.Server.PublicURL + "/api/v1/calendar/feed/" + token + ".ics"
+	c.JSON(http.StatusOK, gin.H{
+		"feed_url":   feedURL,
+		"webcal_url": "webcal://" + strings.TrimPrefix(strings.TrimPrefix(feedURL, "https://"), "http://"),
+		"message":    "Store this URL now; it will not be shown again",
+	})
+}
+
+// DisableSubscription turns the calendar feed off
+// @Summary Disable calendar feed
+// @Description Remove the feed token so existing calendar subscriptions stop updating
+// @Tags calendar
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /calendar/subscription [delete]
+func (h *CalendarHandler) DisableSubscription(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.calendarService.DisableFeed(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed disabled"})
+}
+
+// GetFeed serves the iCalendar subscription feed
+// @Summary Calendar feed
+// @Description iCalendar feed of the token owner's appointments and reminders
+// @Tags calendar
+// @Produce text/calendar
+// @Param token path string true "Feed token (optionally ending in .ics)"
+// @Success 200 {string} string
+// @Failure 404 {object} map[string]string
+// @Router /calendar/feed/{token} [get]
+func (h *CalendarHandler) GetFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	user, err := h.calendarService.GetUserByFeedToken(token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	calendar, err := h.calendarService.BuildFeed(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Feeds carry medical details; keep them out of shared caches
+	c.Header("Cache-Control", "private, no-store")
+	writeCalendar(c, calendar, "")
+}
+
+// GetAppointmentICS downloads a single appointment as .ics
+// @Summary Download appointment .ics
+// @Description Download one appointment as an iCalendar file
+// @Tags appointments
+// @Security BearerAuth
+// @Produce text/calendar
+// @Param id path string true "Appointment ID"
+// @Success 200 {string} string
+// @Failure 404 {object} map[string]string
+// @Router /appointments/{id}/ics [get]
+func (h *CalendarHandler) GetAppointmentICS(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	appointmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid appointment ID"})
+		return
+	}
+
+	calendar, err := h.calendarService.GetAppointmentCalendar(userID, appointmentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+
+	writeCalendar(c, calendar, "appointment-"+appointmentID.String()+".ics")
+}
+
+// GetReminderICS downloads a single reminder as .ics
+// @Summary Download reminder .ics
+// @Description Download one reminder (with its recurrence) as an iCalendar file
+// @Tags reminders
+// @Security BearerAuth
+// @Produce text/calendar
+// @Param id path string true "Reminder ID"
+// @Success 200 {string} string
+// @Failure 404 {object} map[string]string
+// @Router /reminders/{id}/ics [get]
+func (h *CalendarHandler) GetReminderICS(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	reminderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return
+	}
+
+	calendar, err := h.calendarService.GetReminderCalendar(userID, reminderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	writeCalendar(c, calendar, "reminder-"+reminderID.String()+".ics")
+}
+
+// maxICSUploadBytes bounds .ics uploads; invites are a few kilobytes
+const maxICSUploadBytes = 2 << 20
+
+// PreviewAppointmentImport parses an .ics upload without saving it
+// @Summary Preview .ics import
+// @Description Parse an iCalendar file (single or multi-event) and show the appointments it would create, flagging duplicates. A repeating event becomes one appointment on its first occurrence and carries a warning.
+// @Tags appointments
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "iCalendar file"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /appointments/import/preview [post]
+func (h *CalendarHandler) PreviewAppointmentImport(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	events, ok := parseICSUpload(c)
+	if !ok {
+		return
+	}
+
+	items, err := h.calendarService.PreviewAppointmentImport(userID, events)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": items})
+}
+
+// ImportAppointments imports appointments from an .ics upload
+// @Summary Import .ics appointments
+// @Description Create appointments from an iCalendar file. Duplicates and cancelled events are skipped, and a repeating event only creates its first occurrence; pass uids to import only selected events from the preview.
+// @Tags appointments
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "iCalendar file"
+// @Param uids formData []string false "UIDs to import (default: all new events)"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /appointments/import [post]
+func (h *CalendarHandler) ImportAppointments(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	calendarEvents, ok := parseICSUpload(c)
+	if !ok {
+		return
+	}
+
+	items, err := h.calendarService.ImportAppointments(userID, calendarEvents, c.PostFormArray("uids"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	imported := 0
+	for _, item := range items {
+		if item.Status == services.ImportImported {
+			imported++
+			h.eventService.Publish(userID, events.AppointmentCreated, gin.H{
+				"record_type": "appointment",
+				"record_id":   item.AppointmentID,
+				"actor_id":    userID,
+			})
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":     items,
+		"imported": imported,
+	})
+}
+
+// parseICSUpload reads the "file" form field as iCalendar data
+func parseICSUpload(c *gin.Context) ([]ical.Event, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxICSUploadBytes)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An .ics file is required in the \"file\" field"})
+		return nil, false
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+		return nil, false
+	}
+	defer file.Close()
+
+	events, err := ical.Parse(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid iCalendar file: " + err.Error()})
+		return nil, false
+	}
+	return events, true
+}
+
+// writeCalendar renders the calendar, as an attachment when filename is set
+func writeCalendar(c *gin.Context, calendar *ical.Calendar, filename string) {
+	var buf bytes.Buffer
+	if err := calendar.Write(&buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render calendar"})
+		return
+	}
+	if filename != "" {
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	}
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", buf.Bytes())
+}
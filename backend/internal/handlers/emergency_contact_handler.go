@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type EmergencyContactHandler struct {
+	contactService *services.EmergencyContactService
+}
+
+func NewEmergencyContactHandler(contactService *services.EmergencyContactService) *EmergencyContactHandler {
+	return &EmergencyContactHandler{contactService: contactService}
+}
+
+// CreateContact adds an emergency contact
+// @Summary Create emergency contact
+// @Description Add a caregiver or relative to notify when a critical reminder goes unacknowledged
+// @Tags emergency-contacts
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param contact body database.EmergencyContact true "Contact details"
+// @Success 201 {object} database.EmergencyContact
+// @Failure 400 {object} map[string]string
+// @Router /emergency-contacts [post]
+func (h *EmergencyContactHandler) CreateContact(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	contact := database.EmergencyContact{NotifyOnEscalation: true}
+	if err := c.ShouldBindJSON(&contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.contactService.CreateContact(userID, &contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, contact)
+}
+
+// GetContacts lists emergency contacts
+// @Summary Get emergency contacts
+// @Description Get the authenticated user's emergency contacts
+// @Tags emergency-contacts
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /emergency-contacts [get]
+func (h *EmergencyContactHandler) GetContacts(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	contacts, err := h.contactService.GetContacts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": contacts})
+}
+
+// UpdateContact updates an emergency contact
+// @Summary Update emergency contact
+// @Description Replace an emergency contact's details
+// @Tags emergency-contacts
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Contact ID"
+// @Param contact body database.EmergencyContact true "Contact details"
+// @Success 200 {object} database.EmergencyContact
+// @Failure 404 {object} map[string]string
+// @Router /emergency-contacts/{id} [put]
+func (h *EmergencyContactHandler) UpdateContact(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	contactID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	if _, err := h.contactService.GetContactByID(userID, contactID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Emergency contact not found"})
+		return
+	}
+
+	contact := database.EmergencyContact{NotifyOnEscalation: true}
+	if err := c.ShouldBindJSON(&contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.contactService.UpdateContact(userID, contactID, &contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.contactService.GetContactByID(userID, contactID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteContact removes an emergency contact
+// @Summary Delete emergency contact
+// @Description Remove an emergency contact
+// @Tags emergency-contacts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Contact ID"
+// @Success 200 {object} map[string]string
+// @Router /emergency-contacts/{id} [delete]
+func (h *EmergencyContactHandler) DeleteContact(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	contactID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+
+	if err := h.contactService.DeleteContact(userID, contactID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Emergency contact deleted"})
+}
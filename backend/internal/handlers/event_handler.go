@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"medical-records-app/internal/events"
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// streamHeartbeat keeps idle connections open through proxies. The session is
+// checked again at the same interval.
+const streamHeartbeat = 25 * time.Second
+
+type EventHandler struct {
+	eventService   *services.EventService
+	sessionService *services.SessionService
+}
+
+func NewEventHandler(eventService *services.EventService, sessionService *services.SessionService) *EventHandler {
+	return &EventHandler{eventService: eventService, sessionService: sessionService}
+}
+
+// Stream pushes the user's events as Server-Sent Events
+// @Summary Event stream
+// @Description Server-Sent Events stream of reminder.due, share.accessed, record created/updated and export.ready events. Reconnect with the Last-Event-ID header (or last_event_id query) to receive missed events. Browsers may pass the JWT as access_token since EventSource can't set headers. The stream closes when the access token expires or its session is signed out.
+// @Tags events
+// @Security BearerAuth
+// @Produce text/event-stream
+// @Param access_token query string false "JWT, for clients that can't send an Authorization header"
+// @Param last_event_id query int false "Resume after this event ID"
+// @Success 200 {string} string
+// @Router /events/stream [get]
+func (h *EventHandler) Stream(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	sessionID, err := uuid.Parse(c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	lastID := int64(0)
+	if v := c.GetHeader("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseInt(v, 10, 64)
+	} else if v := c.Query("last_event_id"); v != "" {
+		lastID, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	// Subscribe before replaying so nothing published in between is lost;
+	// live events that were also replayed are skipped below. Only those:
+	// IDs are committed out of order, so a lower ID can still arrive live.
+	sub := h.eventService.Subscribe(userID)
+	defer sub.Close()
+
+	var backlog []events.Event
+	if lastID > 0 {
+		backlog, err = h.eventService.Replay(userID, lastID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprint(c.Writer, "retry: 5000\n\n")
+	replayed := make(map[int64]bool, len(backlog))
+	for _, e := range backlog {
+		writeEvent(c.Writer, e)
+		replayed[e.ID] = true
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	// The token can't be checked again once the stream is open, so the
+	// stream ends when it expires
+	var expired <-chan time.Time
+	if expiresAt := c.GetTime("token_expires_at"); !expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-expired:
+			return
+		case <-heartbeat.C:
+			if _, err := h.sessionService.CheckSession(userID, sessionID); err != nil {
+				if errors.Is(err, services.ErrSessionRevoked) {
+					return
+				}
+				log.Printf("Failed to check session %s of event stream: %v", sessionID, err)
+			}
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case e, open := <-sub.C:
+			if !open {
+				// Dropped for falling behind; the client reconnects and
+				// replays from its Last-Event-ID
+				return
+			}
+			if replayed[e.ID] {
+				delete(replayed, e.ID)
+				continue
+			}
+			writeEvent(c.Writer, e)
+			c.Writer.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, e events.Event) {
+	data, _ := json.Marshal(gin.H{
+		"id":         e.ID,
+		"type":       e.Type,
+		"data":       e.Data,
+		"created_at": e.CreatedAt,
+	})
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"errors"
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MFACodeRequest carries a code from the user's authenticator app or one of
+// their recovery codes
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAVerifyRequest completes a two-step login
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// DisableMFARequest needs both factors to turn the second one off
+type DisableMFARequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// VerifyMFA completes a two-step login
+// @Summary Complete two-factor login
+// @Description Exchange the mfa_token from /auth/login and a code from the authenticator app, or a recovery code, for a session. Five wrong codes lock two-factor checks for 15 minutes.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body MFAVerifyRequest true "Challenge token and code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/mfa/verify [post]
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.mfaService.CompleteChallenge(req.MFAToken, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	tokens, err := h.sessionService.StartSession(user, c.ClientIP(), c.GetHeader("User-Agent"), true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionResponse("Login successful", user, tokens))
+}
+
+// GetMFAStatus returns the user's two-factor setup
+// @Summary Get two-factor status
+// @Description Whether two-factor authentication is on, and how many unused recovery codes are left
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} services.MFAStatus
+// @Router /auth/mfa [get]
+func (h *AuthHandler) GetMFAStatus(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.mfaService.GetStatus(userID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollMFA starts two-factor enrollment
+// @Summary Start two-factor enrollment
+// @Description Create a TOTP secret for the authenticated user. Add it to an authenticator app, from the otpauth URI as a QR code or by typing the secret, then confirm with a code.
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} services.TOTPEnrollment
+// @Failure 409 {object} map[string]string
+// @Router /auth/mfa/enroll [post]
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	enrollment, err := h.mfaService.BeginEnrollment(user)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFA turns two-factor authentication on
+// @Summary Confirm two-factor enrollment
+// @Description Turn two-factor authentication on with a code from the new secret. Returns ten one-time recovery codes, which are only shown once. The current session counts as two-factor verified.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body MFACodeRequest true "Authenticator code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /auth/mfa/enroll/confirm [post]
+func (h *AuthHandler) ConfirmMFA(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmEnrollment(userID, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	h.markSessionMFAVerified(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns two-factor authentication off
+// @Summary Disable two-factor authentication
+// @Description Turn two-factor authentication off. Needs the password and a current authenticator or recovery code.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body DisableMFARequest true "Password and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /auth/mfa/disable [post]
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req DisableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfaService.Disable(userID, req.Password, req.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes with ten new ones, after checking a current authenticator or recovery code. The old codes stop working.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body MFACodeRequest true "Authenticator or recovery code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /auth/mfa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// StepUpMFA verifies a second factor for the current session
+// @Summary Step up to two-factor
+// @Description Check an authenticator or recovery code so the current session can use features that require two-factor authentication
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body MFACodeRequest true "Authenticator or recovery code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/mfa/step-up [post]
+func (h *AuthHandler) StepUpMFA(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := h.mfaService.VerifyCode(user, req.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if !h.markSessionMFAVerified(c) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication verified"})
+}
+
+// markSessionMFAVerified records a second factor on the caller's session
+func (h *AuthHandler) markSessionMFAVerified(c *gin.Context) bool {
+	sessionID, err := uuid.Parse(c.GetString("session_id"))
+	if err != nil {
+		return false
+	}
+	return h.sessionService.MarkMFAVerified(sessionID) == nil
+}
+
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFAChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrMFANotEnrolling):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled), errors.Is(err, services.ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationHandler struct {
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(notificationService *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{notificationService: notificationService}
+}
+
+// GetDeliveries retrieves the notification delivery log
+// @Summary Get notification deliveries
+// @Description Get the email/SMS delivery log for the authenticated user, including status, attempts and provider message IDs
+// @Tags notifications
+// @Security BearerAuth
+// @Produce json
+// @Param limit query int false "Limit" default(20)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {object} map[string]interface{}
+// @Router /notifications/deliveries [get]
+func (h *NotificationHandler) GetDeliveries(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	deliveries, total, err := h.notificationService.GetDeliveries(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   deliveries,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
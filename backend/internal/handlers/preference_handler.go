@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PreferenceHandler struct {
+	preferenceService *services.PreferenceService
+}
+
+func NewPreferenceHandler(preferenceService *services.PreferenceService) *PreferenceHandler {
+	return &PreferenceHandler{preferenceService: preferenceService}
+}
+
+// GetPreferences retrieves the user's notification preferences
+// @Summary Get preferences
+// @Description Get the authenticated user's timezone and notification preferences. Defaults are returned until they are changed.
+// @Tags preferences
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} database.UserPreference
+// @Router /preferences [get]
+func (h *PreferenceHandler) GetPreferences(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	prefs, err := h.preferenceService.GetPreferences(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdatePreferences updates the user's notification preferences
+// @Summary Update preferences
+// @Description Update timezone, channels per notification kind (reminder_due, appointment_upcoming, medication_refill), quiet hours, lead times and digest settings. Omitted fields are left unchanged.
+// @Tags preferences
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param preferences body services.UpdatePreferencesRequest true "Preference changes"
+// @Success 200 {object} database.UserPreference
+// @Failure 400 {object} map[string]string
+// @Router /preferences [put]
+func (h *PreferenceHandler) UpdatePreferences(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	var req services.UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs, err := h.preferenceService.UpdatePreferences(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"medical-records-app/internal/database"
+	"medical-records-app/internal/events"
 	"medical-records-app/internal/services"
 	"medical-records-app/internal/utils"
 	"net/http"
@@ -13,10 +14,24 @@ import (
 
 type RecordHandler struct {
 	recordService *services.RecordService
+	eventService  *services.EventService
 }
 
-func NewRecordHandler(recordService *services.RecordService) *RecordHandler {
-	return &RecordHandler{recordService: recordService}
+func NewRecordHandler(recordService *services.RecordService, eventService *services.EventService) *RecordHandler {
+	return &RecordHandler{
+		recordService: recordService,
+		eventService:  eventService,
+	}
+}
+
+// publishRecordEvent tells the owner's connected clients about a change. The
+// actor is whoever made the change, which need not be the owner.
+func (h *RecordHandler) publishRecordEvent(ownerID, actorID uuid.UUID, eventType, recordType string, recordID uuid.UUID) {
+	h.eventService.Publish(ownerID, eventType, gin.H{
+		"record_type": recordType,
+		"record_id":   recordID,
+		"actor_id":    actorID,
+	})
 }
 
 // CreatePrescription creates a new prescription
@@ -47,6 +62,7 @@ func (h *RecordHandler) CreatePrescription(c *gin.Context) {
 		return
 	}
 
+	h.publishRecordEvent(userID, userID, events.PrescriptionCreated, "prescription", prescription.ID)
 	c.JSON(http.StatusCreated, prescription)
 }
 
@@ -147,6 +163,7 @@ func (h *RecordHandler) UpdatePrescription(c *gin.Context) {
 		return
 	}
 
+	h.publishRecordEvent(userID, userID, events.PrescriptionUpdated, "prescription", prescriptionID)
 	c.JSON(http.StatusOK, gin.H{"message": "Prescription updated successfully"})
 }
 
@@ -207,6 +224,7 @@ func (h *RecordHandler) CreateAppointment(c *gin.Context) {
 		return
 	}
 
+	h.publishRecordEvent(userID, userID, events.AppointmentCreated, "appointment", appointment.ID)
 	c.JSON(http.StatusCreated, appointment)
 }
 
@@ -273,6 +291,7 @@ func (h *RecordHandler) CreateLabReport(c *gin.Context) {
 		return
 	}
 
+	h.publishRecordEvent(userID, userID, events.LabReportCreated, "lab_report", labReport.ID)
 	c.JSON(http.StatusCreated, labReport)
 }
 
@@ -6,8 +6,10 @@ import (
 	"medical-records-app/internal/utils"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type ReminderHandler struct {
@@ -40,6 +42,11 @@ func (h *ReminderHandler) CreateReminder(c *gin.Context) {
 		return
 	}
 
+	if err := h.reminderService.ValidateRecurrence(&reminder); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := h.reminderService.CreateReminder(userID, &reminder); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -98,3 +105,303 @@ func (h *ReminderHandler) GetUpcomingReminders(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": reminders})
 }
 
+// CompleteReminder marks a reminder as completed
+// @Summary Complete reminder
+// @Description Mark a reminder as completed. For recurring reminders the next upcoming occurrence is created automatically; occurrences that have already passed are not.
+// @Tags reminders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Reminder ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /reminders/{id}/complete [post]
+func (h *ReminderHandler) CompleteReminder(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	reminderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return
+	}
+
+	if _, err := h.reminderService.GetReminderByID(userID, reminderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	completed, next, err := h.reminderService.CompleteReminder(userID, reminderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reminder":        completed,
+		"next_occurrence": next,
+	})
+}
+
+type SkipOccurrenceRequest struct {
+	Occurrence database.DateTime `json:"occurrence" binding:"required"`
+}
+
+// SkipOccurrence skips one occurrence of a recurring reminder
+// @Summary Skip reminder occurrence
+// @Description Add an exception (EXDATE) for one occurrence of a recurring reminder. The time must be an occurrence of the series that hasn't been skipped yet.
+// @Tags reminders
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Reminder ID"
+// @Param request body SkipOccurrenceRequest true "Occurrence to skip, in the reminder's timezone"
+// @Success 200 {object} database.Reminder
+// @Failure 400 {object} map[string]string
+// @Router /reminders/{id}/skip [post]
+func (h *ReminderHandler) SkipOccurrence(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	reminderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return
+	}
+
+	var req SkipOccurrenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.reminderService.GetReminderByID(userID, reminderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	reminder, err := h.reminderService.SkipOccurrence(userID, reminderID, req.Occurrence.Time)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reminder)
+}
+
+// SnoozeReminderRequest sets either a duration in minutes or an absolute time
+type SnoozeReminderRequest struct {
+	Minutes int        `json:"minutes"`
+	Until   *time.Time `json:"until"`
+}
+
+// SnoozeReminder postpones a reminder's notification
+// @Summary Snooze reminder
+// @Description Hold back a reminder's notification for a number of minutes or until a given time (at most 7 days). The reminder is notified again when the snooze ends.
+// @Tags reminders
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Reminder ID"
+// @Param request body SnoozeReminderRequest true "Snooze duration or end time"
+// @Success 200 {object} database.Reminder
+// @Failure 400 {object} map[string]string
+// @Router /reminders/{id}/snooze [post]
+func (h *ReminderHandler) SnoozeReminder(c *gin.Context) {
+	userID, reminderID, ok := reminderParams(c)
+	if !ok {
+		return
+	}
+
+	var req SnoozeReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var until time.Time
+	switch {
+	case req.Until != nil && req.Minutes != 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either minutes or until, not both"})
+		return
+	case req.Until != nil:
+		until = *req.Until
+	case req.Minutes > 0:
+		until = time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide a positive number of minutes or an until time"})
+		return
+	}
+
+	if _, err := h.reminderService.GetReminderByID(userID, reminderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	reminder, err := h.reminderService.SnoozeReminder(userID, reminderID, until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reminder)
+}
+
+// AcknowledgeReminder records that a reminder was seen
+// @Summary Acknowledge reminder
+// @Description Mark a reminder as seen. Acknowledged critical reminders are not escalated to emergency contacts.
+// @Tags reminders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Reminder ID"
+// @Success 200 {object} database.Reminder
+// @Failure 400 {object} map[string]string
+// @Router /reminders/{id}/acknowledge [post]
+func (h *ReminderHandler) AcknowledgeReminder(c *gin.Context) {
+	userID, reminderID, ok := reminderParams(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.reminderService.GetReminderByID(userID, reminderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	reminder, err := h.reminderService.AcknowledgeReminder(userID, reminderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reminder)
+}
+
+// DismissReminder closes a reminder without completing it
+// @Summary Dismiss reminder
+// @Description Dismiss a reminder's current occurrence without completing it. Recurring reminders move on to their next occurrence.
+// @Tags reminders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Reminder ID"
+// @Success 200 {object} database.Reminder
+// @Failure 400 {object} map[string]string
+// @Router /reminders/{id}/dismiss [post]
+func (h *ReminderHandler) DismissReminder(c *gin.Context) {
+	userID, reminderID, ok := reminderParams(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.reminderService.GetReminderByID(userID, reminderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	reminder, err := h.reminderService.DismissReminder(userID, reminderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reminder)
+}
+
+// GetReminderHistory lists what happened to a reminder
+// @Summary Get reminder history
+// @Description Get notifications, snoozes, acknowledgements, dismissals, completions and escalations of a reminder (or its whole recurring series), newest first
+// @Tags reminders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Reminder ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /reminders/{id}/history [get]
+func (h *ReminderHandler) GetReminderHistory(c *gin.Context) {
+	userID, reminderID, ok := reminderParams(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.reminderService.GetReminderHistory(userID, reminderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events})
+}
+
+func reminderParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	reminderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, reminderID, true
+}
+
+// GetOccurrences lists expanded reminder occurrences in a date range
+// @Summary Get reminder occurrences
+// @Description Expand recurring and one-off reminders into occurrences between from and to (at most 366 days apart)
+// @Tags reminders
+// @Security BearerAuth
+// @Produce json
+// @Param from query string false "Range start (YYYY-MM-DD or RFC3339)" default(now)
+// @Param to query string false "Range end (YYYY-MM-DD or RFC3339)" default(from + 30 days)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /reminders/occurrences [get]
+func (h *ReminderHandler) GetOccurrences(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	from := time.Now()
+	if v := c.Query("from"); v != "" {
+		t, err := parseRangeBound(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+			return
+		}
+		from = t
+	}
+	to := from.AddDate(0, 0, 30)
+	if v := c.Query("to"); v != "" {
+		t, err := parseRangeBound(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+			return
+		}
+		to = t
+	}
+	if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from and at most 366 days later"})
+		return
+	}
+
+	occurrences, err := h.reminderService.GetOccurrences(userID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": occurrences,
+		"from": from,
+		"to":   to,
+	})
+}
+
+func parseRangeBound(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"errors"
+	"medical-records-app/internal/events"
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetInbox lists the shares sent to the user in-app
+// @Summary Get shared-with-me inbox
+// @Description List the in-app shares other users sent to the authenticated user, newest first, with who sent them, how many records they hold and whether they are still available
+// @Tags sharing
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "pending, accepted, declined or all (default)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /sharing/inbox [get]
+func (h *SharingHandler) GetInbox(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	inbox, err := h.sharingService.GetInbox(userID, c.Query("status"))
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": inbox})
+}
+
+// AcceptShare accepts a share sent to the user in-app
+// @Summary Accept share
+// @Description Accept an in-app share so its records can be viewed. A declined share can still be accepted while it is available.
+// @Tags sharing
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Share Record ID"
+// @Success 200 {object} services.InboxShare
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /sharing/inbox/{id}/accept [post]
+func (h *SharingHandler) AcceptShare(c *gin.Context) {
+	h.respondToShare(c, true)
+}
+
+// DeclineShare declines a share sent to the user in-app
+// @Summary Decline share
+// @Description Decline an in-app share. Its records can no longer be viewed unless it is accepted again.
+// @Tags sharing
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Share Record ID"
+// @Success 200 {object} services.InboxShare
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /sharing/inbox/{id}/decline [post]
+func (h *SharingHandler) DeclineShare(c *gin.Context) {
+	h.respondToShare(c, false)
+}
+
+// respondToShare records the recipient's answer and tells the owner
+func (h *SharingHandler) respondToShare(c *gin.Context, accept bool) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	share, err := h.sharingService.RespondToShare(userID, shareID, accept)
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+	h.eventService.Publish(share.OwnerID, events.ShareResponded, gin.H{
+		"share_id":         share.ID,
+		"recipient_status": share.RecipientStatus,
+	})
+
+	c.JSON(http.StatusOK, share)
+}
+
+// GetInboxShare opens a share sent to the user in-app
+// @Summary View shared-with-me records
+// @Description View the records of an accepted in-app share. Each view counts toward the share's view limit and is logged for the owner like a link view, with the viewer's account. File URLs point at the inbox file routes.
+// @Tags sharing
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Share Record ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /sharing/inbox/{id} [get]
+func (h *SharingHandler) GetInboxShare(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	sharedRecord, err := h.sharingService.GetAcceptedShare(userID, shareID)
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+	owner := sharedRecord.User
+
+	sharedRecord, err = h.sharingService.RecordAccess(sharedRecord.ID, c.ClientIP(), c.GetHeader("User-Agent"), services.AccessViewed, &userID)
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+	sharedRecord.User = owner
+	h.eventService.Publish(sharedRecord.UserID, events.ShareAccessed, gin.H{
+		"share_id":     sharedRecord.ID,
+		"record_type":  sharedRecord.RecordType,
+		"action":       services.AccessViewed,
+		"access_count": sharedRecord.CurrentAccessCount,
+		"viewer_id":    userID,
+	})
+
+	share, err := h.sharingService.InboxShareOf(sharedRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := h.sharingService.GetInboxRecords(sharedRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"share":          share,
+		"records":        records,
+		"allow_download": sharedRecord.AllowDownload,
+	})
+}
+
+// PreviewInboxFile shows a file from a share sent to the user in-app
+// @Summary Preview shared-with-me file
+// @Description View a prescription attachment or lab report file from an accepted in-app share inline. Only PDFs and images can be previewed.
+// @Tags sharing
+// @Security BearerAuth
+// @Produce application/pdf,image/png,image/jpeg
+// @Param id path string true "Share Record ID"
+// @Param recordType path string true "prescription or lab_report"
+// @Param recordId path string true "Record ID"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Router /sharing/inbox/{id}/files/{recordType}/{recordId} [get]
+func (h *SharingHandler) PreviewInboxFile(c *gin.Context) {
+	h.serveInboxFile(c, false)
+}
+
+// DownloadInboxFile downloads a file from a share sent to the user in-app
+// @Summary Download shared-with-me file
+// @Description Download a prescription attachment or lab report file from an accepted in-app share. Only allowed when the share has allow_download set.
+// @Tags sharing
+// @Security BearerAuth
+// @Produce octet-stream
+// @Param id path string true "Share Record ID"
+// @Param recordType path string true "prescription or lab_report"
+// @Param recordId path string true "Record ID"
+// @Success 200 {file} file
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /sharing/inbox/{id}/files/{recordType}/{recordId}/download [get]
+func (h *SharingHandler) DownloadInboxFile(c *gin.Context) {
+	h.serveInboxFile(c, true)
+}
+
+func (h *SharingHandler) serveInboxFile(c *gin.Context, download bool) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+	recordID, err := uuid.Parse(c.Param("recordId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+
+	sharedRecord, err := h.sharingService.GetAcceptedShare(userID, shareID)
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+	if download && !sharedRecord.AllowDownload {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrDownloadNotAllowed.Error()})
+		return
+	}
+	h.streamSharedFile(c, sharedRecord, c.Param("recordType"), recordID, download, &userID)
+}
+
+// ExportInboxPacket downloads a share sent to the user in-app as one file
+// @Summary Download shared-with-me packet
+// @Description Download everything in an accepted in-app share as a PDF or ZIP packet. Requires allow_download. Each packet counts toward the share's view limit.
+// @Tags sharing
+// @Security BearerAuth
+// @Produce application/pdf,application/zip
+// @Param id path string true "Share Record ID"
+// @Param format query string false "pdf (default) or zip"
+// @Success 200 {file} file
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /sharing/inbox/{id}/packet [get]
+func (h *SharingHandler) ExportInboxPacket(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+	format, ok := packetFormat(c)
+	if !ok {
+		return
+	}
+
+	sharedRecord, err := h.sharingService.GetAcceptedShare(userID, shareID)
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+	if !sharedRecord.AllowDownload {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrDownloadNotAllowed.Error()})
+		return
+	}
+
+	// A packet counts as an access, so a used-up share can't be exported
+	sharedRecord, err = h.sharingService.RecordAccess(sharedRecord.ID, c.ClientIP(), c.GetHeader("User-Agent"), services.AccessExported, &userID)
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+	h.writePacket(c, sharedRecord, format, "recipient")
+}
+
+func respondInboxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+	case errors.Is(err, services.ErrShareGone):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrShareNotAccepted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidInboxStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
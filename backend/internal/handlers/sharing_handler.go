@@ -1,37 +1,55 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/events"
 	"medical-records-app/internal/services"
+	"medical-records-app/internal/storage"
 	"medical-records-app/internal/utils"
+	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type SharingHandler struct {
 	sharingService *services.SharingService
+	eventService   *services.EventService
 }
 
-func NewSharingHandler(sharingService *services.SharingService) *SharingHandler {
-	return &SharingHandler{sharingService: sharingService}
+func NewSharingHandler(sharingService *services.SharingService, eventService *services.EventService) *SharingHandler {
+	return &SharingHandler{
+		sharingService: sharingService,
+		eventService:   eventService,
+	}
 }
 
 type CreateShareRequest struct {
-	RecordType      string      `json:"record_type" binding:"required"`
-	RecordIDs       []uuid.UUID `json:"record_ids" binding:"required"`
+	RecordType      string      `json:"record_type"` // required unless query is set
+	RecordIDs       []uuid.UUID `json:"record_ids"` // records of record_type
+	Records         []services.RecordRef `json:"records"` // typed {type, id} references, for bundles
+	Query           *services.ShareQuery `json:"query"` // share whatever matches when viewed, instead of listing records
 	ExpiresInHours  int         `json:"expires_in_hours" binding:"required"`
 	MaxAccessCount  int         `json:"max_access_count"`
 	AllowDownload   bool        `json:"allow_download"`
 	RecipientEmail  string      `json:"recipient_email"`
 	RecipientPhone  string      `json:"recipient_phone"`
-	ShareMethod     string      `json:"share_method" binding:"required"` // email, sms, link
+	ShareMethod     string      `json:"share_method" binding:"required"` // email, sms, link, user
+	Protection      string      `json:"protection"` // none (default), pin, otp
+	PIN             string      `json:"pin"` // 6-12 digits, required for pin protection
+	Redaction       services.RedactionProfile `json:"redaction"` // fields the viewer can't see
+	NotifyOwnerOnAccess bool    `json:"notify_owner_on_access"` // notify the owner on first view and first download
 }
 
 // CreateShareLink creates a shareable link
 // @Summary Create share link
-// @Description Create a time-limited shareable link for medical records
+// @Description Create a time-limited shareable link for medical records. Only records owned by the caller can be shared; rejected IDs are listed in record_errors. Instead of listing records, a query (record types, a from/to date window or the last N days, and tags) shares whichever of the caller's records match it each time the link is opened. The link is only returned in this response. Email and SMS shares are sent to the recipient straight away. Links can be protected by a PIN or by one-time codes sent to the recipient. A redaction profile can hide notes, attachments and providers, mask insurance identifiers, or limit each record type to chosen fields; hidden fields never reach the viewer or packets. With notify_owner_on_access the owner is told, on their share_accessed channels, when the link is first opened and first downloaded from. With share_method user the records go to the account that has verified recipient_email instead: no link is returned, and the recipient accepts and views the share from their inbox. If no account has verified that address yet, the share waits until one does; the response is the same either way.
 // @Tags sharing
 // @Security BearerAuth
 // @Accept json
@@ -52,81 +70,474 @@ func (h *SharingHandler) CreateShareLink(c *gin.Context) {
 		return
 	}
 
-	sharedRecord, err := h.sharingService.CreateShareLink(
-		userID,
-		req.RecordType,
-		req.RecordIDs,
-		req.ExpiresInHours,
-		req.MaxAccessCount,
-		req.AllowDownload,
-		req.RecipientEmail,
-		req.RecipientPhone,
-		req.ShareMethod,
-	)
+	sharedRecord, token, err := h.sharingService.CreateShareLink(userID, services.ShareLinkOptions{
+		RecordType:     req.RecordType,
+		RecordIDs:      req.RecordIDs,
+		Records:        req.Records,
+		Query:          req.Query,
+		ExpiresInHours: req.ExpiresInHours,
+		MaxAccessCount: req.MaxAccessCount,
+		AllowDownload:  req.AllowDownload,
+		RecipientEmail: req.RecipientEmail,
+		RecipientPhone: req.RecipientPhone,
+		ShareMethod:    req.ShareMethod,
+		Protection:     req.Protection,
+		PIN:            req.PIN,
+		Redaction:      req.Redaction,
+		NotifyOwnerOnAccess: req.NotifyOwnerOnAccess,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		var recordsErr *services.ShareRecordsError
+		if errors.As(err, &recordsErr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":         err.Error(),
+				"record_errors": recordsErr.Errors,
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// TODO: Send email/SMS if shareMethod is email or sms
+	if sharedRecord.ShareMethod == services.ShareMethodUser {
+		// In-app shares are opened from the recipient's inbox, not by link.
+		// The response is the same whether or not the recipient has an
+		// account yet.
+		if sharedRecord.RecipientUserID != nil {
+			h.eventService.Publish(*sharedRecord.RecipientUserID, events.ShareReceived, gin.H{
+				"share_id":    sharedRecord.ID,
+				"record_type": sharedRecord.RecordType,
+				"expires_at":  sharedRecord.ExpiresAt,
+			})
+		}
+		c.JSON(http.StatusCreated, gin.H{"shared_record": sharedRecord})
+		return
+	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	response := gin.H{
 		"shared_record": sharedRecord,
-		"share_url":     "/share/" + sharedRecord.ShareToken,
-	})
+		"share_token":   token,
+		"share_url":     h.sharingService.ShareURL(token),
+		"message":       "Store this link now; it will not be shown again",
+	}
+
+	// The share exists even if sending fails; the owner can resend it
+	delivery, err := h.sharingService.SendShareLink(sharedRecord, token)
+	if err != nil {
+		response["delivery_error"] = err.Error()
+	} else if delivery != nil {
+		response["delivery"] = delivery
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
 // GetSharedRecord retrieves a shared record by token
 // @Summary Get shared record
-// @Description Access a shared medical record using the share token
+// @Description Access a shared medical record using the share token. The share itself is described only by its record type, expiry, remaining uses, download setting and protection. Protected links also need the viewer session from /share/{token}/verify. File URLs in the records point at the share-scoped file routes. Links with a view limit also return a viewer session, carried in the file URLs, so the files of this view stay available for 30 minutes after the limit is reached.
 // @Tags sharing
 // @Produce json
 // @Param token path string true "Share Token"
+// @Param X-Share-Session header string false "Viewer session token for protected links"
 // @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
 // @Router /share/{token} [get]
 func (h *SharingHandler) GetSharedRecord(c *gin.Context) {
 	token := c.Param("token")
 
 	sharedRecord, err := h.sharingService.GetSharedRecordByToken(token)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found or expired"})
+		respondShareLookupError(c, err)
 		return
 	}
 
-	// Record access
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-	h.sharingService.RecordAccess(sharedRecord.ID, ipAddress, userAgent, "viewed")
+	if err := h.sharingService.CheckViewerSession(sharedRecord, shareSessionToken(c)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":      err.Error(),
+			"protection": sharedRecord.Protection,
+		})
+		return
+	}
 
-	// Parse record IDs from JSON
-	var recordIDs []uuid.UUID
-	if err := json.Unmarshal([]byte(sharedRecord.RecordIDs), &recordIDs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse record IDs"})
+	// Count the access; this is where concurrent viewers of a limited link
+	// are turned away
+	sharedRecord, err = h.sharingService.RecordAccess(sharedRecord.ID, c.ClientIP(), c.GetHeader("User-Agent"), services.AccessViewed, nil)
+	if err != nil {
+		respondShareLookupError(c, err)
 		return
 	}
+	h.eventService.Publish(sharedRecord.UserID, events.ShareAccessed, gin.H{
+		"share_id":     sharedRecord.ID,
+		"record_type":  sharedRecord.RecordType,
+		"action":       services.AccessViewed,
+		"access_count": sharedRecord.CurrentAccessCount,
+	})
+
+	response := gin.H{
+		"share":          services.LinkShareOf(sharedRecord),
+		"allow_download": sharedRecord.AllowDownload,
+	}
+
+	// Files of a limited link stay open to this view only, through a
+	// session carried in their URLs
+	var sessionToken string
+	if sharedRecord.MaxAccessCount > 0 {
+		var expiresAt time.Time
+		sessionToken, expiresAt, err = h.sharingService.IssueViewSession(sharedRecord)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start viewer session"})
+			return
+		}
+		response["session_token"] = sessionToken
+		response["session_expires_at"] = expiresAt
+	}
 
 	// Get the actual records
-	records, err := h.sharingService.GetRecordsByIDs(sharedRecord.RecordType, recordIDs)
+	records, err := h.sharingService.GetSharedRecords(sharedRecord, token, sessionToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	response["records"] = records
+
+	c.JSON(http.StatusOK, response)
+}
+
+// previewableTypes can be shown inline by browsers without running scripts
+var previewableTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+// PreviewSharedFile shows a shared record's file inline
+// @Summary Preview shared file
+// @Description View a prescription attachment or lab report file from a share inline. Works whether or not downloads are allowed; only PDFs and images can be previewed. Protected links need the viewer session as X-Share-Session or ?session=, as do links with a view limit, which use the session returned when the link is viewed.
+// @Tags sharing
+// @Produce application/pdf,image/png,image/jpeg
+// @Param token path string true "Share Token"
+// @Param recordType path string true "prescription or lab_report"
+// @Param id path string true "Record ID"
+// @Success 200 {file} file
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Router /share/{token}/files/{recordType}/{id} [get]
+func (h *SharingHandler) PreviewSharedFile(c *gin.Context) {
+	h.serveSharedFile(c, false)
+}
+
+// DownloadSharedFile downloads a shared record's file
+// @Summary Download shared file
+// @Description Download a prescription attachment or lab report file from a share. Only allowed when the share has allow_download set. Protected links need the viewer session as X-Share-Session or ?session=, as do links with a view limit, which use the session returned when the link is viewed.
+// @Tags sharing
+// @Produce octet-stream
+// @Param token path string true "Share Token"
+// @Param recordType path string true "prescription or lab_report"
+// @Param id path string true "Record ID"
+// @Success 200 {file} file
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /share/{token}/files/{recordType}/{id}/download [get]
+func (h *SharingHandler) DownloadSharedFile(c *gin.Context) {
+	h.serveSharedFile(c, true)
+}
+
+// serveSharedFile streams a shared file so its storage URL never reaches
+// the viewer
+func (h *SharingHandler) serveSharedFile(c *gin.Context, download bool) {
+	sharedRecord, err := h.sharingService.GetShareForFiles(c.Param("token"), shareSessionToken(c))
+	if err != nil {
+		respondShareLookupError(c, err)
+		return
+	}
+	if err := h.sharingService.CheckViewerSession(sharedRecord, shareSessionToken(c)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":      err.Error(),
+			"protection": sharedRecord.Protection,
+		})
+		return
+	}
+	if download && !sharedRecord.AllowDownload {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrDownloadNotAllowed.Error()})
+		return
+	}
+
+	recordID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid record ID"})
+		return
+	}
+	h.streamSharedFile(c, sharedRecord, c.Param("recordType"), recordID, download, nil)
+}
+
+// streamSharedFile sends one file of a share the viewer may open, logging
+// the access. viewerID is the signed-in recipient of an in-app share.
+func (h *SharingHandler) streamSharedFile(c *gin.Context, sharedRecord *database.SharedRecord, recordType string, recordID uuid.UUID, download bool, viewerID *uuid.UUID) {
+	file, err := h.sharingService.OpenSharedFile(c.Request.Context(), sharedRecord, recordType, recordID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, services.ErrNoAttachment), errors.Is(err, storage.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		default:
+			// The error may name the storage location, so keep it in the logs
+			log.Printf("Failed to open shared file %s/%s: %v", recordType, recordID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch file"})
+		}
+		return
+	}
+	defer file.Body.Close()
+
+	contentType, _, _ := mime.ParseMediaType(file.ContentType)
+	if !download && !previewableTypes[contentType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "This file type can't be previewed"})
+		return
+	}
+
+	action := services.AccessPreviewed
+	disposition := "inline"
+	if download {
+		action = services.AccessDownloaded
+		filename := recordType + "-" + recordID.String()
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			filename += exts[0]
+		}
+		disposition = `attachment; filename="` + filename + `"`
+	}
+	if err := h.sharingService.LogFileAccess(sharedRecord.ID, c.ClientIP(), c.GetHeader("User-Agent"), action, recordType, recordID, viewerID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record access"})
+		return
+	}
+	h.eventService.Publish(sharedRecord.UserID, events.ShareAccessed, gin.H{
+		"share_id":    sharedRecord.ID,
+		"record_type": recordType,
+		"record_id":   recordID,
+		"action":      action,
+	})
+
+	c.DataFromReader(http.StatusOK, file.Size, file.ContentType, file.Body, map[string]string{
+		"Content-Disposition":    disposition,
+		"Cache-Control":          "private, no-store",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// ExportSharedPacket downloads a share as one file
+// @Summary Download share packet
+// @Description Download everything in a share as one file: a PDF with a cover page, the records and their attachments, or a ZIP with a text summary and the original files. Requires allow_download. Each packet counts toward the link's view limit. Protected links need the viewer session as X-Share-Session or ?session=.
+// @Tags sharing
+// @Produce application/pdf,application/zip
+// @Param token path string true "Share Token"
+// @Param format query string false "pdf (default) or zip"
+// @Success 200 {file} file
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /share/{token}/packet [get]
+func (h *SharingHandler) ExportSharedPacket(c *gin.Context) {
+	format, ok := packetFormat(c)
+	if !ok {
+		return
+	}
+
+	// The packet is counted below, so it doesn't need a counted view first
+	sharedRecord, err := h.sharingService.GetSharedRecordByToken(c.Param("token"))
+	if err != nil {
+		respondShareLookupError(c, err)
+		return
+	}
+	if err := h.sharingService.CheckViewerSession(sharedRecord, shareSessionToken(c)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":      err.Error(),
+			"protection": sharedRecord.Protection,
+		})
+		return
+	}
+	if !sharedRecord.AllowDownload {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrDownloadNotAllowed.Error()})
+		return
+	}
+
+	// A packet counts as an access, so a used-up link can't be exported
+	sharedRecord, err = h.sharingService.RecordAccess(sharedRecord.ID, c.ClientIP(), c.GetHeader("User-Agent"), services.AccessExported, nil)
+	if err != nil {
+		respondShareLookupError(c, err)
+		return
+	}
+	h.writePacket(c, sharedRecord, format, "viewer")
+}
+
+// ExportMySharePacket downloads one of the user's shares as one file
+// @Summary Download my share packet
+// @Description Download the packet for one of your share links, exactly as the recipient would get it
+// @Tags sharing
+// @Security BearerAuth
+// @Produce application/pdf,application/zip
+// @Param id path string true "Share Record ID"
+// @Param format query string false "pdf (default) or zip"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Router /sharing/{id}/packet [get]
+func (h *SharingHandler) ExportMySharePacket(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+	format, ok := packetFormat(c)
+	if !ok {
+		return
+	}
+
+	sharedRecord, err := h.sharingService.GetOwnShare(userID, shareID)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+	h.writePacket(c, sharedRecord, format, "owner")
+}
+
+// writePacket streams the packet and tells the owner it was produced
+func (h *SharingHandler) writePacket(c *gin.Context, sharedRecord *database.SharedRecord, format, requestedBy string) {
+	doc, err := h.sharingService.BuildPacket(c.Request.Context(), sharedRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/pdf"
+	if format == services.PacketZIP {
+		contentType = "application/zip"
+	}
+	filename := "medical-records-" + doc.GeneratedAt.Format("2006-01-02") + "." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	// Headers are gone once the body starts, so failures can only be logged
+	if err := services.WritePacket(c.Writer, doc, format); err != nil {
+		log.Printf("Failed to write %s packet for share %s: %v", format, sharedRecord.ID, err)
+		return
+	}
+
+	h.eventService.Publish(sharedRecord.UserID, events.ExportReady, gin.H{
+		"share_id":     sharedRecord.ID,
+		"format":       format,
+		"requested_by": requestedBy,
+		"records":      doc.RecordCount(),
+		"attachments":  len(doc.Attachments),
+	})
+}
+
+// packetFormat reads ?format=, defaulting to pdf
+func packetFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", services.PacketPDF)
+	if format != services.PacketPDF && format != services.PacketZIP {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or zip"})
+		return "", false
+	}
+	return format, true
+}
+
+// RequestShareCode sends a one-time code for a protected share
+// @Summary Request share access code
+// @Description Send a one-time code to the recipient of an OTP-protected share link. Codes can be requested once a minute.
+// @Tags sharing
+// @Produce json
+// @Param token path string true "Share Token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /share/{token}/code [post]
+func (h *SharingHandler) RequestShareCode(c *gin.Context) {
+	sharedRecord, err := h.sharingService.GetSharedRecordByToken(c.Param("token"))
+	if err != nil {
+		respondShareLookupError(c, err)
+		return
+	}
+
+	channel, sentTo, err := h.sharingService.SendAccessCode(sharedRecord)
+	if err != nil {
+		respondShareVerifyError(c, sharedRecord, err)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"shared_record": sharedRecord,
-		"records":       records,
-		"allow_download": sharedRecord.AllowDownload,
+		"message": "Code sent",
+		"channel": channel,
+		"sent_to": sentTo,
+	})
+}
+
+// VerifyShareRequest carries the PIN or one-time code for a protected share
+type VerifyShareRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// VerifyShareAccess unlocks a protected share
+// @Summary Verify share PIN or code
+// @Description Check the PIN or one-time code for a protected share link and return a short-lived viewer session. Send it as the X-Share-Session header when fetching the share. Five wrong attempts lock the link for 15 minutes, and each lockout after that is twice as long. After five lockouts the link is disabled and its owner is told.
+// @Tags sharing
+// @Accept json
+// @Produce json
+// @Param token path string true "Share Token"
+// @Param request body VerifyShareRequest true "PIN or code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /share/{token}/verify [post]
+func (h *SharingHandler) VerifyShareAccess(c *gin.Context) {
+	sharedRecord, err := h.sharingService.GetSharedRecordByToken(c.Param("token"))
+	if err != nil {
+		respondShareLookupError(c, err)
+		return
+	}
+
+	var req VerifyShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessionToken, expiresAt, err := h.sharingService.VerifyShareAccess(sharedRecord, req.Code)
+	if err != nil {
+		respondShareVerifyError(c, sharedRecord, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_token": sessionToken,
+		"expires_at":    expiresAt,
 	})
 }
 
 // GetMySharedRecords gets all shared records created by the user
 // @Summary Get my shared records
-// @Description Get all share links created by the authenticated user
+// @Description Get all share links created by the authenticated user, newest first, each with its status. Links are identified by token_prefix; full tokens are never listed.
 // @Tags sharing
 // @Security BearerAuth
 // @Produce json
+// @Param status query string false "active, expired, exhausted, revoked or all (default)"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
 // @Router /sharing/my-shares [get]
 func (h *SharingHandler) GetMySharedRecords(c *gin.Context) {
 	userID, ok := utils.MustGetUserID(c)
@@ -134,8 +545,12 @@ func (h *SharingHandler) GetMySharedRecords(c *gin.Context) {
 		return
 	}
 
-	sharedRecords, err := h.sharingService.GetSharedRecordsByUser(userID)
+	sharedRecords, err := h.sharingService.GetSharedRecordsByUser(userID, c.Query("status"))
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidShareStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -143,6 +558,83 @@ func (h *SharingHandler) GetMySharedRecords(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": sharedRecords})
 }
 
+// GetSharingAnalytics returns access analytics across the user's shares
+// @Summary Get sharing analytics
+// @Description Totals across all of the user's share links (views, previews, downloads, unique viewers by IP address and user agent, average time to first view), a daily access timeline in the user's timezone, and a line per share with its remaining uses
+// @Tags sharing
+// @Security BearerAuth
+// @Produce json
+// @Param days query int false "Days of timeline (default 30, max 365)"
+// @Success 200 {object} services.SharingAnalytics
+// @Failure 400 {object} map[string]string
+// @Router /sharing/analytics [get]
+func (h *SharingHandler) GetSharingAnalytics(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	days, ok := analyticsDays(c)
+	if !ok {
+		return
+	}
+
+	analytics, err := h.sharingService.GetSharingAnalytics(userID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, analytics)
+}
+
+// GetShareAnalytics returns access analytics for one share
+// @Summary Get share analytics
+// @Description Views, previews, downloads, unique viewers, remaining uses and time to first view for one share link, with a daily access timeline and the most recent viewers
+// @Tags sharing
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Share Record ID"
+// @Param days query int false "Days of timeline (default 30, max 365)"
+// @Success 200 {object} services.ShareAnalytics
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /sharing/{id}/analytics [get]
+func (h *SharingHandler) GetShareAnalytics(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+	days, ok := analyticsDays(c)
+	if !ok {
+		return
+	}
+
+	analytics, err := h.sharingService.GetShareAnalytics(userID, shareID, days)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, analytics)
+}
+
+// analyticsDays reads ?days=, the length of the analytics timeline
+func analyticsDays(c *gin.Context) (int, bool) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return 0, false
+	}
+	return days, true
+}
+
 // RevokeShareLink revokes a share link
 // @Summary Revoke share link
 // @Description Revoke an active share link
@@ -164,6 +656,10 @@ func (h *SharingHandler) RevokeShareLink(c *gin.Context) {
 	}
 
 	if err := h.sharingService.RevokeShareLink(userID, shareID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -171,3 +667,153 @@ func (h *SharingHandler) RevokeShareLink(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked successfully"})
 }
 
+// UpdateShareLink changes a share's expiry, access limit or download permission
+// @Summary Update share link
+// @Description Extend or change a share's expiry (expires_at, or extend_by_hours from the current expiry), change max_access_count (0 = unlimited) or toggle allow_download. Omitted fields are left unchanged. Links that expired or were used up become active again if the change brings them back within their limits; revoked links can't be edited.
+// @Tags sharing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Share Record ID"
+// @Param request body services.UpdateShareRequest true "Fields to change"
+// @Success 200 {object} database.SharedRecord
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /sharing/{id} [put]
+func (h *SharingHandler) UpdateShareLink(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	var req services.UpdateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sharedRecord, err := h.sharingService.UpdateShareLink(userID, shareID, req)
+	if err != nil {
+		if errors.Is(err, services.ErrShareRevoked) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		respondShareError(c, err)
+		return
+	}
+	sharedRecord.Status = services.ShareStatus(sharedRecord)
+
+	c.JSON(http.StatusOK, sharedRecord)
+}
+
+// ResendShareRequest optionally corrects the recipient before resending
+type ResendShareRequest struct {
+	RecipientEmail string `json:"recipient_email"`
+	RecipientPhone string `json:"recipient_phone"`
+}
+
+// ResendShareLink sends a share link to its recipient again
+// @Summary Resend share link
+// @Description Email or text an active share link to its recipient again, optionally to a corrected address. The link gets a new token, so previously sent URLs stop working. Each share can be sent at most 5 times.
+// @Tags sharing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Share Record ID"
+// @Param request body ResendShareRequest false "Corrected recipient"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /sharing/{id}/resend [post]
+func (h *SharingHandler) ResendShareLink(c *gin.Context) {
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	var req ResendShareRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	sharedRecord, token, delivery, err := h.sharingService.ResendShareLink(userID, shareID, req.RecipientEmail, req.RecipientPhone)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shared_record": sharedRecord,
+		"share_url":     h.sharingService.ShareURL(token),
+		"delivery":      delivery,
+	})
+}
+
+func respondShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+	case errors.Is(err, services.ErrShareSendLimit):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// respondShareLookupError answers 410 for links that existed but can no
+// longer be used, 401 for files of limited links fetched without a counted
+// view, and 404 otherwise
+func respondShareLookupError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrShareViewRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrShareGone):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func respondShareVerifyError(c *gin.Context, sharedRecord *database.SharedRecord, err error) {
+	switch {
+	case errors.Is(err, services.ErrShareGone):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrShareLocked):
+		if sharedRecord.LockedUntil != nil {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(*sharedRecord.LockedUntil).Seconds())+1))
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCodeCooldown):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// shareSessionToken reads a protected share's viewer session from the
+// X-Share-Session header, or the session query parameter for plain links
+func shareSessionToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Share-Session"); token != "" {
+		return token
+	}
+	return c.Query("session")
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"errors"
+	"medical-records-app/internal/events"
+	"medical-records-app/internal/services"
+	"medical-records-app/internal/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// CreateEndpoint registers a webhook endpoint
+// @Summary Create webhook endpoint
+// @Description Register a URL to receive HMAC-signed JSON payloads for the given event types ("*" for all). The signing secret is only returned here and when rotated. Admins can pass scope=global to receive every user's events.
+// @Tags webhooks
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param scope query string false "user (default) or global (admin only)"
+// @Param endpoint body services.WebhookEndpointRequest true "Endpoint details"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /webhooks [post]
+func (h *WebhookHandler) CreateEndpoint(c *gin.Context) {
+	ownerID, ok := webhookScope(c)
+	if !ok {
+		return
+	}
+
+	var req services.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint, secret, err := h.webhookService.CreateEndpoint(ownerID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"endpoint": endpoint,
+		"secret":   secret,
+		"message":  "Store the secret now; it is used to verify the X-Webhook-Signature header and will not be shown again",
+	})
+}
+
+// GetEndpoints lists webhook endpoints
+// @Summary Get webhook endpoints
+// @Description List the user's webhook endpoints, or global ones with scope=global (admin only)
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param scope query string false "user (default) or global (admin only)"
+// @Success 200 {object} map[string]interface{}
+// @Router /webhooks [get]
+func (h *WebhookHandler) GetEndpoints(c *gin.Context) {
+	ownerID, ok := webhookScope(c)
+	if !ok {
+		return
+	}
+
+	endpoints, err := h.webhookService.GetEndpoints(ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":        endpoints,
+		"event_types": events.Types,
+	})
+}
+
+// GetEndpoint retrieves a webhook endpoint
+// @Summary Get webhook endpoint
+// @Description Get a webhook endpoint by ID
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Success 200 {object} database.WebhookEndpoint
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{id} [get]
+func (h *WebhookHandler) GetEndpoint(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+
+	endpoint, err := h.webhookService.GetEndpoint(ownerID, endpointID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook endpoint not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// UpdateEndpoint updates a webhook endpoint
+// @Summary Update webhook endpoint
+// @Description Replace an endpoint's URL, description and event types, or pause it with is_active=false
+// @Tags webhooks
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Param endpoint body services.WebhookEndpointRequest true "Endpoint details"
+// @Success 200 {object} database.WebhookEndpoint
+// @Failure 400 {object} map[string]string
+// @Router /webhooks/{id} [put]
+func (h *WebhookHandler) UpdateEndpoint(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+
+	var req services.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint, err := h.webhookService.UpdateEndpoint(ownerID, endpointID, req)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// DeleteEndpoint removes a webhook endpoint
+// @Summary Delete webhook endpoint
+// @Description Delete a webhook endpoint; pending retries are dead-lettered
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteEndpoint(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.DeleteEndpoint(ownerID, endpointID); err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook endpoint deleted"})
+}
+
+// RotateSecret issues a new signing secret
+// @Summary Rotate webhook secret
+// @Description Replace the endpoint's signing secret. Deliveries are signed with the new secret immediately.
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{id}/rotate-secret [post]
+func (h *WebhookHandler) RotateSecret(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+
+	secret, err := h.webhookService.RotateSecret(ownerID, endpointID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret})
+}
+
+// TestEndpoint sends a test event
+// @Summary Test webhook endpoint
+// @Description Send a signed webhook.test event to the endpoint and return the outcome of the first attempt
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Success 200 {object} database.WebhookDelivery
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{id}/test [post]
+func (h *WebhookHandler) TestEndpoint(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhookService.SendTest(ownerID, endpointID, userID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// GetDeliveries lists an endpoint's delivery history
+// @Summary Get webhook deliveries
+// @Description Get the delivery history of an endpoint, including response status, attempts and dead-lettered deliveries
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Param status query string false "pending, retrying, delivered or dead"
+// @Param limit query int false "Limit" default(20)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	deliveries, total, err := h.webhookService.GetDeliveries(ownerID, endpointID, c.Query("status"), limit, offset)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   deliveries,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// Redeliver retries a delivery
+// @Summary Redeliver webhook
+// @Description Send a dead-lettered (or already delivered) delivery again, with a fresh set of retry attempts
+// @Tags webhooks
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param deliveryId path string true "Delivery ID"
+// @Param scope query string false "user (default) or global (admin only)"
+// @Success 200 {object} database.WebhookDelivery
+// @Failure 400 {object} map[string]string
+// @Router /webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	ownerID, endpointID, ok := webhookParams(c)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(c.Param("deliveryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	delivery, err := h.webhookService.Redeliver(ownerID, endpointID, deliveryID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// webhookScope resolves whose endpoints the request manages: the caller's,
+// or the global ones (nil owner) for admins passing scope=global
+func webhookScope(c *gin.Context) (*uuid.UUID, bool) {
+	if c.Query("scope") == "global" {
+		if c.GetString("role") != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can manage global webhooks"})
+			return nil, false
+		}
+		return nil, true
+	}
+	userID, ok := utils.MustGetUserID(c)
+	if !ok {
+		return nil, false
+	}
+	return &userID, true
+}
+
+func webhookParams(c *gin.Context) (*uuid.UUID, uuid.UUID, bool) {
+	ownerID, ok := webhookScope(c)
+	if !ok {
+		return nil, uuid.Nil, false
+	}
+	endpointID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endpoint ID"})
+		return nil, uuid.Nil, false
+	}
+	return ownerID, endpointID, true
+}
+
+func respondWebhookError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook endpoint not found"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,357 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// property is one parsed content line
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// component is a BEGIN/END block with its properties and children
+type component struct {
+	name       string
+	properties []property
+	children   []*component
+}
+
+func (c *component) get(name string) (property, bool) {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return property{}, false
+}
+
+func (c *component) all(name string) []property {
+	var props []property
+	for _, p := range c.properties {
+		if p.name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Parse reads an iCalendar stream and returns its VEVENTs. A file may hold
+// several VCALENDARs and each may hold any number of events.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*component
+	var stack []*component
+	for _, line := range lines {
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch p.name {
+		case "BEGIN":
+			c := &component{name: strings.ToUpper(p.value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, c)
+			} else {
+				roots = append(roots, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("unexpected END:%s", p.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("property %s outside of a component", p.name)
+			}
+			current := stack[len(stack)-1]
+			current.properties = append(current.properties, p)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].name)
+	}
+
+	var events []Event
+	for _, root := range roots {
+		if root.name != "VCALENDAR" {
+			continue
+		}
+		zones := collectTimezones(root)
+		for _, child := range root.children {
+			if child.name != "VEVENT" {
+				continue
+			}
+			event, err := parseEvent(child, zones)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return nil, errors.New("no events found in calendar")
+	}
+	return events, nil
+}
+
+func parseEvent(c *component, zones map[string]*time.Location) (Event, error) {
+	var event Event
+
+	if p, ok := c.get("UID"); ok {
+		event.UID = p.value
+	}
+	if p, ok := c.get("SUMMARY"); ok {
+		event.Summary = unescapeText(p.value)
+	}
+	if p, ok := c.get("DESCRIPTION"); ok {
+		event.Description = unescapeText(p.value)
+	}
+	if p, ok := c.get("LOCATION"); ok {
+		event.Location = unescapeText(p.value)
+	}
+	if p, ok := c.get("STATUS"); ok {
+		event.Status = strings.ToUpper(p.value)
+	}
+	if p, ok := c.get("RRULE"); ok {
+		event.RRule = p.value
+	}
+	if p, ok := c.get("ORGANIZER"); ok {
+		event.Organizer = unquote(p.params["CN"])
+	}
+
+	start, ok := c.get("DTSTART")
+	if !ok {
+		return Event{}, fmt.Errorf("event %q has no DTSTART", event.UID)
+	}
+	t, err := parseTime(start, zones)
+	if err != nil {
+		return Event{}, fmt.Errorf("event %q: %w", event.UID, err)
+	}
+	event.Start = t
+
+	if end, ok := c.get("DTEND"); ok {
+		if t, err := parseTime(end, zones); err == nil {
+			event.End = t
+		}
+	}
+
+	for _, p := range c.all("EXDATE") {
+		for _, v := range strings.Split(p.value, ",") {
+			single := property{name: p.name, params: p.params, value: v}
+			if t, err := parseTime(single, zones); err == nil {
+				event.ExDates = append(event.ExDates, t)
+			}
+		}
+	}
+
+	return event, nil
+}
+
+// parseTime reads a DATE or DATE-TIME value, resolving TZID through the IANA
+// database, common Windows zone names, or the file's own VTIMEZONE
+func parseTime(p property, zones map[string]*time.Location) (time.Time, error) {
+	value := strings.TrimSpace(p.value)
+	if p.params["VALUE"] == "DATE" || len(value) == 8 {
+		return time.ParseInLocation("20060102", value, time.UTC)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(utcDateTimeLayout, value)
+	}
+
+	loc := time.UTC
+	if tzid := unquote(p.params["TZID"]); tzid != "" {
+		loc = resolveZone(tzid, zones)
+	}
+	return time.ParseInLocation(dateTimeLayout, value, loc)
+}
+
+// windowsZones maps the Windows zone names Outlook emits to IANA zones
+var windowsZones = map[string]string{
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"US Mountain Standard Time":      "America/Phoenix",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Alaskan Standard Time":          "America/Anchorage",
+	"Hawaiian Standard Time":         "Pacific/Honolulu",
+	"Atlantic Standard Time":         "America/Halifax",
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+	"New Zealand Standard Time":      "Pacific/Auckland",
+	"UTC":                            "UTC",
+	"Coordinated Universal Time":     "UTC",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"Arabian Standard Time":          "Asia/Dubai",
+	"Singapore Standard Time":        "Asia/Singapore",
+	"E. South America Standard Time": "America/Sao_Paulo",
+}
+
+func resolveZone(tzid string, zones map[string]*time.Location) *time.Location {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	if loc, ok := zones[tzid]; ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// collectTimezones turns each VTIMEZONE into a fixed-offset zone using its
+// most recent STANDARD observance (or its first observance if it has none).
+// It's only a fallback for TZIDs that are neither IANA nor known Windows
+// names, so DST isn't modelled.
+func collectTimezones(calendar *component) map[string]*time.Location {
+	zones := make(map[string]*time.Location)
+	for _, child := range calendar.children {
+		if child.name != "VTIMEZONE" {
+			continue
+		}
+		tzid, ok := child.get("TZID")
+		if !ok {
+			continue
+		}
+
+		var chosen *component
+		var chosenStart string
+		for _, obs := range child.children {
+			start, _ := obs.get("DTSTART")
+			switch {
+			case chosen == nil:
+				chosen, chosenStart = obs, start.value
+			case obs.name == "STANDARD" && (chosen.name != "STANDARD" || start.value > chosenStart):
+				chosen, chosenStart = obs, start.value
+			}
+		}
+		if chosen == nil {
+			continue
+		}
+		to, ok := chosen.get("TZOFFSETTO")
+		if !ok {
+			continue
+		}
+		if seconds, err := parseOffset(to.value); err == nil {
+			zones[tzid.value] = time.FixedZone(tzid.value, seconds)
+		}
+	}
+	return zones
+}
+
+func parseOffset(s string) (int, error) {
+	if len(s) < 5 {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+	var hours, minutes int
+	if _, err := fmt.Sscanf(s[1:5], "%02d%02d", &hours, &minutes); err != nil {
+		return 0, err
+	}
+	seconds := hours*3600 + minutes*60
+	if s[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// unfold joins folded continuation lines. A leading byte order mark, which
+// some Windows tools write, is dropped.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits "NAME;PARAM=VALUE;...:value", respecting quoted params
+func parseLine(line string) (property, error) {
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, fmt.Errorf("invalid content line %q", line)
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := splitParams(head)
+	p := property{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string),
+		value:  value,
+	}
+	for _, param := range parts[1:] {
+		if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+			p.params[strings.ToUpper(kv[0])] = kv[1]
+		}
+	}
+	return p, nil
+}
+
+func splitParams(head string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i, r := range head {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ';' && !inQuotes {
+			parts = append(parts, head[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, head[start:])
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
+
+var textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func unescapeText(s string) string {
+	return textUnescaper.Replace(s)
+}
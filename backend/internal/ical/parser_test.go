@@ -0,0 +1,383 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crlf turns a fixture written with LF line endings into a CRLF stream
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(s, "\n"), "\n", "\r\n")
+}
+
+const googleFixture = `
+BEGIN:VCALENDAR
+PRODID:-//Google Inc//Google Calendar 70.9054//EN
+VERSION:2.0
+BEGIN:VEVENT
+DTSTART;TZID=America/New_York:20240304T093000
+DTEND;TZID=America/New_York:20240304T100000
+RRULE:FREQ=WEEKLY;BYDAY=MO;UNTIL=20240401T135959Z
+EXDATE;TZID=America/New_York:20240311T093000,20240318T093000
+UID:abc123@google.com
+SUMMARY:Physio\, knee
+DESCRIPTION:Wear shorts\; bring the referral letter.\nParking is at the bac
+ k of the building. Folder: C:\\scans\\knee
+LOCATION:Main St 12\, Springfield
+STATUS:CONFIRMED
+END:VEVENT
+END:VCALENDAR
+`
+
+const outlookFixture = `
+BEGIN:VCALENDAR
+PRODID:-//Microsoft Corporation//Outlook 16.0 MIMEDIR//EN
+VERSION:2.0
+BEGIN:VTIMEZONE
+TZID:Eastern Standard Time
+BEGIN:STANDARD
+DTSTART:16011104T020000
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:16010311T020000
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+ORGANIZER;CN="Dr. Jones: Cardiology";SENT-BY="mailto:a@b.test":mailto:jones@clinic.test
+DTSTART;TZID="Eastern Standard Time":20240710T150000
+DTEND;TZID="Eastern Standard Time":20240710T153000
+UID:040000008200E00074C5B7101A82E008
+SUMMARY;LANGUAGE=en-us:Cardiology review
+END:VEVENT
+END:VCALENDAR
+`
+
+const customZoneFixture = `
+BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VTIMEZONE
+TZID:Clinic Local Time
+BEGIN:DAYLIGHT
+DTSTART:19700329T020000
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+END:DAYLIGHT
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:custom@test
+DTSTART;TZID=Clinic Local Time:20240115T080000
+SUMMARY:Blood test
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParseGoogle(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	events, err := Parse(strings.NewReader(crlf(googleFixture)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	e := events[0]
+
+	if e.UID != "abc123@google.com" {
+		t.Errorf("UID = %q", e.UID)
+	}
+	if e.Summary != "Physio, knee" {
+		t.Errorf("Summary = %q", e.Summary)
+	}
+	wantDescription := "Wear shorts; bring the referral letter.\nParking is at the back of the building. Folder: C:\\scans\\knee"
+	if e.Description != wantDescription {
+		t.Errorf("Description = %q, want %q", e.Description, wantDescription)
+	}
+	if e.Location != "Main St 12, Springfield" {
+		t.Errorf("Location = %q", e.Location)
+	}
+	if e.Status != "CONFIRMED" {
+		t.Errorf("Status = %q", e.Status)
+	}
+	if e.RRule != "FREQ=WEEKLY;BYDAY=MO;UNTIL=20240401T135959Z" {
+		t.Errorf("RRule = %q", e.RRule)
+	}
+
+	wantStart := time.Date(2024, 3, 4, 9, 30, 0, 0, ny)
+	if !e.Start.Equal(wantStart) || e.Start.Location().String() != "America/New_York" {
+		t.Errorf("Start = %v, want %v", e.Start, wantStart)
+	}
+	if !e.End.Equal(wantStart.Add(30 * time.Minute)) {
+		t.Errorf("End = %v, want %v", e.End, wantStart.Add(30*time.Minute))
+	}
+	// The week after DST starts is still 9:30 local
+	wantEx := []time.Time{time.Date(2024, 3, 11, 9, 30, 0, 0, ny), time.Date(2024, 3, 18, 9, 30, 0, 0, ny)}
+	if len(e.ExDates) != len(wantEx) {
+		t.Fatalf("ExDates = %v, want %v", e.ExDates, wantEx)
+	}
+	for i := range wantEx {
+		if !e.ExDates[i].Equal(wantEx[i]) {
+			t.Errorf("ExDates[%d] = %v, want %v", i, e.ExDates[i], wantEx[i])
+		}
+	}
+}
+
+func TestParseOutlook(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	events, err := Parse(strings.NewReader(crlf(outlookFixture)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	e := events[0]
+	// Windows zone names map to the IANA zone, so DST is right in July
+	want := time.Date(2024, 7, 10, 15, 0, 0, 0, ny)
+	if !e.Start.Equal(want) {
+		t.Errorf("Start = %v, want %v", e.Start, want)
+	}
+	if e.Organizer != "Dr. Jones: Cardiology" {
+		t.Errorf("Organizer = %q", e.Organizer)
+	}
+	if e.Summary != "Cardiology review" {
+		t.Errorf("Summary = %q", e.Summary)
+	}
+}
+
+func TestParseCustomTimezone(t *testing.T) {
+	events, err := Parse(strings.NewReader(crlf(customZoneFixture)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Unknown TZIDs fall back to the VTIMEZONE's standard offset
+	want := time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC)
+	if got := events[0].Start; !got.Equal(want) {
+		t.Errorf("Start = %v, want %v", got, want)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string // lines between BEGIN:VEVENT and END:VEVENT
+		check func(t *testing.T, e Event)
+	}{
+		{
+			name:  "utc",
+			event: "UID:a\nDTSTART:20240110T140000Z",
+			check: func(t *testing.T, e Event) {
+				if want := time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC); !e.Start.Equal(want) {
+					t.Errorf("Start = %v, want %v", e.Start, want)
+				}
+			},
+		},
+		{
+			name:  "all-day",
+			event: "UID:a\nDTSTART;VALUE=DATE:20240229\nDTEND;VALUE=DATE:20240301",
+			check: func(t *testing.T, e Event) {
+				if want := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC); !e.Start.Equal(want) {
+					t.Errorf("Start = %v, want %v", e.Start, want)
+				}
+				if want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC); !e.End.Equal(want) {
+					t.Errorf("End = %v, want %v", e.End, want)
+				}
+			},
+		},
+		{
+			name:  "floating",
+			event: "UID:a\nDTSTART:20240110T140000",
+			check: func(t *testing.T, e Event) {
+				if want := time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC); !e.Start.Equal(want) {
+					t.Errorf("Start = %v, want %v", e.Start, want)
+				}
+			},
+		},
+		{
+			name:  "unknown tzid",
+			event: "UID:a\nDTSTART;TZID=Nowhere/Special:20240110T140000",
+			check: func(t *testing.T, e Event) {
+				if want := time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC); !e.Start.Equal(want) {
+					t.Errorf("Start = %v, want %v", e.Start, want)
+				}
+			},
+		},
+		{
+			name:  "tab folding and lower case",
+			event: "uid:a\ndtstart:20240110T140000Z\nsummary:Eye\n\texam",
+			check: func(t *testing.T, e Event) {
+				if e.Summary != "Eyeexam" {
+					t.Errorf("Summary = %q, want %q", e.Summary, "Eyeexam")
+				}
+			},
+		},
+		{
+			name:  "escapes",
+			event: "UID:a\nDTSTART:20240110T140000Z\nSUMMARY:a\\\\nb\\Nc\\,d\\;e:f",
+			check: func(t *testing.T, e Event) {
+				if want := "a\\nb\nc,d;e:f"; e.Summary != want {
+					t.Errorf("Summary = %q, want %q", e.Summary, want)
+				}
+			},
+		},
+		{
+			name:  "exdate lines and a bad value",
+			event: "UID:a\nDTSTART:20240110T140000Z\nRRULE:FREQ=DAILY\nEXDATE:20240111T140000Z\nEXDATE:nonsense,20240113T140000Z",
+			check: func(t *testing.T, e Event) {
+				if len(e.ExDates) != 2 {
+					t.Errorf("ExDates = %v, want two dates", e.ExDates)
+				}
+			},
+		},
+		{
+			name:  "bad end is ignored",
+			event: "UID:a\nDTSTART:20240110T140000Z\nDTEND:tomorrow",
+			check: func(t *testing.T, e Event) {
+				if !e.End.IsZero() {
+					t.Errorf("End = %v, want zero", e.End)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := crlf("BEGIN:VCALENDAR\nBEGIN:VEVENT\n" + tt.event + "\nEND:VEVENT\nEND:VCALENDAR\n")
+			events, err := Parse(strings.NewReader(in))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			tt.check(t, events[0])
+		})
+	}
+}
+
+func TestParseStreams(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		count int
+	}{
+		{"lf line endings", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:a\nDTSTART:20240110T140000Z\nEND:VEVENT\nEND:VCALENDAR\n", 1},
+		{"byte order mark", "\ufeffBEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART:20240110T140000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", 1},
+		{"blank lines", "BEGIN:VCALENDAR\r\n\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART:20240110T140000Z\r\nEND:VEVENT\r\n\r\nEND:VCALENDAR", 1},
+		{"two calendars", crlf(googleFixture) + crlf(outlookFixture), 2},
+		{"todo is skipped", "BEGIN:VCALENDAR\nBEGIN:VTODO\nUID:t\nEND:VTODO\nBEGIN:VEVENT\nUID:a\nDTSTART:20240110T140000Z\nEND:VEVENT\nEND:VCALENDAR\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := Parse(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(events) != tt.count {
+				t.Errorf("got %d events, want %d", len(events), tt.count)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"not a calendar", "hello world\n"},
+		{"no events", "BEGIN:VCALENDAR\nVERSION:2.0\nEND:VCALENDAR\n"},
+		{"event outside a calendar", "BEGIN:VEVENT\nUID:a\nDTSTART:20240110T140000Z\nEND:VEVENT\n"},
+		{"missing end", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:a\nDTSTART:20240110T140000Z\nEND:VEVENT\n"},
+		{"mismatched end", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:a\nDTSTART:20240110T140000Z\nEND:VCALENDAR\nEND:VEVENT\n"},
+		{"stray end", "END:VCALENDAR\n"},
+		{"property outside a component", "VERSION:2.0\nBEGIN:VCALENDAR\nEND:VCALENDAR\n"},
+		{"line without a colon", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID a\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"unterminated quote", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nORGANIZER;CN=\"Dr. X:mailto:x@y.test\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"no dtstart", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:a\nSUMMARY:x\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"bad dtstart", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:a\nDTSTART:2024-01-10 14:00\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"line too long", "BEGIN:VCALENDAR\nX-JUNK:" + strings.Repeat("x", 2*1024*1024) + "\nEND:VCALENDAR\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if events, err := Parse(strings.NewReader(tt.in)); err == nil {
+				t.Errorf("Parse succeeded with %d events, want an error", len(events))
+			}
+		})
+	}
+}
+
+// TestRoundTrip writes events and reads them back
+func TestRoundTrip(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	berlin := mustLoad(t, "Europe/Berlin")
+	start := time.Date(2024, 10, 27, 1, 30, 0, 0, berlin) // the morning clocks go back at 3:00
+	want := []Event{
+		{
+			UID:         "reminder-1@test",
+			Summary:     "Take metformin, 500mg; with food",
+			Description: strings.Repeat("Long notes that need folding – ü, é, 🦷. ", 10) + "\nSecond line \\ with a backslash",
+			Start:       start,
+			End:         start.Add(15 * time.Minute),
+			RRule:       "FREQ=DAILY;UNTIL=20241130T000000Z",
+			ExDates:     []time.Time{start.AddDate(0, 0, 1)},
+			Status:      "CONFIRMED",
+		},
+		{
+			UID:      "appt-2@test",
+			Summary:  "Dentist",
+			Location: "Room 2, 3rd floor",
+			Start:    time.Date(2024, 11, 3, 1, 30, 0, 0, ny),
+			End:      time.Date(2024, 11, 3, 2, 30, 0, 0, ny),
+			Status:   "TENTATIVE",
+		},
+		{
+			UID:     "utc@test",
+			Summary: "Video call",
+			Start:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := (&Calendar{Name: "Round trip", Events: want}).Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.UID != w.UID || g.Summary != w.Summary || g.Description != w.Description ||
+			g.Location != w.Location || g.RRule != w.RRule || g.Status != w.Status {
+			t.Errorf("event %d = %+v, want %+v", i, g, w)
+		}
+		if !g.Start.Equal(w.Start) || g.Start.Location().String() != w.Start.Location().String() {
+			t.Errorf("event %d start = %v, want %v", i, g.Start, w.Start)
+		}
+		if !g.End.Equal(w.End) {
+			t.Errorf("event %d end = %v, want %v", i, g.End, w.End)
+		}
+		if len(g.ExDates) != len(w.ExDates) {
+			t.Errorf("event %d exdates = %v, want %v", i, g.ExDates, w.ExDates)
+			continue
+		}
+		for j := range w.ExDates {
+			if !g.ExDates[j].Equal(w.ExDates[j]) {
+				t.Errorf("event %d exdate %d = %v, want %v", i, j, g.ExDates[j], w.ExDates[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// writeTimezone emits a VTIMEZONE for loc built from Go's zone database. Each
+// offset change between fromYear and toYear becomes its own observance, which
+// avoids having to express the zone's rules as RRULEs.
+func writeTimezone(lw *lineWriter, loc *time.Location, fromYear, toYear int) {
+	start := time.Date(fromYear, time.January, 1, 0, 0, 0, 0, loc)
+	end := time.Date(toYear+1, time.January, 1, 0, 0, 0, 0, loc)
+
+	lw.line("BEGIN:VTIMEZONE")
+	lw.line("TZID:" + loc.String())
+
+	// The observance in effect at the start of the range
+	name, offset := start.Zone()
+	writeObservance(lw, start.IsDST(), start, offset, offset, name)
+
+	for _, t := range transitions(start, end) {
+		before := t.Add(-time.Second)
+		_, fromOffset := before.Zone()
+		toName, toOffset := t.Zone()
+		// DTSTART is the local time of the change in the offset it changes from
+		onset := t.In(time.FixedZone("", fromOffset))
+		writeObservance(lw, t.IsDST(), onset, fromOffset, toOffset, toName)
+	}
+
+	lw.line("END:VTIMEZONE")
+}
+
+func writeObservance(lw *lineWriter, dst bool, onset time.Time, fromOffset, toOffset int, name string) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	lw.line("BEGIN:" + kind)
+	lw.line("DTSTART:" + onset.Format(dateTimeLayout))
+	lw.line("TZOFFSETFROM:" + formatOffset(fromOffset))
+	lw.line("TZOFFSETTO:" + formatOffset(toOffset))
+	if name != "" {
+		lw.line("TZNAME:" + escapeText(name))
+	}
+	lw.line("END:" + kind)
+}
+
+// transitions finds the instants in [start, end) where the UTC offset changes
+func transitions(start, end time.Time) []time.Time {
+	var result []time.Time
+	_, prevOffset := start.Zone()
+	prev := start
+	for t := start.Add(24 * time.Hour); !t.After(end); t = t.Add(24 * time.Hour) {
+		_, offset := t.Zone()
+		if offset != prevOffset {
+			result = append(result, findTransition(prev, t))
+			prevOffset = offset
+		}
+		prev = t
+	}
+	return result
+}
+
+// findTransition binary-searches (lo, hi] for the first second with hi's offset
+func findTransition(lo, hi time.Time) time.Time {
+	_, target := hi.Zone()
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+		if _, offset := mid.Zone(); offset == target {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, (seconds%3600)/60)
+}
@@ -0,0 +1,230 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dateTimeLayout    = "20060102T150405"
+	utcDateTimeLayout = "20060102T150405Z"
+	productID         = "-//Medical Records App//Calendar//EN"
+)
+
+// Calendar is a VCALENDAR with its events
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Event is a VEVENT. Start and End carry their timezone: UTC times are written
+// as UTC, anything else with a TZID and a matching VTIMEZONE.
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	Start        time.Time
+	End          time.Time
+	RRule        string
+	ExDates      []time.Time
+	Status       string // CONFIRMED, TENTATIVE, CANCELLED
+	Organizer    string // common name of the ORGANIZER; only read when parsing
+	Created      time.Time
+	LastModified time.Time
+	Alarms       []Alarm
+}
+
+// Alarm is a display VALARM triggered Before the event starts
+type Alarm struct {
+	Before      time.Duration
+	Description string
+}
+
+// Write renders the calendar as an RFC 5545 stream
+func (c *Calendar) Write(w io.Writer) error {
+	lw := &lineWriter{w: w}
+	now := time.Now().UTC()
+
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + productID)
+	lw.line("CALSCALE:GREGORIAN")
+	lw.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		lw.line("X-WR-CALNAME:" + escapeText(c.Name))
+	}
+
+	for _, tz := range c.timezones() {
+		writeTimezone(lw, tz.loc, tz.fromYear, tz.toYear)
+	}
+
+	for _, e := range c.Events {
+		lw.line("BEGIN:VEVENT")
+		lw.line("UID:" + e.UID)
+		lw.line("DTSTAMP:" + now.Format(utcDateTimeLayout))
+		lw.line(timeProperty("DTSTART", e.Start))
+		if !e.End.IsZero() {
+			lw.line(timeProperty("DTEND", e.End))
+		}
+		if !e.Created.IsZero() {
+			lw.line("CREATED:" + e.Created.UTC().Format(utcDateTimeLayout))
+		}
+		if !e.LastModified.IsZero() {
+			lw.line("LAST-MODIFIED:" + e.LastModified.UTC().Format(utcDateTimeLayout))
+		}
+		lw.line("SUMMARY:" + escapeText(e.Summary))
+		if e.Description != "" {
+			lw.line("DESCRIPTION:" + escapeText(e.Description))
+		}
+		if e.Location != "" {
+			lw.line("LOCATION:" + escapeText(e.Location))
+		}
+		if e.Status != "" {
+			lw.line("STATUS:" + e.Status)
+		}
+		if e.RRule != "" {
+			lw.line("RRULE:" + e.RRule)
+		}
+		for _, ex := range e.ExDates {
+			lw.line(timeProperty("EXDATE", ex))
+		}
+		for _, a := range e.Alarms {
+			lw.line("BEGIN:VALARM")
+			lw.line("ACTION:DISPLAY")
+			lw.line("DESCRIPTION:" + escapeText(a.Description))
+			lw.line("TRIGGER:-" + formatDuration(a.Before))
+			lw.line("END:VALARM")
+		}
+		lw.line("END:VEVENT")
+	}
+
+	lw.line("END:VCALENDAR")
+	return lw.err
+}
+
+type zoneRange struct {
+	loc              *time.Location
+	fromYear, toYear int
+}
+
+// timezones collects the non-UTC zones used by events and the years each needs
+// to cover, including a few years ahead for recurring events
+func (c *Calendar) timezones() []zoneRange {
+	ranges := make(map[string]*zoneRange)
+	for _, e := range c.Events {
+		loc := e.Start.Location()
+		if isUTC(loc) {
+			continue
+		}
+		from, to := e.Start.Year(), e.Start.Year()
+		if !e.End.IsZero() && e.End.Year() > to {
+			to = e.End.Year()
+		}
+		if e.RRule != "" {
+			if y := time.Now().Year() + 5; y > to {
+				to = y
+			}
+		}
+		zr, ok := ranges[loc.String()]
+		if !ok {
+			ranges[loc.String()] = &zoneRange{loc: loc, fromYear: from, toYear: to}
+			continue
+		}
+		if from < zr.fromYear {
+			zr.fromYear = from
+		}
+		if to > zr.toYear {
+			zr.toYear = to
+		}
+	}
+
+	names := make([]string, 0, len(ranges))
+	for name := range ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]zoneRange, 0, len(names))
+	for _, name := range names {
+		result = append(result, *ranges[name])
+	}
+	return result
+}
+
+func timeProperty(name string, t time.Time) string {
+	if isUTC(t.Location()) {
+		return name + ":" + t.UTC().Format(utcDateTimeLayout)
+	}
+	return fmt.Sprintf("%s;TZID=%s:%s", name, t.Location().String(), t.Format(dateTimeLayout))
+}
+
+func isUTC(loc *time.Location) bool {
+	return loc == time.UTC || loc.String() == "UTC"
+}
+
+// formatDuration renders a non-negative duration as an RFC 5545 DURATION
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return "PT0M"
+	}
+	days := int(d / (24 * time.Hour))
+	d -= time.Duration(days) * 24 * time.Hour
+	hours := int(d / time.Hour)
+	d -= time.Duration(hours) * time.Hour
+	minutes := int(d / time.Minute)
+
+	var b strings.Builder
+	b.WriteString("P")
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if hours > 0 || minutes > 0 {
+		b.WriteString("T")
+		if hours > 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+		}
+		if minutes > 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+		}
+	}
+	return b.String()
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// lineWriter writes CRLF-terminated content lines folded at 75 octets
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (lw *lineWriter) line(s string) {
+	if lw.err != nil {
+		return
+	}
+	var b strings.Builder
+	for len(s) > 75 {
+		cut := 75
+		if b.Len() > 0 {
+			cut = 74 // continuation lines start with a space
+		}
+		// Don't split a multi-byte UTF-8 sequence
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, lw.err = io.WriteString(lw.w, b.String())
+}
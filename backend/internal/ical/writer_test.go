@@ -0,0 +1,255 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func writeCalendar(t *testing.T, c *Calendar) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return buf.String()
+}
+
+// contentLines splits written output into its physical lines, checking that
+// each one is CRLF-terminated
+func contentLines(t *testing.T, out string) []string {
+	t.Helper()
+	if !strings.HasSuffix(out, "\r\n") {
+		t.Fatalf("output doesn't end with CRLF")
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			t.Fatalf("bare CR or LF in line %q", line)
+		}
+	}
+	return lines
+}
+
+func TestWriteFolding(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary string
+	}{
+		{"short", "Checkup"},
+		{"exactly one line", strings.Repeat("a", 75-len("SUMMARY:"))},
+		{"one over", strings.Repeat("a", 76-len("SUMMARY:"))},
+		{"several lines", strings.Repeat("0123456789", 30)},
+		{"multi-byte", strings.Repeat("Zahnärztliche Kontrolle – ", 8)},
+		{"four-byte", strings.Repeat("🦷", 60)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := writeCalendar(t, &Calendar{Events: []Event{{
+				UID:     "fold@test",
+				Summary: tt.summary,
+				Start:   time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+			}}})
+
+			var summary strings.Builder
+			inSummary := false
+			for _, line := range contentLines(t, out) {
+				if len(line) > 75 {
+					t.Errorf("line of %d octets: %q", len(line), line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("fold split a UTF-8 sequence: %q", line)
+				}
+				switch {
+				case strings.HasPrefix(line, "SUMMARY:"):
+					inSummary = true
+					summary.WriteString(line)
+				case inSummary && strings.HasPrefix(line, " "):
+					summary.WriteString(line[1:])
+				default:
+					inSummary = false
+				}
+			}
+			if got, want := summary.String(), "SUMMARY:"+tt.summary; got != want {
+				t.Errorf("unfolded SUMMARY = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"Dr. Smith, MD", `Dr. Smith\, MD`},
+		{"fasting; no water", `fasting\; no water`},
+		{`C:\scans`, `C:\\scans`},
+		{"line one\nline two", `line one\nline two`},
+		{"line one\r\nline two", `line one\nline two`},
+		{"line one\rline two", `line one\nline two`},
+		{"time: 9:30", "time: 9:30"},
+		{`\,;`, `\\\,\;`},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.in); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	start := time.Date(2024, 3, 4, 9, 30, 0, 0, ny)
+	out := writeCalendar(t, &Calendar{
+		Name: "Ana Lima - Medical",
+		Events: []Event{{
+			UID:          "appt-1@test",
+			Summary:      "Cardiology, follow-up",
+			Description:  "Bring results\nand medication list",
+			Location:     "Clinic; room 4",
+			Start:        start,
+			End:          start.Add(30 * time.Minute),
+			RRule:        "FREQ=MONTHLY;BYDAY=1MO",
+			ExDates:      []time.Time{time.Date(2024, 4, 1, 9, 30, 0, 0, ny)},
+			Status:       "CONFIRMED",
+			Created:      time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+			LastModified: time.Date(2024, 2, 2, 12, 0, 0, 0, ny),
+			Alarms:       []Alarm{{Before: 90 * time.Minute, Description: "Cardiology, follow-up"}},
+		}},
+	})
+	lines := contentLines(t, out)
+
+	if lines[0] != "BEGIN:VCALENDAR" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("calendar starts with %q and ends with %q", lines[0], lines[len(lines)-1])
+	}
+	for _, want := range []string{
+		"VERSION:2.0",
+		"PRODID:" + productID,
+		`X-WR-CALNAME:Ana Lima - Medical`,
+		"BEGIN:VTIMEZONE",
+		"TZID:America/New_York",
+		"UID:appt-1@test",
+		"DTSTART;TZID=America/New_York:20240304T093000",
+		"DTEND;TZID=America/New_York:20240304T100000",
+		"CREATED:20240201T120000Z",
+		"LAST-MODIFIED:20240202T170000Z",
+		`SUMMARY:Cardiology\, follow-up`,
+		`DESCRIPTION:Bring results\nand medication list`,
+		`LOCATION:Clinic\; room 4`,
+		"STATUS:CONFIRMED",
+		"RRULE:FREQ=MONTHLY;BYDAY=1MO",
+		"EXDATE;TZID=America/New_York:20240401T093000",
+		"TRIGGER:-PT1H30M",
+	} {
+		if !containsLine(lines, want) {
+			t.Errorf("missing line %q in\n%s", want, out)
+		}
+	}
+
+	// The VTIMEZONE must come before the event that uses it
+	if tz, ev := indexOf(lines, "BEGIN:VTIMEZONE"), indexOf(lines, "BEGIN:VEVENT"); tz > ev {
+		t.Errorf("VTIMEZONE at line %d comes after VEVENT at line %d", tz, ev)
+	}
+}
+
+func TestWriteUTCEvent(t *testing.T) {
+	out := writeCalendar(t, &Calendar{Events: []Event{{
+		UID:     "utc@test",
+		Summary: "Lab work",
+		Start:   time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC),
+		ExDates: []time.Time{time.Date(2024, 1, 17, 14, 0, 0, 0, time.UTC)},
+		RRule:   "FREQ=WEEKLY;COUNT=4",
+	}}})
+	lines := contentLines(t, out)
+	for _, want := range []string{"DTSTART:20240110T140000Z", "EXDATE:20240117T140000Z"} {
+		if !containsLine(lines, want) {
+			t.Errorf("missing line %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "VTIMEZONE") || strings.Contains(out, "DTEND") {
+		t.Errorf("UTC event without an end wrote a VTIMEZONE or DTEND:\n%s", out)
+	}
+}
+
+func TestWriteTimezone(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	var buf bytes.Buffer
+	lw := &lineWriter{w: &buf}
+	writeTimezone(lw, ny, 2024, 2024)
+	if lw.err != nil {
+		t.Fatal(lw.err)
+	}
+	lines := contentLines(t, buf.String())
+
+	want := []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:America/New_York",
+		"BEGIN:STANDARD",
+		"DTSTART:20240101T000000",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0500",
+		"TZNAME:EST",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20240310T020000",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"TZNAME:EDT",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"DTSTART:20241103T020000",
+		"TZOFFSETFROM:-0400",
+		"TZOFFSETTO:-0500",
+		"TZNAME:EST",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("VTIMEZONE =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "PT0M"},
+		{-time.Minute, "PT0M"},
+		{15 * time.Minute, "PT15M"},
+		{2 * time.Hour, "PT2H"},
+		{24 * time.Hour, "P1D"},
+		{26*time.Hour + 5*time.Minute, "P1DT2H5M"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.in); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func containsLine(lines []string, want string) bool {
+	return indexOf(lines, want) >= 0
+}
+
+func indexOf(lines []string, want string) int {
+	for i, line := range lines {
+		if line == want {
+			return i
+		}
+	}
+	return -1
+}
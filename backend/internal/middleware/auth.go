@@ -1,14 +1,18 @@
 package middleware
 
 import (
+	"errors"
 	"medical-records-app/internal/auth"
+	"medical-records-app/internal/services"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware requires a valid access token whose session hasn't been
+// signed out
+func AuthMiddleware(sessions *services.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -24,18 +28,51 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token := parts[1]
-		claims, err := auth.ValidateToken(token)
-		if err != nil {
+		authenticate(c, sessions, parts[1])
+	}
+}
+
+// StreamAuthMiddleware also accepts the token as an access_token query
+// parameter, since browsers can't set headers on EventSource requests. Only
+// use it on streaming endpoints: query strings end up in access logs.
+func StreamAuthMiddleware(sessions *services.SessionService) gin.HandlerFunc {
+	header := AuthMiddleware(sessions)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				authenticate(c, sessions, token)
+				return
+			}
+		}
+		header(c)
+	}
+}
+
+func authenticate(c *gin.Context, sessions *services.SessionService, token string) {
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return
+	}
+	session, err := sessions.CheckSession(claims.UserID, claims.SessionID)
+	if err != nil {
+		if errors.Is(err, services.ErrSessionRevoked) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
-			return
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
 		}
+		c.Abort()
+		return
+	}
 
-		c.Set("user_id", claims.UserID.String())
-		c.Set("email", claims.Email)
-		c.Set("role", claims.Role)
-		c.Next()
+	c.Set("user_id", claims.UserID.String())
+	c.Set("session_id", claims.SessionID.String())
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
 	}
+	c.Set("mfa_verified", session.MFAVerifiedAt != nil)
+	c.Set("email", claims.Email)
+	c.Set("role", claims.Role)
+	c.Next()
 }
-
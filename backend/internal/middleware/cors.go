@@ -45,6 +45,7 @@ func GetCORSConfig() cors.Config {
 			"X-Requested-With",
 			"X-CSRF-Token",
 			"Cache-Control",
+			"X-Share-Session",
 		},
 		
 		// Expose headers that frontend might need
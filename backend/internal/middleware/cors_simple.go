@@ -90,7 +90,7 @@ func SimpleCORSMiddleware() gin.HandlerFunc {
 		}
 		
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS, HEAD")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept, Authorization, X-Requested-With, X-CSRF-Token, Cache-Control")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept, Authorization, X-Requested-With, X-CSRF-Token, Cache-Control, X-Share-Session")
 		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Authorization")
 		c.Writer.Header().Set("Access-Control-Max-Age", "43200") // 12 hours
 		
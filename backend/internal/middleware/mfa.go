@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"errors"
+	"medical-records-app/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RequireMFA only lets through sessions in which the user checked a second
+// factor, at login or by stepping up. Use it after AuthMiddleware.
+func RequireMFA(users *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("mfa_verified") {
+			c.Next()
+			return
+		}
+
+		userID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		user, err := users.GetUserByID(userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+			}
+			c.Abort()
+			return
+		}
+		if !user.MFAEnabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Set up two-factor authentication to use this feature",
+				"code":  "mfa_not_enrolled",
+			})
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Enter a two-factor authentication code to continue",
+				"code":  "mfa_required",
+			})
+		}
+		c.Abort()
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"errors"
+	"medical-records-app/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RequireVerifiedEmail turns away users who haven't verified their email
+// address. Use it after AuthMiddleware.
+func RequireVerifiedEmail(users *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		user, err := users.GetUserByID(userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+			}
+			c.Abort()
+			return
+		}
+		if !user.IsEmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Verify your email address to use this feature",
+				"code":  "email_not_verified",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
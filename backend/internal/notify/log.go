@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogNotifier is the development sink: it "delivers" messages by appending
+// them as JSON lines to a file, or to the server log when no file is set.
+// Secrets are redacted, so codes and links sent through it can't be used.
+type LogNotifier struct {
+	channel string
+	path    string
+	mu      sync.Mutex
+}
+
+func NewLogNotifier(channel, path string) *LogNotifier {
+	return &LogNotifier{channel: channel, path: path}
+}
+
+func (n *LogNotifier) Channel() string {
+	return n.channel
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) (string, error) {
+	id := "log-" + uuid.New().String()
+	entry, err := json.Marshal(map[string]interface{}{
+		"id":      id,
+		"channel": n.channel,
+		"to":      msg.To,
+		"subject": Redact(msg.Subject, msg.Secrets),
+		"body":    Redact(msg.Body, msg.Secrets),
+		"sent_at": time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	if n.path == "" {
+		log.Printf("📨 %s notification: %s", n.channel, entry)
+		return id, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(entry, '\n')); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"log"
+	"medical-records-app/internal/config"
+	"strings"
+)
+
+// Delivery channels
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// RedactedText stands in for secrets wherever a message is stored or logged
+const RedactedText = "[redacted]"
+
+// Message is a single outbound notification
+type Message struct {
+	To      string // email address or E.164 phone number
+	Subject string // ignored by SMS
+	Body    string
+	// Secrets are values in Subject and Body, such as one-time codes and
+	// links carrying a token, that must only reach the recipient
+	Secrets []string
+}
+
+// Redact replaces every secret in s with RedactedText
+func Redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, RedactedText)
+		}
+	}
+	return s
+}
+
+// Notifier delivers messages over one channel
+type Notifier interface {
+	Channel() string
+	// Send delivers the message and returns the provider's message ID
+	Send(ctx context.Context, msg Message) (string, error)
+}
+
+// PermanentError marks a failure that retrying won't fix, such as an invalid
+// recipient or rejected credentials
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// IsPermanent reports whether err should not be retried
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// FromConfig builds one notifier per channel. Channels without provider
+// credentials fall back to the log sink so development setups still "deliver".
+// In production they are left out instead, so sends on them fail rather than
+// writing messages to the server log.
+func FromConfig(cfg *config.Config) map[string]Notifier {
+	notifiers := make(map[string]Notifier)
+	production := cfg.Server.Env == "production"
+
+	switch {
+	case cfg.SMTP.Host != "":
+		notifiers[ChannelEmail] = NewSMTPNotifier(cfg.SMTP)
+	case production:
+		log.Println("WARNING: SMTP is not configured; email notifications are disabled")
+	default:
+		notifiers[ChannelEmail] = NewLogNotifier(ChannelEmail, cfg.Notification.LogFile)
+	}
+
+	switch {
+	case cfg.SMS.Provider == "twilio" && cfg.SMS.TwilioAccountSID != "":
+		notifiers[ChannelSMS] = NewTwilioNotifier(cfg.SMS)
+	case production:
+		log.Println("WARNING: SMS provider is not configured; SMS notifications are disabled")
+	default:
+		notifiers[ChannelSMS] = NewLogNotifier(ChannelSMS, cfg.Notification.LogFile)
+	}
+
+	return notifiers
+}
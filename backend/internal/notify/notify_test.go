@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"medical-records-app/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFromConfigLogSink(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.Env = "development"
+	notifiers := FromConfig(cfg)
+	for _, channel := range []string{ChannelEmail, ChannelSMS} {
+		if _, ok := notifiers[channel].(*LogNotifier); !ok {
+			t.Errorf("development %s notifier = %T, want *LogNotifier", channel, notifiers[channel])
+		}
+	}
+
+	// Production never falls back to the log sink
+	cfg.Server.Env = "production"
+	notifiers = FromConfig(cfg)
+	if len(notifiers) != 0 {
+		t.Errorf("production without providers registered %v, want no notifiers", notifiers)
+	}
+}
+
+func TestLogNotifierRedactsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.log")
+	n := NewLogNotifier(ChannelEmail, path)
+	_, err := n.Send(context.Background(), Message{
+		To:      "ana@example.com",
+		Subject: "Your code is 482913",
+		Body:    "Use 482913 or open https://app.test/reset?token=s3cr3t",
+		Secrets: []string{"482913", "s3cr3t"},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"482913", "s3cr3t"} {
+		if strings.Contains(string(written), secret) {
+			t.Errorf("log sink wrote secret %q: %s", secret, written)
+		}
+	}
+	if !strings.Contains(string(written), "reset?token="+RedactedText) {
+		t.Errorf("log sink entry %s doesn't contain the redacted link", written)
+	}
+}
+
+func TestRenderShareDeactivatedLocked(t *testing.T) {
+	data := map[string]interface{}{
+		"OwnerName": "Ana", "Expired": false, "Locked": true, "SharedWith": "with Dr. Lee",
+		"RecordCount": 2, "RecordLabel": "records", "TokenPrefix": "abcd1234", "Views": 0,
+		"SharingURL": "https://app.test/sharing",
+	}
+	for _, channel := range []string{ChannelEmail, ChannelSMS} {
+		msg, err := Render("share_deactivated", channel, data)
+		if err != nil {
+			t.Fatalf("Render %s: %v", channel, err)
+		}
+		if !strings.Contains(msg.Body, "too many wrong PINs or codes") || strings.Contains(msg.Body, "used up") {
+			t.Errorf("%s body doesn't explain the lockout: %s", channel, msg.Body)
+		}
+	}
+}
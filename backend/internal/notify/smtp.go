@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"medical-records-app/internal/config"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMTPNotifier sends plain-text email through an SMTP relay, using STARTTLS
+// when the server offers it
+type SMTPNotifier struct {
+	cfg config.SMTPConfig
+}
+
+func NewSMTPNotifier(cfg config.SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{cfg: cfg}
+}
+
+func (n *SMTPNotifier) Channel() string {
+	return ChannelEmail
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, msg Message) (string, error) {
+	from, err := mail.ParseAddress(n.cfg.From)
+	if err != nil {
+		return "", &PermanentError{Err: fmt.Errorf("invalid SMTP_FROM: %w", err)}
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return "", &PermanentError{Err: fmt.Errorf("invalid recipient: %w", err)}
+	}
+
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	messageID := fmt.Sprintf("<%s@%s>", uuid.New().String(), domain)
+
+	var buf bytes.Buffer
+	headers := []struct{ key, value string }{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `text/plain; charset="utf-8"`},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if n.cfg.User != "" {
+		auth = smtp.PlainAuth("", n.cfg.User, n.cfg.Password, n.cfg.Host)
+	}
+
+	// net/smtp has no context support, so bound the send from the outside
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(n.cfg.Host+":"+n.cfg.Port, auth, from.Address, []string{to.Address}, buf.Bytes())
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			var protoErr *textproto.Error
+			// 5xx replies are permanent rejections (bad mailbox, auth failure)
+			if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+				return "", &PermanentError{Err: err}
+			}
+			return "", err
+		}
+		return messageID, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
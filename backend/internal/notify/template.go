@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templateFuncs = template.FuncMap{
+	"plural": func(n int, singular, plural string) string {
+		if n == 1 {
+			return singular
+		}
+		return plural
+	},
+}
+
+// templates maps "<name>.<channel>" to its parsed file. Each file defines a
+// "body" template and, for email, a "subject" template; files are parsed
+// separately so their blocks don't collide.
+var templates = loadTemplates()
+
+func loadTemplates() map[string]*template.Template {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		loaded[name] = template.Must(template.New(name).Funcs(templateFuncs).ParseFS(templateFS, file))
+	}
+	return loaded
+}
+
+// Render builds the message for a named template on the given channel. The
+// recipient is left for the caller to fill in.
+func Render(name, channel string, data interface{}) (Message, error) {
+	tmpl, ok := templates[name+"."+channel]
+	if !ok {
+		return Message{}, fmt.Errorf("no %s template for %q", channel, name)
+	}
+
+	var msg Message
+	if tmpl.Lookup("subject") != nil {
+		var subject bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+			return Message{}, err
+		}
+		msg.Subject = strings.TrimSpace(subject.String())
+	}
+	var body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return Message{}, err
+	}
+	msg.Body = strings.TrimSpace(body.String()) + "\n"
+	return msg, nil
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"medical-records-app/internal/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioNotifier sends SMS through the Twilio Messages API. Any provider that
+// implements the same endpoint can be used by pointing TWILIO_API_URL at it.
+type TwilioNotifier struct {
+	cfg    config.SMSConfig
+	client *http.Client
+}
+
+func NewTwilioNotifier(cfg config.SMSConfig) *TwilioNotifier {
+	return &TwilioNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (n *TwilioNotifier) Channel() string {
+	return ChannelSMS
+}
+
+type twilioResponse struct {
+	SID     string `json:"sid"`
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (n *TwilioNotifier) Send(ctx context.Context, msg Message) (string, error) {
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json",
+		strings.TrimRight(n.cfg.TwilioAPIURL, "/"), url.PathEscape(n.cfg.TwilioAccountSID))
+
+	form := url.Values{}
+	form.Set("To", msg.To)
+	form.Set("From", n.cfg.TwilioPhoneNumber)
+	form.Set("Body", msg.Body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+	req.SetBasicAuth(n.cfg.TwilioAccountSID, n.cfg.TwilioAuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body twilioResponse
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("twilio returned %d: %s", resp.StatusCode, body.Message)
+		// Client errors other than rate limiting won't succeed on retry
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return "", &PermanentError{Err: err}
+		}
+		return "", err
+	}
+
+	return body.SID, nil
+}
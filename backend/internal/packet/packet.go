@@ -0,0 +1,171 @@
+// Package packet renders a set of shared records as a single file for
+// download: a ZIP with a plain-text summary and the original attachments, or
+// one PDF with a cover page, the summary and the attachments inside it.
+package packet
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxAttachmentBytes caps each attachment read into a packet, and
+// maxTotalBytes all of them together
+const (
+	maxAttachmentBytes = 25 << 20
+	maxTotalBytes      = 100 << 20
+)
+
+// Document is everything that goes into a packet
+type Document struct {
+	Title       string
+	Patient     string
+	DateOfBirth string // empty when unknown
+	RecordTypes []string
+	GeneratedAt time.Time
+	ExpiresAt   time.Time
+	Withheld    string // what the owner chose not to share, empty when nothing
+	Sections    []Section
+	Attachments []Attachment
+}
+
+// Section groups records of one type, e.g. "Prescriptions"
+type Section struct {
+	Title string
+	Items []Item
+}
+
+// Item is one record
+type Item struct {
+	Heading string
+	Date    string
+	Fields  []Field
+}
+
+// Field is a labelled value; empty values are left out when rendering
+type Field struct {
+	Label string
+	Value string
+}
+
+// Attachment is a record's original file, fetched only while the packet is
+// written
+type Attachment struct {
+	Name string // file name inside the packet, e.g. lab_report-<id>.pdf
+	For  string // the record it belongs to, for the summary
+	Open func() (body io.ReadCloser, contentType string, err error)
+}
+
+// attachmentResult records what happened to an attachment while writing
+type attachmentResult struct {
+	Attachment
+	ContentType string
+	Data        []byte
+	Err         error
+}
+
+// fetch reads an attachment, enforcing the per-file and remaining total size
+// limits
+func fetch(a Attachment, remaining int64) attachmentResult {
+	result := attachmentResult{Attachment: a}
+	body, contentType, err := a.Open()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer body.Close()
+
+	limit := int64(maxAttachmentBytes)
+	if remaining < limit {
+		limit = remaining
+	}
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if int64(len(data)) > limit {
+		result.Err = fmt.Errorf("file is too large to include")
+		return result
+	}
+	result.ContentType = contentType
+	result.Data = data
+	return result
+}
+
+// fetchAll reads every attachment in order
+func fetchAll(attachments []Attachment) []attachmentResult {
+	results := make([]attachmentResult, 0, len(attachments))
+	remaining := int64(maxTotalBytes)
+	for _, a := range attachments {
+		result := fetch(a, remaining)
+		remaining -= int64(len(result.Data))
+		results = append(results, result)
+	}
+	return results
+}
+
+// RecordCount is the number of records across all sections
+func (d *Document) RecordCount() int {
+	n := 0
+	for _, section := range d.Sections {
+		n += len(section.Items)
+	}
+	return n
+}
+
+// coverLines are the facts shown at the top of both packet formats
+func (d *Document) coverLines() []Field {
+	return []Field{
+		{"Patient", d.Patient},
+		{"Date of birth", d.DateOfBirth},
+		{"Records", strings.Join(d.RecordTypes, ", ")},
+		{"Generated", d.GeneratedAt.Format("Jan 2, 2006 3:04 PM MST")},
+		{"Share link expires", d.ExpiresAt.Format("Jan 2, 2006 3:04 PM MST")},
+		{"Withheld", d.Withheld},
+	}
+}
+
+// writeSummary renders the document as plain text. note describes each
+// attachment's fate and is keyed by attachment name.
+func (d *Document) writeSummary(w io.Writer, note map[string]string) error {
+	var b strings.Builder
+	b.WriteString(d.Title + "\n")
+	b.WriteString(strings.Repeat("=", utf8.RuneCountInString(d.Title)) + "\n\n")
+	for _, f := range d.coverLines() {
+		if f.Value != "" {
+			fmt.Fprintf(&b, "%-20s %s\n", f.Label+":", f.Value)
+		}
+	}
+
+	for _, section := range d.Sections {
+		fmt.Fprintf(&b, "\n%s (%d)\n%s\n", section.Title, len(section.Items), strings.Repeat("-", utf8.RuneCountInString(section.Title)))
+		if len(section.Items) == 0 {
+			b.WriteString("None\n")
+		}
+		for _, item := range section.Items {
+			b.WriteString("\n" + item.Heading)
+			if item.Date != "" {
+				b.WriteString(" - " + item.Date)
+			}
+			b.WriteString("\n")
+			for _, f := range item.Fields {
+				if f.Value != "" {
+					fmt.Fprintf(&b, "  %s: %s\n", f.Label, f.Value)
+				}
+			}
+		}
+	}
+
+	if len(d.Attachments) > 0 {
+		b.WriteString("\nAttachments\n-----------\n")
+		for _, a := range d.Attachments {
+			fmt.Fprintf(&b, "%s (%s): %s\n", a.Name, a.For, note[a.Name])
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
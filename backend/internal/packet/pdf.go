@@ -0,0 +1,479 @@
+package packet
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// maxImagePixels caps the size of images decoded for a page of their own.
+// A small compressed file can declare huge dimensions, and decoding it would
+// allocate memory for every pixel. Larger images are embedded as files.
+const maxImagePixels = 25_000_000
+
+// Letter paper in points, with 0.75in margins
+const (
+	pageWidth  = 612.0
+	pageHeight = 792.0
+	margin     = 54.0
+)
+
+// WritePDF writes the packet as one PDF: a cover page, the record summary,
+// a page per image attachment, and every other attachment (such as PDFs)
+// embedded as a file attachment the reader can open from the viewer's
+// attachments panel. It uses only the standard Helvetica fonts, so nothing
+// needs embedding.
+func WritePDF(w io.Writer, doc *Document) error {
+	p := &pdfFile{}
+	catalogID := p.reserve()
+	pagesID := p.reserve()
+	regularID := p.add([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"))
+	boldID := p.add([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>"))
+	l := &pdfLayout{pdf: p, pagesID: pagesID, regularID: regularID, boldID: boldID}
+
+	// Fetch attachments first so the summary can say what happened to each
+	results := fetchAll(doc.Attachments)
+	note := make(map[string]string, len(results))
+	type pdfImage struct {
+		id            int
+		width, height int
+		caption       string
+	}
+	var images []pdfImage
+	type embedded struct {
+		name string
+		id   int
+	}
+	var files []embedded
+	for _, r := range results {
+		if r.Err != nil {
+			note[r.Name] = "not included (" + r.Err.Error() + ")"
+			continue
+		}
+		if id, width, height, ok := p.addImage(r.Data); ok {
+			images = append(images, pdfImage{id, width, height, r.Name + " - " + r.For})
+			note[r.Name] = "shown after the summary"
+			continue
+		}
+		fileID := p.addStream(
+			fmt.Sprintf("/Type /EmbeddedFile /Subtype %s /Params << /Size %d >>", pdfName(mimeOf(r.ContentType)), len(r.Data)),
+			r.Data,
+		)
+		specID := p.add([]byte(fmt.Sprintf("<< /Type /Filespec /F %s /UF %s /Desc %s /EF << /F %d 0 R >> >>",
+			pdfString(r.Name), pdfString(r.Name), pdfString(r.For), fileID)))
+		files = append(files, embedded{r.Name, specID})
+		note[r.Name] = "attached to this PDF (see the attachments panel)"
+	}
+
+	// Cover page
+	l.newPage()
+	l.text(true, 20, 0, doc.Title)
+	l.gap(10)
+	for _, f := range doc.coverLines() {
+		if f.Value != "" {
+			l.field(f.Label, f.Value)
+		}
+	}
+	l.gap(16)
+	l.text(true, 12, 0, "Contents")
+	for _, section := range doc.Sections {
+		l.text(false, 10, 12, fmt.Sprintf("%s: %d", section.Title, len(section.Items)))
+	}
+	if len(doc.Attachments) > 0 {
+		l.text(false, 10, 12, fmt.Sprintf("Attachments: %d", len(doc.Attachments)))
+	}
+	l.gap(24)
+	l.text(false, 8, 0, "This packet contains confidential medical information shared by the patient. Do not forward it.")
+
+	// Summary
+	l.newPage()
+	for _, section := range doc.Sections {
+		l.text(true, 14, 0, fmt.Sprintf("%s (%d)", section.Title, len(section.Items)))
+		l.gap(4)
+		if len(section.Items) == 0 {
+			l.text(false, 10, 0, "None")
+		}
+		for _, item := range section.Items {
+			heading := item.Heading
+			if item.Date != "" {
+				heading += " - " + item.Date
+			}
+			l.text(true, 11, 0, heading)
+			for _, f := range item.Fields {
+				if f.Value != "" {
+					l.field(f.Label, f.Value)
+				}
+			}
+			l.gap(8)
+		}
+		l.gap(10)
+	}
+	if len(doc.Attachments) > 0 {
+		l.text(true, 14, 0, "Attachments")
+		l.gap(4)
+		for _, a := range doc.Attachments {
+			l.text(false, 10, 0, fmt.Sprintf("%s (%s): %s", a.Name, a.For, note[a.Name]))
+		}
+	}
+
+	for _, img := range images {
+		l.imagePage(img.id, img.width, img.height, img.caption)
+	}
+	l.flush()
+
+	// Catalog, page tree and embedded file name tree
+	kids := make([]string, len(l.pages))
+	for i, id := range l.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	p.set(pagesID, []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(l.pages))))
+
+	catalog := fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R", pagesID)
+	if len(files) > 0 {
+		sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+		var names strings.Builder
+		for _, f := range files {
+			fmt.Fprintf(&names, "%s %d 0 R ", pdfString(f.name), f.id)
+		}
+		catalog += fmt.Sprintf(" /Names << /EmbeddedFiles << /Names [%s] >> >> /PageMode /UseAttachments", strings.TrimSpace(names.String()))
+	}
+	catalog += " >>"
+	p.set(catalogID, []byte(catalog))
+
+	infoID := p.add([]byte(fmt.Sprintf("<< /Title %s /Producer (Medical Records App) /CreationDate (D:%s) >>",
+		pdfString(doc.Title), doc.GeneratedAt.UTC().Format("20060102150405Z"))))
+
+	return p.writeTo(w, catalogID, infoID)
+}
+
+// pdfFile collects numbered objects and serializes them with a cross-
+// reference table
+type pdfFile struct {
+	objects [][]byte // object n is objects[n-1]
+}
+
+func (p *pdfFile) reserve() int {
+	p.objects = append(p.objects, nil)
+	return len(p.objects)
+}
+
+func (p *pdfFile) set(id int, body []byte) {
+	p.objects[id-1] = body
+}
+
+func (p *pdfFile) add(body []byte) int {
+	id := p.reserve()
+	p.set(id, body)
+	return id
+}
+
+func (p *pdfFile) addStream(dict string, data []byte) int {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<< %s /Length %d >>\nstream\n", dict, len(data))
+	b.Write(data)
+	b.WriteString("\nendstream")
+	return p.add(b.Bytes())
+}
+
+// addImage adds an image XObject. JPEGs are passed through as-is; other
+// formats the standard library can decode are flattened onto white and
+// stored as compressed RGB. ok is false for anything else, and for images too
+// large to decode.
+func (p *pdfFile) addImage(data []byte) (id, width, height int, ok bool) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	if format == "jpeg" {
+		colorSpace := ""
+		switch config.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.YCbCrModel, color.RGBAModel:
+			colorSpace = "/DeviceRGB"
+		}
+		if colorSpace != "" {
+			id = p.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+				config.Width, config.Height, colorSpace), data)
+			return id, config.Width, config.Height, true
+		}
+	}
+
+	// Everything else, including CMYK JPEGs, is converted to RGB
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return 0, 0, 0, false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// Composite onto white
+			white := 0xffff - a
+			rgb = append(rgb, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(rgb)
+	zw.Close()
+
+	id = p.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+		bounds.Dx(), bounds.Dy()), compressed.Bytes())
+	return id, bounds.Dx(), bounds.Dy(), true
+}
+
+func (p *pdfFile) writeTo(w io.Writer, rootID, infoID int) error {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(p.objects))
+	for i, body := range p.objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		b.Write(body)
+		b.WriteString("\nendobj\n")
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(p.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(p.objects)+1, rootID, infoID, xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pdfLayout flows text down pages, starting a new page when one fills up
+type pdfLayout struct {
+	pdf       *pdfFile
+	pagesID   int
+	regularID int
+	boldID    int
+
+	pages   []int
+	content bytes.Buffer
+	xobject string // image resource for the current page, if any
+	open    bool
+	y       float64
+}
+
+func (l *pdfLayout) newPage() {
+	l.flush()
+	l.open = true
+	l.y = pageHeight - margin
+}
+
+// flush finishes the current page, if one is open
+func (l *pdfLayout) flush() {
+	if !l.open {
+		return
+	}
+	contentID := l.pdf.addStream("", l.content.Bytes())
+	resources := fmt.Sprintf("/Font << /F1 %d 0 R /F2 %d 0 R >>", l.regularID, l.boldID)
+	if l.xobject != "" {
+		resources += " /XObject << " + l.xobject + " >>"
+	}
+	pageID := l.pdf.add([]byte(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources << %s >> /Contents %d 0 R >>",
+		l.pagesID, pageWidth, pageHeight, resources, contentID)))
+	l.pages = append(l.pages, pageID)
+	l.content.Reset()
+	l.xobject = ""
+	l.open = false
+}
+
+func (l *pdfLayout) gap(h float64) {
+	l.y -= h
+}
+
+// text writes s wrapped to the page width, indented by indent points
+func (l *pdfLayout) text(bold bool, size, indent float64, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	lineHeight := size * 1.35
+	for _, line := range wrap(s, bold, size, pageWidth-2*margin-indent) {
+		if l.y-lineHeight < margin {
+			l.newPage()
+		}
+		l.y -= lineHeight
+		fmt.Fprintf(&l.content, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, margin+indent, l.y, pdfString(line))
+	}
+}
+
+// field writes "Label: value" with the label in bold
+func (l *pdfLayout) field(label, value string) {
+	const size = 10
+	labelText := label + ": "
+	labelWidth := textWidth(labelText, true, size)
+	lines := wrap(value, false, size, pageWidth-2*margin-12-labelWidth)
+	for i, line := range lines {
+		if l.y-size*1.35 < margin {
+			l.newPage()
+		}
+		l.y -= size * 1.35
+		if i == 0 {
+			fmt.Fprintf(&l.content, "BT /F2 %d Tf %.2f %.2f Td %s Tj ET\n", size, margin+12, l.y, pdfString(labelText))
+		}
+		fmt.Fprintf(&l.content, "BT /F1 %d Tf %.2f %.2f Td %s Tj ET\n", size, margin+12+labelWidth, l.y, pdfString(line))
+	}
+}
+
+// imagePage puts one image on its own page, scaled to fit under a caption
+func (l *pdfLayout) imagePage(imageID, width, height int, caption string) {
+	l.newPage()
+	l.text(true, 11, 0, caption)
+	l.xobject = fmt.Sprintf("/Im1 %d 0 R", imageID)
+
+	boxWidth := pageWidth - 2*margin
+	boxHeight := l.y - 12 - margin
+	scale := boxWidth / float64(width)
+	if s := boxHeight / float64(height); s < scale {
+		scale = s
+	}
+	if scale > 1 {
+		scale = 1 // don't blow small images up
+	}
+	w, h := float64(width)*scale, float64(height)*scale
+	fmt.Fprintf(&l.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im1 Do Q\n", w, h, margin, l.y-12-h)
+}
+
+// wrap breaks s into lines no wider than width points
+func wrap(s string, bold bool, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		var line string
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if textWidth(candidate, bold, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Split words that are wider than a whole line
+			for textWidth(word, bold, size) > width {
+				n := len(word)
+				for n > 1 && textWidth(word[:n], bold, size) > width {
+					n--
+				}
+				lines = append(lines, word[:n])
+				word = word[n:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// helveticaWidths are the Helvetica glyph widths for ASCII 32-126, in
+// thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// textWidth estimates the width of s in points. Bold is approximated as a
+// little wider than regular, and characters outside ASCII as a digit.
+func textWidth(s string, bold bool, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	width := float64(total) * size / 1000
+	if bold {
+		width *= 1.06
+	}
+	return width
+}
+
+// winAnsi maps curly quotes and dashes to their WinAnsi codes, passes
+// Latin-1 through and replaces anything else with '?'
+var winAnsi = map[rune]byte{
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '€': 0x80, '…': 0x85,
+}
+
+// pdfString encodes s as a PDF literal string in WinAnsiEncoding
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		var c byte
+		switch {
+		case winAnsi[r] != 0:
+			c = winAnsi[r]
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			c = byte(r)
+		default:
+			c = '?'
+		}
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n', '\r', '\t':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfName encodes s as a PDF name, escaping delimiters such as '/'
+func pdfName(s string) string {
+	var b strings.Builder
+	b.WriteByte('/')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || strings.IndexByte("#()<>[]{}/%", c) >= 0 {
+			fmt.Fprintf(&b, "#%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// mimeOf strips parameters from a Content-Type value
+func mimeOf(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
@@ -0,0 +1,52 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngWithSize encodes a 1x1 PNG and rewrites its header to declare the given
+// dimensions, like a crafted upload would
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Signature (8), then the IHDR chunk: length (4), type (4), data (13), CRC (4)
+	ihdr := data[16:29]
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestAddImagePixelBudget(t *testing.T) {
+	p := &pdfFile{}
+	if _, width, height, ok := p.addImage(pngWithSize(t, 1, 1)); !ok || width != 1 || height != 1 {
+		t.Errorf("small PNG: %dx%d, ok %v; want 1x1 added", width, height, ok)
+	}
+
+	huge := pngWithSize(t, 30000, 30000)
+	if config, _, err := image.DecodeConfig(bytes.NewReader(huge)); err != nil || config.Width != 30000 {
+		t.Fatalf("crafted PNG doesn't declare 30000x30000: %+v, %v", config, err)
+	}
+	if _, _, _, ok := p.addImage(huge); ok {
+		t.Error("30000x30000 PNG was decoded, want it refused")
+	}
+}
+
+func TestRecordCount(t *testing.T) {
+	doc := &Document{Sections: []Section{
+		{Title: "Prescriptions", Items: make([]Item, 3)},
+		{Title: "Lab reports", Items: make([]Item, 2)},
+	}}
+	if got := doc.RecordCount(); got != 5 {
+		t.Errorf("RecordCount() = %d, want 5", got)
+	}
+}
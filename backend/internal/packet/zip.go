@@ -0,0 +1,50 @@
+package packet
+
+import (
+	"archive/zip"
+	"io"
+)
+
+// WriteZIP writes the packet as a ZIP archive: summary.txt plus the original
+// attachments under attachments/. Attachments are streamed one at a time, so
+// a failure part-way is noted in the summary rather than aborting the file.
+func WriteZIP(w io.Writer, doc *Document) error {
+	zw := zip.NewWriter(w)
+	note := make(map[string]string, len(doc.Attachments))
+
+	remaining := int64(maxTotalBytes)
+	for _, a := range doc.Attachments {
+		result := fetch(a, remaining)
+		if result.Err != nil {
+			note[a.Name] = "not included (" + result.Err.Error() + ")"
+			continue
+		}
+		remaining -= int64(len(result.Data))
+
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     "attachments/" + a.Name,
+			Method:   zip.Deflate,
+			Modified: doc.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(result.Data); err != nil {
+			return err
+		}
+		note[a.Name] = "included in attachments/"
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "summary.txt",
+		Method:   zip.Deflate,
+		Modified: doc.GeneratedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if err := doc.writeSummary(f, note); err != nil {
+		return err
+	}
+	return zw.Close()
+}
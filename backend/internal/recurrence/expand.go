@@ -0,0 +1,311 @@
+package recurrence
+
+import (
+	"sort"
+	"time"
+)
+
+// Expand returns the occurrences of a series starting at dtstart that fall
+// within [from, to]. dtstart carries the series' timezone, so occurrences keep
+// the same local clock time across DST changes. Exceptions (EXDATEs) are
+// matched to the second and still count towards COUNT, as in RFC 5545.
+func Expand(rule *Rule, dtstart, from, to time.Time, exceptions []time.Time) []time.Time {
+	var occurrences []time.Time
+	iterate(rule, dtstart, from, exceptions, func(t time.Time) bool {
+		if t.After(to) {
+			return false
+		}
+		if !t.Before(from) {
+			occurrences = append(occurrences, t)
+		}
+		return true
+	})
+	return occurrences
+}
+
+// Next returns the first occurrence of the series strictly after the given time
+func Next(rule *Rule, dtstart, after time.Time, exceptions []time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	iterate(rule, dtstart, after, exceptions, func(t time.Time) bool {
+		if t.After(after) {
+			next, found = t, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// LoadLocation resolves an IANA timezone name, treating an empty name as UTC
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// InLocation reads a wall-clock time, as stored in the timestamp columns, as a
+// local time in loc
+func InLocation(wall time.Time, loc *time.Location) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+}
+
+// WallClock is the inverse of InLocation: it keeps the local clock reading of t
+// and drops its zone so it can be stored in a timestamp column
+func WallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// iterate walks the occurrences in order, starting near from, calling fn
+// until it returns false or the rule is exhausted
+func iterate(rule *Rule, dtstart, from time.Time, exceptions []time.Time, fn func(time.Time) bool) {
+	loc := dtstart.Location()
+	until := rule.Until
+	if !until.IsZero() && rule.untilFloating {
+		until = InLocation(until, loc)
+	}
+
+	excluded := make(map[int64]bool, len(exceptions))
+	for _, ex := range exceptions {
+		excluded[ex.Unix()] = true
+	}
+
+	hour, minute, second := dtstart.Clock()
+	count := 0
+	empty := 0
+	for period := rule.firstPeriod(dtstart, from); empty < maxEmptyPeriods; period++ {
+		days := rule.periodDays(dtstart, period)
+		if len(days) == 0 {
+			empty++
+			continue
+		}
+		empty = 0
+		for _, day := range days {
+			t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, loc)
+			if t.Before(dtstart) {
+				continue
+			}
+			if !until.IsZero() && t.After(until) {
+				return
+			}
+			count++
+			if !excluded[t.Unix()] && !fn(t) {
+				return
+			}
+			if rule.Count > 0 && count >= rule.Count {
+				return
+			}
+		}
+	}
+}
+
+// firstPeriod is the earliest FREQ period that can hold an occurrence at or
+// after from, so long-running series aren't walked from the start. Rules with
+// COUNT always start at dtstart, since every earlier occurrence counts.
+func (r *Rule) firstPeriod(dtstart, from time.Time) int {
+	if r.Count > 0 || !from.After(dtstart) {
+		return 0
+	}
+	y1, m1, d1 := dtstart.Date()
+	y2, m2, d2 := from.In(dtstart.Location()).Date()
+	days := int((civilDate(y2, m2, d2).Unix() - civilDate(y1, m1, d1).Unix()) / 86400)
+
+	var n int
+	switch r.Freq {
+	case Daily:
+		n = days
+	case Weekly:
+		n = days / 7
+	case Monthly:
+		n = (y2-y1)*12 + int(m2-m1)
+	case Yearly:
+		n = y2 - y1
+	}
+	// Step back one period to stay clear of rounding at period boundaries
+	if n = n/r.Interval - 1; n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// periodDays returns the sorted candidate dates (at midnight UTC) for the
+// n-th FREQ period after the one containing dtstart
+func (r *Rule) periodDays(dtstart time.Time, n int) []time.Time {
+	step := n * r.Interval
+	year, month, day := dtstart.Date()
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		d := civilDate(year, month, day+step)
+		if r.matchesMonth(d.Month()) && r.matchesMonthDay(d) && r.matchesWeekday(d.Weekday()) {
+			days = append(days, d)
+		}
+	case Weekly:
+		start := civilDate(year, month, day-mondayOffset(dtstart.Weekday())+7*step)
+		if len(r.ByDay) == 0 {
+			days = append(days, start.AddDate(0, 0, mondayOffset(dtstart.Weekday())))
+		}
+		for _, wd := range r.ByDay {
+			days = append(days, start.AddDate(0, 0, mondayOffset(wd.Weekday)))
+		}
+		days = filterDays(days, func(d time.Time) bool { return r.matchesMonth(d.Month()) })
+	case Monthly:
+		first := civilDate(year, month+time.Month(step), 1)
+		if r.matchesMonth(first.Month()) {
+			days = r.monthDays(first.Year(), first.Month(), day)
+		}
+	case Yearly:
+		y := year + step
+		switch {
+		case len(r.ByMonth) > 0:
+			for _, m := range r.ByMonth {
+				days = append(days, r.monthDays(y, m, day)...)
+			}
+		case len(r.ByMonthDay) > 0:
+			// BYMONTHDAY without BYMONTH applies to every month
+			for m := time.January; m <= time.December; m++ {
+				days = append(days, r.monthDays(y, m, day)...)
+			}
+		case len(r.ByDay) > 0:
+			// BYDAY without BYMONTH counts weekdays across the whole year
+			days = weekdaysIn(civilDate(y, time.January, 1), civilDate(y+1, time.January, 1), r.ByDay)
+		default:
+			days = r.monthDays(y, month, day)
+		}
+	}
+
+	return sortedUnique(days)
+}
+
+// monthDays expands BYMONTHDAY and BYDAY within one month, intersecting them
+// when both are present and falling back to dtstart's day of month
+func (r *Rule) monthDays(year int, month time.Month, dtstartDay int) []time.Time {
+	first := civilDate(year, month, 1)
+	next := first.AddDate(0, 1, 0)
+	length := next.AddDate(0, 0, -1).Day()
+
+	var byMonthDay []time.Time
+	for _, d := range r.ByMonthDay {
+		if d < 0 {
+			d = length + 1 + d
+		}
+		if d >= 1 && d <= length {
+			byMonthDay = append(byMonthDay, civilDate(year, month, d))
+		}
+	}
+
+	switch {
+	case len(r.ByMonthDay) > 0 && len(r.ByDay) > 0:
+		byDay := weekdaysIn(first, next, r.ByDay)
+		return filterDays(byMonthDay, func(d time.Time) bool { return containsDay(byDay, d) })
+	case len(r.ByMonthDay) > 0:
+		return byMonthDay
+	case len(r.ByDay) > 0:
+		return weekdaysIn(first, next, r.ByDay)
+	case dtstartDay <= length:
+		// Months without dtstart's day (e.g. the 31st) are skipped, per RFC 5545
+		return []time.Time{civilDate(year, month, dtstartDay)}
+	}
+	return nil
+}
+
+// weekdaysIn lists the days in [start, end) matching BYDAY, honouring ordinals
+// such as 2TU (second Tuesday) or -1FR (last Friday) within the range
+func weekdaysIn(start, end time.Time, byDay []WeekdayNum) []time.Time {
+	var days []time.Time
+	for _, wd := range byDay {
+		var matches []time.Time
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			if d.Weekday() == wd.Weekday {
+				matches = append(matches, d)
+			}
+		}
+		switch {
+		case wd.N == 0:
+			days = append(days, matches...)
+		case wd.N > 0 && wd.N <= len(matches):
+			days = append(days, matches[wd.N-1])
+		case wd.N < 0 && -wd.N <= len(matches):
+			days = append(days, matches[len(matches)+wd.N])
+		}
+	}
+	return days
+}
+
+func (r *Rule) matchesMonth(m time.Month) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, month := range r.ByMonth {
+		if month == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthDay(d time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	length := civilDate(d.Year(), d.Month()+1, 0).Day()
+	for _, md := range r.ByMonthDay {
+		if md == d.Day() || (md < 0 && length+1+md == d.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesWeekday(w time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday == w {
+			return true
+		}
+	}
+	return false
+}
+
+func civilDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// mondayOffset is the number of days since Monday, the week start used here
+func mondayOffset(w time.Weekday) int {
+	return (int(w) + 6) % 7
+}
+
+func filterDays(days []time.Time, keep func(time.Time) bool) []time.Time {
+	var kept []time.Time
+	for _, d := range days {
+		if keep(d) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+func containsDay(days []time.Time, day time.Time) bool {
+	for _, d := range days {
+		if d.Equal(day) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedUnique(days []time.Time) []time.Time {
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	unique := days[:0]
+	for i, d := range days {
+		if i == 0 || !d.Equal(days[i-1]) {
+			unique = append(unique, d)
+		}
+	}
+	return unique
+}
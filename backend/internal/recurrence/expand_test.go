@@ -0,0 +1,309 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestExpand(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	utc := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	ny := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name       string
+		rule       string
+		dtstart    time.Time
+		from, to   time.Time
+		exceptions []time.Time
+		want       []time.Time
+	}{
+		{
+			name:    "daily keeps local time into DST",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: ny(2024, time.March, 9, 9),
+			from:    ny(2024, time.March, 1, 0),
+			to:      ny(2024, time.April, 1, 0),
+			want:    []time.Time{ny(2024, time.March, 9, 9), ny(2024, time.March, 10, 9), ny(2024, time.March, 11, 9)},
+		},
+		{
+			name:    "weekly keeps local time out of DST",
+			rule:    "FREQ=WEEKLY;COUNT=2",
+			dtstart: ny(2024, time.October, 28, 9),
+			from:    ny(2024, time.October, 1, 0),
+			to:      ny(2024, time.December, 1, 0),
+			want:    []time.Time{ny(2024, time.October, 28, 9), ny(2024, time.November, 4, 9)},
+		},
+		{
+			name:    "last friday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR;COUNT=4",
+			dtstart: utc(2024, time.January, 26, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2025, time.January, 1, 0),
+			want: []time.Time{
+				utc(2024, time.January, 26, 10), utc(2024, time.February, 23, 10),
+				utc(2024, time.March, 29, 10), utc(2024, time.April, 26, 10),
+			},
+		},
+		{
+			name:    "second monday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=2MO;COUNT=3",
+			dtstart: utc(2024, time.January, 8, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2025, time.January, 1, 0),
+			want:    []time.Time{utc(2024, time.January, 8, 10), utc(2024, time.February, 12, 10), utc(2024, time.March, 11, 10)},
+		},
+		{
+			name:    "second monday after dtstart in the month",
+			rule:    "FREQ=MONTHLY;BYDAY=2MO;COUNT=2",
+			dtstart: utc(2024, time.January, 20, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2025, time.January, 1, 0),
+			want:    []time.Time{utc(2024, time.February, 12, 10), utc(2024, time.March, 11, 10)},
+		},
+		{
+			name:    "count",
+			rule:    "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=4",
+			dtstart: utc(2024, time.January, 2, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2024, time.February, 1, 0),
+			want: []time.Time{
+				utc(2024, time.January, 2, 10), utc(2024, time.January, 4, 10),
+				utc(2024, time.January, 9, 10), utc(2024, time.January, 11, 10),
+			},
+		},
+		{
+			name:    "until before the last candidate",
+			rule:    "FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20240111T000000Z",
+			dtstart: utc(2024, time.January, 2, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2024, time.February, 1, 0),
+			want:    []time.Time{utc(2024, time.January, 2, 10), utc(2024, time.January, 4, 10), utc(2024, time.January, 9, 10)},
+		},
+		{
+			name:    "date-only until includes the day",
+			rule:    "FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20240111",
+			dtstart: utc(2024, time.January, 2, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2024, time.February, 1, 0),
+			want: []time.Time{
+				utc(2024, time.January, 2, 10), utc(2024, time.January, 4, 10),
+				utc(2024, time.January, 9, 10), utc(2024, time.January, 11, 10),
+			},
+		},
+		{
+			name:    "floating until is read in the series timezone",
+			rule:    "FREQ=DAILY;UNTIL=20240103T090000",
+			dtstart: ny(2024, time.January, 1, 9),
+			from:    ny(2024, time.January, 1, 0),
+			to:      ny(2024, time.February, 1, 0),
+			want:    []time.Time{ny(2024, time.January, 1, 9), ny(2024, time.January, 2, 9), ny(2024, time.January, 3, 9)},
+		},
+		{
+			name:       "exceptions still count towards COUNT",
+			rule:       "FREQ=DAILY;COUNT=3",
+			dtstart:    utc(2024, time.January, 1, 10),
+			from:       utc(2024, time.January, 1, 0),
+			to:         utc(2024, time.February, 1, 0),
+			exceptions: []time.Time{utc(2024, time.January, 2, 10)},
+			want:       []time.Time{utc(2024, time.January, 1, 10), utc(2024, time.January, 3, 10)},
+		},
+		{
+			name:       "exceptions in another timezone match the same instant",
+			rule:       "FREQ=DAILY;COUNT=2",
+			dtstart:    ny(2024, time.January, 1, 9),
+			from:       ny(2024, time.January, 1, 0),
+			to:         ny(2024, time.February, 1, 0),
+			exceptions: []time.Time{utc(2024, time.January, 1, 14)},
+			want:       []time.Time{ny(2024, time.January, 2, 9)},
+		},
+		{
+			name:    "window",
+			rule:    "FREQ=DAILY;INTERVAL=2",
+			dtstart: utc(2024, time.January, 1, 10),
+			from:    utc(2024, time.January, 4, 0),
+			to:      utc(2024, time.January, 9, 0),
+			want:    []time.Time{utc(2024, time.January, 5, 10), utc(2024, time.January, 7, 10)},
+		},
+		{
+			name:    "months without the day are skipped",
+			rule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart: utc(2024, time.January, 31, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2025, time.January, 1, 0),
+			want:    []time.Time{utc(2024, time.January, 31, 10), utc(2024, time.March, 31, 10), utc(2024, time.May, 31, 10)},
+		},
+		{
+			name:    "yearly by month day without by month covers every month",
+			rule:    "FREQ=YEARLY;BYMONTHDAY=1;COUNT=3",
+			dtstart: utc(2024, time.January, 1, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2025, time.January, 1, 0),
+			want:    []time.Time{utc(2024, time.January, 1, 10), utc(2024, time.February, 1, 10), utc(2024, time.March, 1, 10)},
+		},
+		{
+			name:    "leap days",
+			rule:    "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29",
+			dtstart: utc(2024, time.February, 29, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2034, time.January, 1, 0),
+			want:    []time.Time{utc(2024, time.February, 29, 10), utc(2028, time.February, 29, 10), utc(2032, time.February, 29, 10)},
+		},
+		{
+			name:    "daily series decades after it started",
+			rule:    "FREQ=DAILY",
+			dtstart: utc(1990, time.January, 1, 8),
+			from:    utc(2030, time.June, 1, 0),
+			to:      utc(2030, time.June, 3, 23),
+			want:    []time.Time{utc(2030, time.June, 1, 8), utc(2030, time.June, 2, 8), utc(2030, time.June, 3, 8)},
+		},
+		{
+			name:    "weekly series decades after it started",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO",
+			dtstart: utc(1990, time.January, 1, 8), // a Monday
+			from:    utc(2090, time.January, 1, 0),
+			to:      utc(2090, time.January, 31, 0),
+			want:    []time.Time{utc(2090, time.January, 2, 8), utc(2090, time.January, 16, 8), utc(2090, time.January, 30, 8)},
+		},
+		{
+			name:    "rule that never matches",
+			rule:    "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			dtstart: utc(2024, time.January, 1, 10),
+			from:    utc(2024, time.January, 1, 0),
+			to:      utc(2100, time.January, 1, 0),
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+			got := Expand(rule, tt.dtstart, tt.from, tt.to, tt.exceptions)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d occurrences %v, want %d %v", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("occurrence %d = %v, want %v", i, got[i], tt.want[i])
+				}
+				if got[i].Location() != tt.dtstart.Location() {
+					t.Errorf("occurrence %d is in %v, want %v", i, got[i].Location(), tt.dtstart.Location())
+				}
+			}
+		})
+	}
+}
+
+// TestExpandWindowMatchesFullWalk checks that starting the walk near the
+// window, rather than at dtstart, finds the same occurrences
+func TestExpandWindowMatchesFullWalk(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	dtstart := time.Date(2001, time.March, 31, 7, 30, 0, 0, newYork)
+	rules := []string{
+		"FREQ=DAILY;INTERVAL=3",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU",
+		"FREQ=WEEKLY;INTERVAL=5",
+		"FREQ=MONTHLY;INTERVAL=7",
+		"FREQ=MONTHLY;BYDAY=-1SA",
+		"FREQ=MONTHLY;BYMONTHDAY=1,-1",
+		"FREQ=YEARLY;INTERVAL=3;BYMONTH=3;BYDAY=-1SU",
+		"FREQ=YEARLY;BYDAY=10TU",
+		"FREQ=DAILY;BYMONTH=3;BYDAY=SU",
+	}
+	from := time.Date(2019, time.February, 27, 0, 0, 0, 0, newYork)
+	to := time.Date(2020, time.April, 2, 0, 0, 0, 0, newYork)
+
+	for _, text := range rules {
+		rule, err := Parse(text)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", text, err)
+		}
+		var want []time.Time
+		for _, occ := range Expand(rule, dtstart, dtstart, to, nil) {
+			if !occ.Before(from) {
+				want = append(want, occ)
+			}
+		}
+		got := Expand(rule, dtstart, from, to, nil)
+		if len(got) != len(want) {
+			t.Errorf("%s: got %d occurrences, want %d", text, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(want[i]) {
+				t.Errorf("%s: occurrence %d = %v, want %v", text, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestExpandDSTOffsets(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	rule, err := Parse("FREQ=DAILY;COUNT=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtstart := time.Date(2024, time.March, 9, 9, 0, 0, 0, newYork)
+	got := Expand(rule, dtstart, dtstart, dtstart.AddDate(0, 0, 7), nil)
+	want := []string{"2024-03-09T14:00:00Z", "2024-03-10T13:00:00Z"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if s := got[i].UTC().Format(time.RFC3339); s != want[i] {
+			t.Errorf("occurrence %d = %s, want %s", i, s, want[i])
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name       string
+		rule       string
+		dtstart    time.Time
+		after      time.Time
+		exceptions []time.Time
+		want       time.Time
+		ok         bool
+	}{
+		{"first after dtstart", "FREQ=WEEKLY", utc(2024, time.January, 1, 9), utc(2024, time.January, 1, 9), nil, utc(2024, time.January, 8, 9), true},
+		{"before dtstart", "FREQ=WEEKLY", utc(2024, time.January, 1, 9), utc(2023, time.June, 1, 0), nil, utc(2024, time.January, 1, 9), true},
+		{"skips an exception", "FREQ=DAILY", utc(2024, time.January, 1, 9), utc(2024, time.January, 1, 9), []time.Time{utc(2024, time.January, 2, 9)}, utc(2024, time.January, 3, 9), true},
+		{"count used up", "FREQ=DAILY;COUNT=2", utc(2024, time.January, 1, 9), utc(2024, time.January, 2, 9), nil, time.Time{}, false},
+		{"past until", "FREQ=DAILY;UNTIL=20240105T000000Z", utc(2024, time.January, 1, 9), utc(2024, time.January, 4, 9), nil, time.Time{}, false},
+		{"far in the future", "FREQ=DAILY", utc(1990, time.January, 1, 9), utc(2060, time.March, 1, 12), nil, utc(2060, time.March, 2, 9), true},
+		{"count far in the future", "FREQ=MONTHLY;COUNT=600", utc(1990, time.January, 15, 9), utc(2030, time.January, 1, 0), nil, utc(2030, time.January, 15, 9), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+			got, ok := Next(rule, tt.dtstart, tt.after, tt.exceptions)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("Next = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
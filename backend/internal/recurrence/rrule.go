@@ -0,0 +1,282 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ part of an RFC 5545 recurrence rule
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is a BYDAY entry such as MO, 2TU or -1FR
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int // 0 = every matching weekday in the period
+}
+
+// Rule is a parsed RRULE. Only the parts the app needs are supported:
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH, and WKST where
+// it makes no difference. Other parts are rejected.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+
+	// untilFloating marks an UNTIL given without a "Z", which is read in the
+	// timezone of the series rather than as UTC
+	untilFloating bool
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// maxEmptyPeriods bounds how many FREQ periods in a row can pass without a
+// candidate date, so a rule that never matches can't spin forever. Sparse
+// rules, such as every February 29th, stay well within it.
+const maxEmptyPeriods = 10000
+
+// Parse parses an RRULE value, with or without the leading "RRULE:"
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "RRULE:"), "rrule:")
+	if s == "" {
+		return nil, errors.New("empty recurrence rule")
+	}
+
+	rule := &Rule{Interval: 1}
+	wkst := "MO"
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		switch key {
+		case "FREQ":
+			switch Frequency(value) {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = Frequency(value)
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", value)
+			}
+			rule.Count = n
+		case "UNTIL":
+			t, floating, err := parseUntil(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.Until = t
+			rule.untilFloating = floating
+		case "BYDAY":
+			for _, item := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(item)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, item := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %q", item)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, item := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("invalid BYMONTH %q", item)
+				}
+				rule.ByMonth = append(rule.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			if _, ok := weekdayCodes[value]; !ok {
+				return nil, fmt.Errorf("invalid WKST %q", value)
+			}
+			wkst = value
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, errors.New("recurrence rule requires FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, errors.New("recurrence rule cannot have both COUNT and UNTIL")
+	}
+	// Parts that would change the series but aren't applied are rejected
+	// rather than ignored
+	for _, wd := range rule.ByDay {
+		if wd.N != 0 && rule.Freq != Monthly && rule.Freq != Yearly {
+			return nil, errors.New("numbered BYDAY is only valid with MONTHLY or YEARLY")
+		}
+		// Ordinals count within a month unless a YEARLY rule has no BYMONTH
+		inMonth := rule.Freq == Monthly || len(rule.ByMonth) > 0
+		if inMonth && (wd.N > 5 || wd.N < -5) {
+			return nil, fmt.Errorf("BYDAY %s can't fall within a month", wd)
+		}
+	}
+	if rule.Freq == Weekly && len(rule.ByMonthDay) > 0 {
+		return nil, errors.New("BYMONTHDAY is not valid with WEEKLY")
+	}
+	// Weeks start on Monday here, which only matters for a multi-day weekly
+	// rule that skips weeks
+	if wkst != "MO" && rule.Freq == Weekly && rule.Interval > 1 && len(rule.ByDay) > 1 {
+		return nil, fmt.Errorf("unsupported WKST %q; weeks start on Monday", wkst)
+	}
+
+	return rule, nil
+}
+
+// FromInterval maps the legacy free-form RecurrenceInterval values onto a rule
+func FromInterval(interval string) (*Rule, error) {
+	switch strings.ToLower(strings.TrimSpace(interval)) {
+	case "daily":
+		return &Rule{Freq: Daily, Interval: 1}, nil
+	case "weekly":
+		return &Rule{Freq: Weekly, Interval: 1}, nil
+	case "monthly":
+		return &Rule{Freq: Monthly, Interval: 1}, nil
+	case "quarterly":
+		return &Rule{Freq: Monthly, Interval: 3}, nil
+	case "yearly", "annually":
+		return &Rule{Freq: Yearly, Interval: 1}, nil
+	}
+	return nil, fmt.Errorf("unknown recurrence interval %q", interval)
+}
+
+// String renders the rule back to RRULE syntax (without the "RRULE:" prefix)
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.untilFloating {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = wd.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]string, len(r.ByMonth))
+		for i, m := range r.ByMonth {
+			months[i] = strconv.Itoa(int(m))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// InLocation returns a copy of the rule with a floating UNTIL resolved to UTC
+// in loc, as RFC 5545 requires when DTSTART has a TZID
+func (r *Rule) InLocation(loc *time.Location) *Rule {
+	resolved := *r
+	if !r.Until.IsZero() && r.untilFloating {
+		resolved.Until = InLocation(r.Until, loc).UTC()
+		resolved.untilFloating = false
+	}
+	return &resolved
+}
+
+func (wd WeekdayNum) String() string {
+	for code, day := range weekdayCodes {
+		if day == wd.Weekday {
+			if wd.N != 0 {
+				return strconv.Itoa(wd.N) + code
+			}
+			return code
+		}
+	}
+	return ""
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	day, ok := weekdayCodes[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	wd := WeekdayNum{Weekday: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+func parseUntil(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("20060102T150405", s); err == nil {
+		return t, true, nil
+	}
+	// A date-only UNTIL includes the whole day
+	if t, err := time.Parse("20060102", s); err == nil {
+		return t.Add(24*time.Hour - time.Second), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid UNTIL %q", s)
+}
@@ -0,0 +1,84 @@
+package recurrence
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // String() of the parsed rule; empty when an error is expected
+	}{
+		{"prefix and defaults", "RRULE:FREQ=WEEKLY;BYDAY=MO,WE", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"lower case", "freq=daily;interval=2;count=5", "FREQ=DAILY;INTERVAL=2;COUNT=5"},
+		{"last friday", "FREQ=MONTHLY;BYDAY=-1FR", "FREQ=MONTHLY;BYDAY=-1FR"},
+		{"second monday", "FREQ=MONTHLY;BYDAY=2MO", "FREQ=MONTHLY;BYDAY=2MO"},
+		{"utc until", "FREQ=DAILY;UNTIL=20240111T000000Z", "FREQ=DAILY;UNTIL=20240111T000000Z"},
+		{"floating until", "FREQ=DAILY;UNTIL=20240111T090000", "FREQ=DAILY;UNTIL=20240111T090000"},
+		{"yearly by month and day", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1", "FREQ=YEARLY;BYMONTHDAY=-1;BYMONTH=2"},
+		{"yearly ordinal across the year", "FREQ=YEARLY;BYDAY=20MO", "FREQ=YEARLY;BYDAY=20MO"},
+		{"wkst that makes no difference", "FREQ=WEEKLY;WKST=SU;BYDAY=MO", "FREQ=WEEKLY;BYDAY=MO"},
+
+		{"empty", "", ""},
+		{"no freq", "INTERVAL=2", ""},
+		{"unsupported freq", "FREQ=HOURLY", ""},
+		{"count and until", "FREQ=DAILY;COUNT=2;UNTIL=20240101", ""},
+		{"zero count", "FREQ=DAILY;COUNT=0", ""},
+		{"bad interval", "FREQ=DAILY;INTERVAL=0", ""},
+		{"month day out of range", "FREQ=MONTHLY;BYMONTHDAY=32", ""},
+		{"month out of range", "FREQ=YEARLY;BYMONTH=13", ""},
+		{"numbered byday with weekly", "FREQ=WEEKLY;BYDAY=2MO", ""},
+		{"numbered byday with daily", "FREQ=DAILY;BYDAY=-1FR", ""},
+		{"ordinal past a month", "FREQ=MONTHLY;BYDAY=6MO", ""},
+		{"ordinal past a month with bymonth", "FREQ=YEARLY;BYMONTH=3;BYDAY=-6SU", ""},
+		{"bymonthday with weekly", "FREQ=WEEKLY;BYMONTHDAY=15", ""},
+		{"unsupported part", "FREQ=MONTHLY;BYDAY=MO;BYSETPOS=1", ""},
+		{"unsupported wkst", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU;WKST=SU", ""},
+		{"invalid wkst", "FREQ=WEEKLY;WKST=XX", ""},
+		{"invalid byday", "FREQ=WEEKLY;BYDAY=MOO", ""},
+		{"missing value", "FREQ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.in)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %s, want an error", tt.in, rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.in, err)
+			}
+			if got := rule.String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromInterval(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"daily", "FREQ=DAILY"},
+		{"Weekly", "FREQ=WEEKLY"},
+		{"monthly", "FREQ=MONTHLY"},
+		{"quarterly", "FREQ=MONTHLY;INTERVAL=3"},
+		{" annually ", "FREQ=YEARLY"},
+	}
+	for _, tt := range tests {
+		rule, err := FromInterval(tt.in)
+		if err != nil {
+			t.Errorf("FromInterval(%q): %v", tt.in, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("FromInterval(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if _, err := FromInterval("fortnightly"); err == nil {
+		t.Error("FromInterval(fortnightly) succeeded, want an error")
+	}
+}
@@ -2,13 +2,16 @@ package router
 
 import (
 	"medical-records-app/internal/config"
+	"medical-records-app/internal/events"
 	"medical-records-app/internal/handlers"
 	"medical-records-app/internal/middleware"
+	"medical-records-app/internal/notify"
 	"medical-records-app/internal/services"
+	"medical-records-app/internal/storage"
+	"medical-records-app/internal/webhook"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	swaggerFiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
 	"gorm.io/gorm"
 )
 
@@ -26,18 +29,97 @@ func Initialize(db *gorm.DB, cfg *config.Config) *gin.Engine {
 
 	// Initialize services
 	userService := services.NewUserService(db)
+	sessionService := services.NewSessionService(
+		db,
+		time.Duration(cfg.JWT.AccessTokenMinutes)*time.Minute,
+		time.Duration(cfg.JWT.RefreshTokenDays)*24*time.Hour,
+	)
 	recordService := services.NewRecordService(db)
-	sharingService := services.NewSharingService(db)
 	medicationService := services.NewMedicationService(db)
 	reminderService := services.NewReminderService(db)
+	notificationService := services.NewNotificationService(
+		db,
+		notify.FromConfig(cfg),
+		cfg.Notification.MaxAttempts,
+		time.Duration(cfg.Notification.RetryBaseSeconds)*time.Second,
+	)
+	oneTimeCodeService := services.NewOneTimeCodeService(db)
+	fileStore := storage.New(cfg.AWS, webhook.NewClient(2*time.Minute, cfg.Server.Env != "production"))
+	sharingService := services.NewSharingService(db, notificationService, oneTimeCodeService, fileStore, cfg.Server.FrontendURL, cfg.Server.PublicURL)
+	notificationService.AddObserver(sharingService.HandleDelivery)
+	eventService := services.NewEventService(db, events.NewBroker())
+	webhookService := services.NewWebhookService(
+		db,
+		webhook.NewClient(time.Duration(cfg.Webhook.TimeoutSeconds)*time.Second, cfg.Webhook.AllowPrivateTargets),
+		cfg.Webhook.MaxAttempts,
+		time.Duration(cfg.Webhook.RetryBaseSeconds)*time.Second,
+	)
+	eventService.AddSink(webhookService.HandleEvent)
+	reminderDispatcher := services.NewReminderDispatcher(db, notificationService, eventService)
+	calendarService := services.NewCalendarService(db)
+	preferenceService := services.NewPreferenceService(db)
+	emergencyContactService := services.NewEmergencyContactService(db)
+	verificationService := services.NewVerificationService(
+		db,
+		notificationService,
+		oneTimeCodeService,
+		cfg.Server.FrontendURL,
+		time.Duration(cfg.Verification.EmailTokenHours)*time.Hour,
+		time.Duration(cfg.Verification.PhoneCodeMinutes)*time.Minute,
+		time.Duration(cfg.Verification.ResendCooldownSeconds)*time.Second,
+		cfg.Verification.MaxSendsPerDay,
+	)
+	mfaService := services.NewMFAService(db, time.Duration(cfg.MFA.ChallengeMinutes)*time.Minute)
+	passwordService := services.NewPasswordService(
+		db,
+		notificationService,
+		sessionService,
+		cfg.Server.FrontendURL,
+		time.Duration(cfg.Verification.PasswordResetMinutes)*time.Minute,
+		time.Duration(cfg.Verification.ResendCooldownSeconds)*time.Second,
+		cfg.Verification.MaxSendsPerDay,
+	)
+
+	// Background workers need the database; skip them if it is unavailable
+	if db != nil {
+		notificationService.Start(30 * time.Second)
+		sessionService.Start(time.Hour)
+		passwordService.Start(time.Hour)
+		eventService.Start()
+		webhookService.Start(15 * time.Second)
+		reminderDispatcher.Start(time.Minute)
+		sharingService.Start(5 * time.Minute)
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userService, cfg)
-	recordHandler := handlers.NewRecordHandler(recordService)
-	sharingHandler := handlers.NewSharingHandler(sharingService)
+	authHandler := handlers.NewAuthHandler(userService, sessionService, verificationService, passwordService, mfaService, cfg)
+	recordHandler := handlers.NewRecordHandler(recordService, eventService)
+	sharingHandler := handlers.NewSharingHandler(sharingService, eventService)
 	dashboardHandler := handlers.NewDashboardHandler(recordService, medicationService, reminderService)
 	medicationHandler := handlers.NewMedicationHandler(medicationService)
 	reminderHandler := handlers.NewReminderHandler(reminderService)
+	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	calendarHandler := handlers.NewCalendarHandler(calendarService, eventService, cfg)
+	preferenceHandler := handlers.NewPreferenceHandler(preferenceService)
+	emergencyContactHandler := handlers.NewEmergencyContactHandler(emergencyContactService)
+	eventHandler := handlers.NewEventHandler(eventService, sessionService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+
+	// Features limited to verified accounts by REQUIRE_VERIFIED_EMAIL_FOR
+	requireVerified := func(feature string) gin.HandlerFunc {
+		if !cfg.Verification.RequiresVerifiedEmail(feature) {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return middleware.RequireVerifiedEmail(userService)
+	}
+
+	// Features that need a second factor by REQUIRE_MFA_FOR
+	requireMFA := func(feature string) gin.HandlerFunc {
+		if !cfg.MFA.RequiresMFA(feature) {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return middleware.RequireMFA(userService)
+	}
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -61,12 +143,33 @@ func Initialize(db *gorm.DB, cfg *config.Config) *gin.Engine {
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
-			auth.GET("/profile", middleware.AuthMiddleware(), authHandler.GetProfile)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", authHandler.Logout)
+			auth.POST("/password/forgot", authHandler.ForgotPassword)
+			auth.POST("/password/reset", authHandler.ResetPassword)
+			auth.POST("/password/change", middleware.AuthMiddleware(sessionService), authHandler.ChangePassword)
+			auth.POST("/mfa/verify", authHandler.VerifyMFA)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/verify-email/resend", middleware.AuthMiddleware(sessionService), authHandler.ResendEmailVerification)
+			auth.PUT("/phone", middleware.AuthMiddleware(sessionService), authHandler.ChangePhone)
+			auth.POST("/verify-phone/send", middleware.AuthMiddleware(sessionService), authHandler.SendPhoneCode)
+			auth.POST("/verify-phone", middleware.AuthMiddleware(sessionService), authHandler.VerifyPhone)
+
+			mfa := auth.Group("/mfa", middleware.AuthMiddleware(sessionService))
+			{
+				mfa.GET("", authHandler.GetMFAStatus)
+				mfa.POST("/enroll", authHandler.EnrollMFA)
+				mfa.POST("/enroll/confirm", authHandler.ConfirmMFA)
+				mfa.POST("/disable", authHandler.DisableMFA)
+				mfa.POST("/recovery-codes", authHandler.RegenerateRecoveryCodes)
+				mfa.POST("/step-up", authHandler.StepUpMFA)
+			}
+			auth.GET("/profile", middleware.AuthMiddleware(sessionService), authHandler.GetProfile)
 		}
 
 		// Protected routes
 		protected := api.Group("")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(sessionService))
 		{
 			// Dashboard
 			protected.GET("/dashboard", dashboardHandler.GetDashboard)
@@ -81,6 +184,9 @@ func Initialize(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			// Appointments
 			protected.POST("/appointments", recordHandler.CreateAppointment)
 			protected.GET("/appointments", recordHandler.GetAppointments)
+			protected.GET("/appointments/:id/ics", calendarHandler.GetAppointmentICS)
+			protected.POST("/appointments/import/preview", calendarHandler.PreviewAppointmentImport)
+			protected.POST("/appointments/import", calendarHandler.ImportAppointments)
 
 			// Lab Reports
 			protected.POST("/lab-reports", recordHandler.CreateLabReport)
@@ -99,15 +205,76 @@ func Initialize(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			protected.POST("/reminders", reminderHandler.CreateReminder)
 			protected.GET("/reminders", reminderHandler.GetReminders)
 			protected.GET("/reminders/upcoming", reminderHandler.GetUpcomingReminders)
+			protected.GET("/reminders/occurrences", reminderHandler.GetOccurrences)
+			protected.POST("/reminders/:id/complete", reminderHandler.CompleteReminder)
+			protected.POST("/reminders/:id/skip", reminderHandler.SkipOccurrence)
+			protected.POST("/reminders/:id/snooze", reminderHandler.SnoozeReminder)
+			protected.POST("/reminders/:id/acknowledge", reminderHandler.AcknowledgeReminder)
+			protected.POST("/reminders/:id/dismiss", reminderHandler.DismissReminder)
+			protected.GET("/reminders/:id/history", reminderHandler.GetReminderHistory)
+			protected.GET("/reminders/:id/ics", calendarHandler.GetReminderICS)
+
+			// Calendar subscription
+			protected.GET("/calendar/subscription", calendarHandler.GetSubscription)
+			protected.POST("/calendar/subscription/rotate", calendarHandler.RotateSubscription)
+			protected.DELETE("/calendar/subscription", calendarHandler.DisableSubscription)
 
 			// Sharing
-			protected.POST("/sharing/create", sharingHandler.CreateShareLink)
+			protected.POST("/sharing/create", requireVerified("sharing"), requireMFA("sharing"), sharingHandler.CreateShareLink)
 			protected.GET("/sharing/my-shares", sharingHandler.GetMySharedRecords)
+			protected.GET("/sharing/analytics", sharingHandler.GetSharingAnalytics)
+			protected.GET("/sharing/inbox", sharingHandler.GetInbox)
+			protected.GET("/sharing/inbox/:id", sharingHandler.GetInboxShare)
+			protected.POST("/sharing/inbox/:id/accept", sharingHandler.AcceptShare)
+			protected.POST("/sharing/inbox/:id/decline", sharingHandler.DeclineShare)
+			protected.GET("/sharing/inbox/:id/files/:recordType/:recordId", sharingHandler.PreviewInboxFile)
+			protected.GET("/sharing/inbox/:id/files/:recordType/:recordId/download", requireMFA("export"), sharingHandler.DownloadInboxFile)
+			protected.GET("/sharing/inbox/:id/packet", requireMFA("export"), sharingHandler.ExportInboxPacket)
+			protected.PUT("/sharing/:id", requireVerified("sharing"), requireMFA("sharing"), sharingHandler.UpdateShareLink)
 			protected.POST("/sharing/:id/revoke", sharingHandler.RevokeShareLink)
+			protected.POST("/sharing/:id/resend", requireVerified("sharing"), requireMFA("sharing"), sharingHandler.ResendShareLink)
+			protected.GET("/sharing/:id/packet", requireMFA("export"), sharingHandler.ExportMySharePacket)
+			protected.GET("/sharing/:id/analytics", sharingHandler.GetShareAnalytics)
+
+			// Notifications
+			protected.GET("/notifications/deliveries", notificationHandler.GetDeliveries)
+
+			// Preferences
+			protected.GET("/preferences", preferenceHandler.GetPreferences)
+			protected.PUT("/preferences", preferenceHandler.UpdatePreferences)
+
+			// Webhooks
+			protected.POST("/webhooks", requireVerified("webhooks"), webhookHandler.CreateEndpoint)
+			protected.GET("/webhooks", webhookHandler.GetEndpoints)
+			protected.GET("/webhooks/:id", webhookHandler.GetEndpoint)
+			protected.PUT("/webhooks/:id", requireVerified("webhooks"), webhookHandler.UpdateEndpoint)
+			protected.DELETE("/webhooks/:id", webhookHandler.DeleteEndpoint)
+			protected.POST("/webhooks/:id/rotate-secret", webhookHandler.RotateSecret)
+			protected.POST("/webhooks/:id/test", requireVerified("webhooks"), webhookHandler.TestEndpoint)
+			protected.GET("/webhooks/:id/deliveries", webhookHandler.GetDeliveries)
+			protected.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", webhookHandler.Redeliver)
+
+			// Emergency contacts
+			protected.POST("/emergency-contacts", emergencyContactHandler.CreateContact)
+			protected.GET("/emergency-contacts", emergencyContactHandler.GetContacts)
+			protected.PUT("/emergency-contacts/:id", emergencyContactHandler.UpdateContact)
+			protected.DELETE("/emergency-contacts/:id", emergencyContactHandler.DeleteContact)
 		}
 
+		// Event stream; EventSource can't set headers, so the token may also
+		// be passed as a query parameter
+		api.GET("/events/stream", middleware.StreamAuthMiddleware(sessionService), eventHandler.Stream)
+
 		// Public share access
 		api.GET("/share/:token", sharingHandler.GetSharedRecord)
+		api.POST("/share/:token/code", sharingHandler.RequestShareCode)
+		api.POST("/share/:token/verify", sharingHandler.VerifyShareAccess)
+		api.GET("/share/:token/files/:recordType/:id", sharingHandler.PreviewSharedFile)
+		api.GET("/share/:token/files/:recordType/:id/download", sharingHandler.DownloadSharedFile)
+		api.GET("/share/:token/packet", sharingHandler.ExportSharedPacket)
+
+		// Calendar feed (authenticated by its secret token)
+		api.GET("/calendar/feed/:token", calendarHandler.GetFeed)
 	}
 
 	return r
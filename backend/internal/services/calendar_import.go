@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/ical"
+	"medical-records-app/internal/recurrence"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Import item statuses
+const (
+	ImportNew       = "new"
+	ImportDuplicate = "duplicate"
+	ImportCancelled = "cancelled"
+	ImportSkipped   = "skipped"
+	ImportImported  = "imported"
+)
+
+// AppointmentImportItem is one VEVENT mapped onto an appointment, with the
+// outcome of duplicate detection
+type AppointmentImportItem struct {
+	UID             string            `json:"uid"`
+	DoctorName      string            `json:"doctor_name"`
+	Specialty       string            `json:"specialty"`
+	Location        string            `json:"location"`
+	AppointmentDate database.DateTime `json:"appointment_date"`
+	Notes           string            `json:"notes"`
+	Status          string            `json:"status"` // new, duplicate, cancelled, skipped, imported
+	DuplicateOf     *uuid.UUID        `json:"duplicate_of,omitempty"`
+	DuplicateReason string            `json:"duplicate_reason,omitempty"` // uid, time_and_doctor
+	AppointmentID   *uuid.UUID        `json:"appointment_id,omitempty"`
+	Recurrence      string            `json:"recurrence,omitempty"` // RRULE of a repeating event
+	Warning         string            `json:"warning,omitempty"`
+}
+
+// recurringImportWarning explains that a repeating event becomes a single
+// appointment. Appointments don't repeat, and one appointment per UID keeps
+// re-imports of the same calendar recognisable as duplicates.
+const recurringImportWarning = "this event repeats; only its first occurrence is imported"
+
+// PreviewAppointmentImport maps the events onto appointments without saving
+// anything, flagging duplicates of existing appointments and of each other
+func (s *CalendarService) PreviewAppointmentImport(userID uuid.UUID, events []ical.Event) ([]AppointmentImportItem, error) {
+	return s.planImport(s.db, userID, events)
+}
+
+// ImportAppointments creates appointments for the new items. When uids is
+// non-empty only events with those UIDs are imported; the rest are skipped.
+func (s *CalendarService) ImportAppointments(userID uuid.UUID, events []ical.Event, uids []string) ([]AppointmentImportItem, error) {
+	selected := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		selected[uid] = true
+	}
+
+	var items []AppointmentImportItem
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		items, err = s.planImport(tx, userID, events)
+		if err != nil {
+			return err
+		}
+
+		for i := range items {
+			item := &items[i]
+			if item.Status != ImportNew {
+				continue
+			}
+			if len(selected) > 0 && !selected[item.UID] {
+				item.Status = ImportSkipped
+				continue
+			}
+
+			appointment := &database.Appointment{
+				ID:              uuid.New(),
+				UserID:          userID,
+				DoctorName:      item.DoctorName,
+				Specialty:       item.Specialty,
+				Location:        item.Location,
+				AppointmentDate: item.AppointmentDate,
+				Notes:           item.Notes,
+				ExternalUID:     item.UID,
+				CreatedAt:       time.Now(),
+				UpdatedAt:       time.Now(),
+			}
+			if err := tx.Create(appointment).Error; err != nil {
+				return err
+			}
+			item.Status = ImportImported
+			item.AppointmentID = &appointment.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *CalendarService) planImport(db *gorm.DB, userID uuid.UUID, events []ical.Event) ([]AppointmentImportItem, error) {
+	// Appointment dates are wall-clock times in the user's timezone
+	loc := userLocation(db, userID)
+	seenUIDs := make(map[string]bool)
+	seenSlots := make(map[string]bool)
+
+	items := make([]AppointmentImportItem, 0, len(events))
+	for _, event := range events {
+		item := appointmentFromEvent(event, loc)
+
+		slot := item.AppointmentDate.Time.Format(time.RFC3339) + "|" + strings.ToLower(item.DoctorName)
+		switch {
+		case event.Status == "CANCELLED":
+			item.Status = ImportCancelled
+		case item.UID != "" && seenUIDs[item.UID]:
+			// Overrides of a recurring event repeat the UID within one file
+			item.Status, item.DuplicateReason = ImportDuplicate, "uid"
+		case seenSlots[slot]:
+			item.Status, item.DuplicateReason = ImportDuplicate, "time_and_doctor"
+		default:
+			existing, reason, err := findDuplicateAppointment(db, userID, &item)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				item.Status, item.DuplicateOf, item.DuplicateReason = ImportDuplicate, existing, reason
+			}
+		}
+
+		if item.UID != "" {
+			seenUIDs[item.UID] = true
+		}
+		seenSlots[slot] = true
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// appointmentFromEvent maps VEVENT fields onto appointment fields. The doctor
+// comes from the ORGANIZER's name, falling back to the summary. A repeating
+// event maps onto its first occurrence and is flagged.
+func appointmentFromEvent(event ical.Event, loc *time.Location) AppointmentImportItem {
+	doctor, specialty := event.Organizer, ""
+	if doctor == "" {
+		doctor, specialty = doctorFromSummary(event.Summary)
+	}
+	if doctor == "" {
+		doctor = "Unknown"
+	}
+
+	notes := event.Description
+	if event.Organizer != "" && event.Summary != "" {
+		notes = strings.TrimSpace(event.Summary + "\n\n" + notes)
+	}
+
+	item := AppointmentImportItem{
+		UID:             event.UID,
+		DoctorName:      doctor,
+		Specialty:       specialty,
+		Location:        event.Location,
+		AppointmentDate: database.DateTime{Time: recurrence.WallClock(event.Start.In(loc))},
+		Notes:           notes,
+		Status:          ImportNew,
+	}
+	if event.RRule != "" {
+		item.Recurrence, item.Warning = event.RRule, recurringImportWarning
+	}
+	return item
+}
+
+// doctorFromSummary understands summaries like "Appointment with Dr. Lee
+// (Cardiology)", which is also what the calendar feed exports
+func doctorFromSummary(summary string) (string, string) {
+	s := strings.TrimSpace(summary)
+	for _, prefix := range []string{"Appointment with ", "Appointment: ", "Appointment - "} {
+		if strings.HasPrefix(strings.ToLower(s), strings.ToLower(prefix)) {
+			s = strings.TrimSpace(s[len(prefix):])
+			break
+		}
+	}
+
+	specialty := ""
+	if strings.HasSuffix(s, ")") {
+		if open := strings.LastIndex(s, " ("); open > 0 {
+			specialty = s[open+2 : len(s)-1]
+			s = s[:open]
+		}
+	}
+	return s, specialty
+}
+
+// findDuplicateAppointment looks for an existing appointment with the same
+// UID (including UIDs the calendar feed generated) or the same time and doctor
+func findDuplicateAppointment(db *gorm.DB, userID uuid.UUID, item *AppointmentImportItem) (*uuid.UUID, string, error) {
+	var existing database.Appointment
+
+	if item.UID != "" {
+		query := db.Where("user_id = ?", userID)
+		if id, ok := exportedAppointmentID(item.UID); ok {
+			query = query.Where("external_uid = ? OR id = ?", item.UID, id)
+		} else {
+			query = query.Where("external_uid = ?", item.UID)
+		}
+		err := query.First(&existing).Error
+		if err == nil {
+			return &existing.ID, "uid", nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", err
+		}
+	}
+
+	err := db.Where("user_id = ? AND appointment_date = ? AND LOWER(doctor_name) = LOWER(?)",
+		userID, item.AppointmentDate, item.DoctorName).First(&existing).Error
+	if err == nil {
+		return &existing.ID, "time_and_doctor", nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", err
+	}
+	return nil, "", nil
+}
+
+// exportedAppointmentID recognises UIDs produced by appointmentEvent
+func exportedAppointmentID(uid string) (uuid.UUID, bool) {
+	suffix := fmt.Sprintf("@%s", uidDomain)
+	if !strings.HasPrefix(uid, "appointment-") || !strings.HasSuffix(uid, suffix) {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(strings.TrimSuffix(strings.TrimPrefix(uid, "appointment-"), suffix))
+	return id, err == nil
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"medical-records-app/internal/ical"
+	"testing"
+	"time"
+)
+
+func TestAppointmentFromEvent(t *testing.T) {
+	start := time.Date(2024, 3, 4, 14, 30, 0, 0, time.UTC)
+	single := appointmentFromEvent(ical.Event{UID: "a", Summary: "Appointment with Dr. Lee (Cardiology)", Start: start}, time.UTC)
+	if single.DoctorName != "Dr. Lee" || single.Specialty != "Cardiology" {
+		t.Errorf("doctor = %q (%q), want Dr. Lee (Cardiology)", single.DoctorName, single.Specialty)
+	}
+	if single.Recurrence != "" || single.Warning != "" {
+		t.Errorf("single event flagged as repeating: %q, %q", single.Recurrence, single.Warning)
+	}
+
+	repeating := appointmentFromEvent(ical.Event{UID: "b", Summary: "Physio", Start: start, RRule: "FREQ=WEEKLY;COUNT=6"}, time.UTC)
+	if repeating.Recurrence != "FREQ=WEEKLY;COUNT=6" || repeating.Warning == "" {
+		t.Errorf("repeating event not flagged: %q, %q", repeating.Recurrence, repeating.Warning)
+	}
+	if !repeating.AppointmentDate.Time.Equal(start) || repeating.Status != ImportNew {
+		t.Errorf("repeating event = %v %s, want its first occurrence %v as new", repeating.AppointmentDate.Time, repeating.Status, start)
+	}
+}
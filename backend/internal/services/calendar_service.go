@@ -0,0 +1,222 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/ical"
+	"medical-records-app/internal/recurrence"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	uidDomain           = "medical-records-app"
+	appointmentDuration = time.Hour
+	reminderDuration    = 30 * time.Minute
+)
+
+// CalendarService renders appointments and reminders as iCalendar data and
+// manages the per-user feed token
+type CalendarService struct {
+	db *gorm.DB
+}
+
+func NewCalendarService(db *gorm.DB) *CalendarService {
+	return &CalendarService{db: db}
+}
+
+// RotateFeedToken issues a new feed token, invalidating any previous one. Only
+// the hash is stored, so the raw token is returned to the caller once.
+func (s *CalendarService) RotateFeedToken(userID uuid.UUID) (string, error) {
+	token, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err := s.db.Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"calendar_token_hash":       auth.HashToken(token),
+		"calendar_token_created_at": now,
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// DisableFeed removes the feed token so existing subscriptions stop working
+func (s *CalendarService) DisableFeed(userID uuid.UUID) error {
+	return s.db.Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"calendar_token_hash":       "",
+		"calendar_token_created_at": nil,
+	}).Error
+}
+
+// GetFeedStatus reports whether the user has an active feed token
+func (s *CalendarService) GetFeedStatus(userID uuid.UUID) (bool, *time.Time, error) {
+	var user database.User
+	if err := s.db.Select("calendar_token_hash", "calendar_token_created_at").First(&user, userID).Error; err != nil {
+		return false, nil, err
+	}
+	return user.CalendarTokenHash != "", user.CalendarTokenCreatedAt, nil
+}
+
+// GetUserByFeedToken resolves a feed token to its user
+func (s *CalendarService) GetUserByFeedToken(token string) (*database.User, error) {
+	if token == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var user database.User
+	if err := s.db.Where("calendar_token_hash = ?", auth.HashToken(token)).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// BuildFeed returns the user's appointments and reminders as a calendar
+func (s *CalendarService) BuildFeed(user *database.User) (*ical.Calendar, error) {
+	var appointments []database.Appointment
+	if err := s.db.Where("user_id = ?", user.ID).Order("appointment_date ASC").Find(&appointments).Error; err != nil {
+		return nil, err
+	}
+
+	var reminders []database.Reminder
+	if err := s.db.Where("user_id = ?", user.ID).Order("reminder_date ASC").Find(&reminders).Error; err != nil {
+		return nil, err
+	}
+
+	prefs, err := loadPreferences(s.db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := &ical.Calendar{Name: strings.TrimSpace(user.FirstName + " " + user.LastName + " - Medical")}
+	for i := range appointments {
+		calendar.Events = append(calendar.Events, s.appointmentEvent(&appointments[i], prefs))
+	}
+
+	// A recurring series is a single VEVENT with an RRULE. Its completed
+	// occurrences are already covered by the rule, so only the open row is used.
+	for i := range reminders {
+		reminder := &reminders[i]
+		if reminder.IsRecurring && reminder.IsCompleted {
+			continue
+		}
+		event, err := s.reminderEvent(reminder, preferenceLocation(prefs))
+		if err != nil {
+			return nil, err
+		}
+		calendar.Events = append(calendar.Events, event)
+	}
+
+	return calendar, nil
+}
+
+// GetAppointmentCalendar returns a single appointment as a calendar
+func (s *CalendarService) GetAppointmentCalendar(userID, appointmentID uuid.UUID) (*ical.Calendar, error) {
+	var appointment database.Appointment
+	if err := s.db.Where("id = ? AND user_id = ?", appointmentID, userID).First(&appointment).Error; err != nil {
+		return nil, err
+	}
+	prefs, err := loadPreferences(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &ical.Calendar{Events: []ical.Event{s.appointmentEvent(&appointment, prefs)}}, nil
+}
+
+// GetReminderCalendar returns a single reminder (or its series) as a calendar
+func (s *CalendarService) GetReminderCalendar(userID, reminderID uuid.UUID) (*ical.Calendar, error) {
+	var reminder database.Reminder
+	if err := s.db.Where("id = ? AND user_id = ?", reminderID, userID).First(&reminder).Error; err != nil {
+		return nil, err
+	}
+	event, err := s.reminderEvent(&reminder, userLocation(s.db, userID))
+	if err != nil {
+		return nil, err
+	}
+	return &ical.Calendar{Events: []ical.Event{event}}, nil
+}
+
+// appointmentEvent renders an appointment in the user's timezone, with an
+// alarm at their preferred lead time and another an hour before
+func (s *CalendarService) appointmentEvent(appointment *database.Appointment, prefs *database.UserPreference) ical.Event {
+	start := recurrence.InLocation(appointment.AppointmentDate.Time, preferenceLocation(prefs))
+
+	summary := "Appointment with " + appointment.DoctorName
+	if appointment.Specialty != "" {
+		summary += " (" + appointment.Specialty + ")"
+	}
+
+	location := appointment.Location
+	if appointment.Hospital != "" {
+		if location != "" {
+			location = appointment.Hospital + ", " + location
+		} else {
+			location = appointment.Hospital
+		}
+	}
+
+	alarms := []ical.Alarm{{Before: time.Hour, Description: summary}}
+	if lead := time.Duration(prefs.AppointmentLeadMinutes) * time.Minute; lead > time.Hour {
+		alarms = append([]ical.Alarm{{Before: lead, Description: summary}}, alarms...)
+	}
+
+	return ical.Event{
+		UID:          fmt.Sprintf("appointment-%s@%s", appointment.ID, uidDomain),
+		Summary:      summary,
+		Description:  appointment.Notes,
+		Location:     location,
+		Start:        start,
+		End:          start.Add(appointmentDuration),
+		Status:       "CONFIRMED",
+		Created:      appointment.CreatedAt,
+		LastModified: appointment.UpdatedAt,
+		Alarms:       alarms,
+	}
+}
+
+// reminderEvent renders a reminder in its own timezone, or userLoc for
+// reminders that predate per-reminder timezones
+func (s *CalendarService) reminderEvent(reminder *database.Reminder, userLoc *time.Location) (ical.Event, error) {
+	loc := userLoc
+	if reminder.Timezone != "" {
+		if l, err := recurrence.LoadLocation(reminder.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	event := ical.Event{
+		UID:          fmt.Sprintf("reminder-%s@%s", reminder.ID, uidDomain),
+		Summary:      reminder.Title,
+		Description:  reminder.Description,
+		Start:        recurrence.InLocation(reminder.ReminderDate.Time, loc),
+		Status:       "CONFIRMED",
+		Created:      reminder.CreatedAt,
+		LastModified: reminder.UpdatedAt,
+		Alarms:       []ical.Alarm{{Before: 0, Description: reminder.Title}},
+	}
+
+	if reminder.IsRecurring {
+		rule, dtstart, exceptions, err := seriesOf(reminder, loc)
+		if err != nil {
+			return ical.Event{}, errors.New("reminder has an invalid recurrence rule")
+		}
+		seriesID := reminder.ID
+		if reminder.SeriesID != nil {
+			seriesID = *reminder.SeriesID
+		}
+		// The UID follows the series so completing an occurrence (which moves
+		// the series to a new row) doesn't create a new calendar event
+		event.UID = fmt.Sprintf("reminder-series-%s@%s", seriesID, uidDomain)
+		event.Start = dtstart
+		event.RRule = rule.InLocation(loc).String()
+		event.ExDates = exceptions
+	}
+
+	event.End = event.Start.Add(reminderDuration)
+	return event, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects to the Postgres database in TEST_DATABASE_URL and migrates
+// it. Without one, tests that need a database are skipped locally (run
+// `make test-db` to include them) and fail in CI, so a misconfigured
+// pipeline can't pass with them silently skipped. Each test creates its own
+// rows, so the database can be shared between runs.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI; database tests would otherwise be skipped")
+		}
+		t.Skip("SKIPPED: needs Postgres; set TEST_DATABASE_URL or run `make test-db`")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// createTestUser stores a user with a unique email and the given password
+func createTestUser(t *testing.T, db *gorm.DB, password string) *database.User {
+	t.Helper()
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	user := &database.User{
+		ID:              id,
+		Email:           id.String() + "@example.test",
+		PasswordHash:    hash,
+		FirstName:       "Test",
+		IsEmailVerified: true,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
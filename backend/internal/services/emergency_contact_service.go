@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"medical-records-app/internal/database"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type EmergencyContactService struct {
+	db *gorm.DB
+}
+
+func NewEmergencyContactService(db *gorm.DB) *EmergencyContactService {
+	return &EmergencyContactService{db: db}
+}
+
+func (s *EmergencyContactService) CreateContact(userID uuid.UUID, contact *database.EmergencyContact) error {
+	if err := validateContact(contact); err != nil {
+		return err
+	}
+	contact.UserID = userID
+	contact.ID = uuid.New()
+	contact.CreatedAt = time.Now()
+	contact.UpdatedAt = time.Now()
+	return s.db.Create(contact).Error
+}
+
+func (s *EmergencyContactService) GetContacts(userID uuid.UUID) ([]database.EmergencyContact, error) {
+	var contacts []database.EmergencyContact
+	if err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (s *EmergencyContactService) GetContactByID(userID, contactID uuid.UUID) (*database.EmergencyContact, error) {
+	var contact database.EmergencyContact
+	if err := s.db.Where("id = ? AND user_id = ?", contactID, userID).First(&contact).Error; err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// UpdateContact replaces the contact's details
+func (s *EmergencyContactService) UpdateContact(userID, contactID uuid.UUID, contact *database.EmergencyContact) error {
+	if err := validateContact(contact); err != nil {
+		return err
+	}
+	contact.UpdatedAt = time.Now()
+	return s.db.Model(&database.EmergencyContact{}).
+		Where("id = ? AND user_id = ?", contactID, userID).
+		Select("name", "relationship", "email", "phone", "notify_on_escalation", "updated_at").
+		Updates(contact).Error
+}
+
+func (s *EmergencyContactService) DeleteContact(userID, contactID uuid.UUID) error {
+	return s.db.Where("id = ? AND user_id = ?", contactID, userID).
+		Delete(&database.EmergencyContact{}).Error
+}
+
+func validateContact(contact *database.EmergencyContact) error {
+	if contact.Email == "" && contact.Phone == "" {
+		return errors.New("an emergency contact needs an email or phone number")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/events"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// eventChannel is the PostgreSQL NOTIFY channel carrying new event IDs
+	eventChannel   = "user_events"
+	eventRetention = 7 * 24 * time.Hour
+	maxEventReplay = 500
+)
+
+// EventService persists user events and fans them out to connected clients.
+// With the bridge running, events go through LISTEN/NOTIFY so clients
+// connected to any replica receive them.
+type EventService struct {
+	db      *gorm.DB
+	broker  *events.Broker
+	bridged atomic.Bool
+
+	mu     sync.Mutex
+	lastID int64 // highest event ID dispatched to the broker
+	sinks  []func(events.Event)
+}
+
+func NewEventService(db *gorm.DB, broker *events.Broker) *EventService {
+	return &EventService{db: db, broker: broker}
+}
+
+// Subscribe registers a stream for the user's events
+func (s *EventService) Subscribe(userID uuid.UUID) *events.Subscription {
+	return s.broker.Subscribe(userID)
+}
+
+// AddSink registers fn to run for every event published on this replica.
+// Unlike stream subscribers, sinks see each event once across the cluster,
+// which suits side effects such as webhook deliveries.
+func (s *EventService) AddSink(fn func(events.Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sinks = append(s.sinks, fn)
+}
+
+// Publish records an event for the user and delivers it. Failures are logged
+// rather than returned: events are a side channel and shouldn't fail the
+// request that raised them.
+func (s *EventService) Publish(userID uuid.UUID, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", eventType, err)
+		return
+	}
+
+	row := &database.UserEvent{
+		UserID:    userID,
+		Type:      eventType,
+		Data:      string(payload),
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Create(row).Error; err != nil {
+		log.Printf("Failed to store %s event: %v", eventType, err)
+		return
+	}
+
+	event := toEvent(row)
+	s.mu.Lock()
+	sinks := s.sinks
+	s.mu.Unlock()
+	for _, sink := range sinks {
+		sink(event)
+	}
+
+	if s.bridged.Load() {
+		if err := s.db.Exec("SELECT pg_notify(?, ?)", eventChannel, strconv.FormatInt(row.ID, 10)).Error; err == nil {
+			return
+		}
+		log.Printf("Failed to notify event %d, dispatching locally: %v", row.ID, err)
+	}
+	s.dispatch(event)
+}
+
+// Replay returns the user's events after afterID, oldest first
+func (s *EventService) Replay(userID uuid.UUID, afterID int64) ([]events.Event, error) {
+	var rows []database.UserEvent
+	if err := s.db.Where("user_id = ? AND id > ?", userID, afterID).
+		Order("id ASC").
+		Limit(maxEventReplay).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]events.Event, len(rows))
+	for i := range rows {
+		result[i] = toEvent(&rows[i])
+	}
+	return result, nil
+}
+
+// Start runs the LISTEN/NOTIFY bridge and prunes old events in the background
+func (s *EventService) Start() {
+	var maxID int64
+	s.db.Model(&database.UserEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&maxID)
+	s.mu.Lock()
+	s.lastID = maxID
+	s.mu.Unlock()
+
+	if sqlDB, err := s.db.DB(); err == nil {
+		go events.Listen(context.Background(), sqlDB, eventChannel, s.catchUp, s.handleNotification)
+	} else {
+		log.Printf("Event bridge disabled: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.db.Where("created_at < ?", time.Now().Add(-eventRetention)).
+				Delete(&database.UserEvent{}).Error; err != nil {
+				log.Printf("Failed to prune events: %v", err)
+			}
+		}
+	}()
+}
+
+// catchUp runs whenever the listener (re)connects and dispatches anything
+// published while it was down
+func (s *EventService) catchUp() {
+	s.bridged.Store(true)
+
+	s.mu.Lock()
+	after := s.lastID
+	s.mu.Unlock()
+
+	var rows []database.UserEvent
+	if err := s.db.Where("id > ?", after).Order("id ASC").Limit(1000).Find(&rows).Error; err != nil {
+		log.Printf("Failed to catch up on events: %v", err)
+		return
+	}
+	for i := range rows {
+		s.dispatch(toEvent(&rows[i]))
+	}
+}
+
+func (s *EventService) handleNotification(payload string) {
+	id, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return
+	}
+	var row database.UserEvent
+	if err := s.db.First(&row, id).Error; err != nil {
+		log.Printf("Failed to load event %d: %v", id, err)
+		return
+	}
+	s.dispatch(toEvent(&row))
+}
+
+func (s *EventService) dispatch(e events.Event) {
+	s.mu.Lock()
+	if e.ID > s.lastID {
+		s.lastID = e.ID
+	}
+	s.mu.Unlock()
+	s.broker.Dispatch(e)
+}
+
+func toEvent(row *database.UserEvent) events.Event {
+	return events.Event{
+		ID:        row.ID,
+		UserID:    row.UserID,
+		Type:      row.Type,
+		Data:      json.RawMessage(row.Data),
+		CreatedAt: row.CreatedAt,
+	}
+}
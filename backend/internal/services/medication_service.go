@@ -58,14 +58,17 @@ func (s *MedicationService) DeleteMedication(userID, medicationID uuid.UUID) err
 		Delete(&database.Medication{}).Error
 }
 
+// GetMedicationsNeedingRefill returns active medications whose next refill
+// falls within the user's refill lead time
 func (s *MedicationService) GetMedicationsNeedingRefill(userID uuid.UUID) ([]database.Medication, error) {
+	prefs, err := loadPreferences(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	var medications []database.Medication
-	now := time.Now()
-	
-	// Find medications where next refill date is within the reminder days
 	if err := s.db.Where("user_id = ? AND is_active = ?", userID, true).
-		Where("next_refill_date IS NOT NULL AND next_refill_date <= ?", 
-			now.AddDate(0, 0, 7)). // Default 7 days ahead
+		Where("next_refill_date IS NOT NULL AND next_refill_date <= ?", refillCutoff(prefs, time.Now())).
 		Find(&medications).Error; err != nil {
 		return nil, err
 	}
@@ -73,3 +76,8 @@ func (s *MedicationService) GetMedicationsNeedingRefill(userID uuid.UUID) ([]dat
 	return medications, nil
 }
 
+// refillCutoff is the last refill date that is due for a reminder, counted in
+// days from today in the user's timezone
+func refillCutoff(prefs *database.UserPreference, now time.Time) string {
+	return now.In(preferenceLocation(prefs)).AddDate(0, 0, prefs.RefillLeadDays).Format("2006-01-02")
+}
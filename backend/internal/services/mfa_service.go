@@ -0,0 +1,374 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaIssuer          = "Medical Records App"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	maxMFAAttempts     = 5
+	mfaLockout         = 15 * time.Minute
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolling     = errors.New("start two-factor enrollment first")
+	ErrInvalidMFACode      = errors.New("invalid authentication code")
+	ErrMFALocked           = errors.New("too many failed attempts; try again later")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired sign-in challenge; log in again")
+)
+
+// TOTPEnrollment is what a user adds to their authenticator app. Clients
+// usually show the URI as a QR code, with the secret for typing in by hand.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAStatus describes a user's two-factor setup
+type MFAStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int64      `json:"recovery_codes_left"`
+}
+
+// MFAService manages TOTP two-factor authentication. Once enabled, logging in
+// takes a code from the user's authenticator app or one of their recovery
+// codes as well as the password.
+type MFAService struct {
+	db           *gorm.DB
+	challengeTTL time.Duration
+}
+
+func NewMFAService(db *gorm.DB, challengeTTL time.Duration) *MFAService {
+	return &MFAService{
+		db:           db,
+		challengeTTL: challengeTTL,
+	}
+}
+
+// GetStatus returns whether the user has two-factor authentication on and
+// how many recovery codes they have left
+func (s *MFAService) GetStatus(userID uuid.UUID) (*MFAStatus, error) {
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	status := &MFAStatus{Enabled: user.MFAEnabled, EnabledAt: user.MFAEnabledAt}
+	if user.MFAEnabled {
+		if err := s.db.Model(&database.MFARecoveryCode{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Count(&status.RecoveryCodesLeft).Error; err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// BeginEnrollment creates a new TOTP secret for the user. It takes effect
+// once ConfirmEnrollment sees a code from it; starting over replaces it.
+func (s *MFAService) BeginEnrollment(user *database.User) (*TOTPEnrollment, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&database.User{}).
+		Where("id = ? AND mfa_enabled = ?", user.ID, false).
+		Updates(map[string]interface{}{
+			"totp_secret":    secret,
+			"totp_last_step": 0,
+			"updated_at":     time.Now(),
+		}).Error; err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, mfaIssuer, user.Email),
+	}, nil
+}
+
+// ConfirmEnrollment turns two-factor authentication on once the user shows
+// a code from the new secret, and returns their recovery codes in plain
+// text. They can't be shown again.
+func (s *MFAService) ConfirmEnrollment(userID uuid.UUID, code string) ([]string, error) {
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolling
+	}
+	if mfaLocked(&user) {
+		return nil, ErrMFALocked
+	}
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, s.recordFailedAttempt(&user)
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&database.User{}).
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"mfa_enabled":         true,
+				"mfa_enabled_at":      now,
+				"totp_last_step":      step,
+				"mfa_failed_attempts": 0,
+				"mfa_locked_until":    nil,
+				"updated_at":          now,
+			}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off. It takes the password and a
+// current code, so a stolen session alone can't remove the second factor.
+func (s *MFAService) Disable(userID uuid.UUID, password, code string) error {
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if !auth.CheckPasswordHash(password, user.PasswordHash) {
+		return ErrWrongPassword
+	}
+	if err := s.VerifyCode(&user, code); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.User{}).
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"mfa_enabled":    false,
+				"mfa_enabled_at": nil,
+				"totp_secret":    "",
+				"totp_last_step": 0,
+				"updated_at":     time.Now(),
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		// Sessions no longer count as two-factor verified
+		return tx.Model(&database.AuthSession{}).
+			Where("user_id = ? AND mfa_verified_at IS NOT NULL", userID).
+			Update("mfa_verified_at", nil).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a
+// current code, and returns the new ones in plain text
+func (s *MFAService) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.VerifyCode(&user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// StartChallenge returns a short-lived token for a user who got their
+// password right, to be exchanged with a code by CompleteChallenge
+func (s *MFAService) StartChallenge(user *database.User) (string, time.Time, error) {
+	return auth.GenerateMFAChallengeToken(user.ID, user.PasswordVersion, s.challengeTTL)
+}
+
+// CompleteChallenge checks the second step of a two-step login and returns
+// the user to start a session for. A challenge issued before the password
+// was last reset or changed is refused.
+func (s *MFAService) CompleteChallenge(challengeToken, code string) (*database.User, error) {
+	claims, err := auth.ValidateMFAChallengeToken(challengeToken)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidMFAChallenge
+		}
+		return nil, err
+	}
+	if !user.MFAEnabled || claims.PasswordVersion != user.PasswordVersion {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err := s.VerifyCode(&user, code); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// VerifyCode accepts a code from the user's authenticator app, or one of
+// their unused recovery codes, which is then used up. Authenticator codes
+// work once. Repeated failures lock two-factor checks for a while.
+func (s *MFAService) VerifyCode(user *database.User, code string) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if mfaLocked(user) {
+		return ErrMFALocked
+	}
+
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		// Only move forward, so a code (or an older one) can't be replayed
+		result := s.db.Model(&database.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Updates(map[string]interface{}{
+				"totp_last_step":      step,
+				"mfa_failed_attempts": 0,
+				"mfa_locked_until":    nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			user.TOTPLastStep = step
+			return nil
+		}
+	} else if normalized := normalizeRecoveryCode(code); len(normalized) == recoveryCodeLength {
+		result := s.db.Model(&database.MFARecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(user.ID, normalized)).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return s.db.Model(&database.User{}).
+				Where("id = ?", user.ID).
+				Updates(map[string]interface{}{"mfa_failed_attempts": 0, "mfa_locked_until": nil}).Error
+		}
+	}
+
+	return s.recordFailedAttempt(user)
+}
+
+// recordFailedAttempt counts a wrong code and locks two-factor checks once
+// there have been too many
+func (s *MFAService) recordFailedAttempt(user *database.User) error {
+	if err := s.db.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Update("mfa_failed_attempts", gorm.Expr("mfa_failed_attempts + 1")).Error; err != nil {
+		return err
+	}
+	if err := s.db.First(user, "id = ?", user.ID).Error; err != nil {
+		return err
+	}
+	if user.MFAFailedAttempts < maxMFAAttempts {
+		return ErrInvalidMFACode
+	}
+
+	lockedUntil := time.Now().Add(mfaLockout)
+	user.MFAFailedAttempts = 0
+	user.MFALockedUntil = &lockedUntil
+	if err := s.db.Model(&database.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]interface{}{"mfa_failed_attempts": 0, "mfa_locked_until": lockedUntil}).Error; err != nil {
+		return err
+	}
+	return ErrMFALocked
+}
+
+func mfaLocked(user *database.User) bool {
+	return user.MFALockedUntil != nil && time.Now().Before(*user.MFALockedUntil)
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new
+// set, returned in plain text
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]database.MFARecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		records[i] = database.MFARecoveryCode{
+			ID:        uuid.New(),
+			UserID:    userID,
+			CodeHash:  hashRecoveryCode(userID, code),
+			CreatedAt: time.Now(),
+		}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a random code in uppercase base32 (A-Z and
+// 2-7). With no 0, 1, 8 or 9 in the alphabet, an O, I or B can only be the
+// letter, and normalizeRecoveryCode uppercases what the user types.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b)[:recoveryCodeLength], nil
+}
+
+// normalizeRecoveryCode undoes the grouping and case a user might type a
+// recovery code with
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// hashRecoveryCode keys the code with the server secret and binds it to its
+// user, like one-time codes
+func hashRecoveryCode(userID uuid.UUID, code string) string {
+	return auth.HashCode(userID.String(), code)
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// enrollTestUser creates a user with two-factor authentication turned on and
+// returns them with their secret and recovery codes
+func enrollTestUser(t *testing.T, db *gorm.DB, s *MFAService) (*database.User, []string) {
+	t.Helper()
+	user := createTestUser(t, db, "password")
+	enrollment, err := s.BeginEnrollment(user)
+	if err != nil {
+		t.Fatalf("BeginEnrollment: %v", err)
+	}
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := s.ConfirmEnrollment(user.ID, code)
+	if err != nil {
+		t.Fatalf("ConfirmEnrollment: %v", err)
+	}
+	if err := db.First(user, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user, codes
+}
+
+func TestMFAVerifyCodeReplay(t *testing.T) {
+	db := testDB(t)
+	s := NewMFAService(db, 5*time.Minute)
+	user, _ := enrollTestUser(t, db, s)
+
+	// The code used to confirm enrollment can't be used again
+	confirmed := time.Unix(user.TOTPLastStep*30, 0)
+	current, err := auth.TOTPCode(user.TOTPSecret, confirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyCode(user, current); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("replayed enrollment code: got %v, want %v", err, ErrInvalidMFACode)
+	}
+
+	// The next step's code is within the skew window and works once
+	next, err := auth.TOTPCode(user.TOTPSecret, confirmed.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyCode(user, next); err != nil {
+		t.Fatalf("next step's code: %v", err)
+	}
+	if err := s.VerifyCode(user, next); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("replayed code: got %v, want %v", err, ErrInvalidMFACode)
+	}
+	// and older codes are refused once a later one was accepted
+	if err := s.VerifyCode(user, current); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("older code: got %v, want %v", err, ErrInvalidMFACode)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	db := testDB(t)
+	s := NewMFAService(db, 5*time.Minute)
+	user, codes := enrollTestUser(t, db, s)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	if err := s.VerifyCode(user, codes[0]); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if err := s.VerifyCode(user, codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("second use of a recovery code: got %v, want %v", err, ErrInvalidMFACode)
+	}
+	// Codes can be typed without the dash and in lower case
+	typed := strings.ToLower(strings.ReplaceAll(codes[1], "-", ""))
+	if err := s.VerifyCode(user, typed); err != nil {
+		t.Fatalf("recovery code typed as %q: %v", typed, err)
+	}
+
+	status, err := s.GetStatus(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RecoveryCodesLeft != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", status.RecoveryCodesLeft, recoveryCodeCount-2)
+	}
+
+	// Regenerating replaces the old codes
+	current, err := auth.TOTPCode(user.TOTPSecret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := s.RegenerateRecoveryCodes(user.ID, current)
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes: %v", err)
+	}
+	if err := s.VerifyCode(user, codes[2]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replaced recovery code: got %v, want %v", err, ErrInvalidMFACode)
+	}
+	if err := s.VerifyCode(user, fresh[0]); err != nil {
+		t.Errorf("new recovery code: %v", err)
+	}
+}
+
+func TestMFALockout(t *testing.T) {
+	db := testDB(t)
+	s := NewMFAService(db, 5*time.Minute)
+	user, codes := enrollTestUser(t, db, s)
+
+	// A code for a step well outside the window is wrong
+	wrong, err := auth.TOTPCode(user.TOTPSecret, time.Now().Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < maxMFAAttempts; i++ {
+		if err := s.VerifyCode(user, wrong); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("wrong code %d: got %v, want %v", i, err, ErrInvalidMFACode)
+		}
+	}
+	if err := s.VerifyCode(user, wrong); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("wrong code %d: got %v, want %v", maxMFAAttempts, err, ErrMFALocked)
+	}
+
+	// While locked, even right codes are refused, including from a fresh load
+	if err := db.First(user, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	next, err := auth.TOTPCode(user.TOTPSecret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyCode(user, next); !errors.Is(err, ErrMFALocked) {
+		t.Errorf("right code while locked: got %v, want %v", err, ErrMFALocked)
+	}
+	if err := s.VerifyCode(user, codes[0]); !errors.Is(err, ErrMFALocked) {
+		t.Errorf("recovery code while locked: got %v, want %v", err, ErrMFALocked)
+	}
+
+	// Once the lock has passed, a right code works and resets the count
+	if err := db.Model(&database.User{}).Where("id = ?", user.ID).
+		Update("mfa_locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(user, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyCode(user, next); err != nil {
+		t.Fatalf("right code after the lock: %v", err)
+	}
+	if err := db.First(user, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.MFAFailedAttempts != 0 || user.MFALockedUntil != nil {
+		t.Errorf("after a right code: %d failed attempts, locked until %v; want 0 and nil", user.MFAFailedAttempts, user.MFALockedUntil)
+	}
+}
+
+func TestMFAChallengeVoidedByPasswordChange(t *testing.T) {
+	db := testDB(t)
+	s := NewMFAService(db, 5*time.Minute)
+	passwords := NewPasswordService(db, nil, NewSessionService(db, time.Hour, time.Hour), "", time.Hour, time.Minute, 5)
+	user, codes := enrollTestUser(t, db, s)
+
+	challenge, _, err := s.StartChallenge(user)
+	if err != nil {
+		t.Fatalf("StartChallenge: %v", err)
+	}
+	if err := passwords.ChangePassword(user.ID, "password", "a new password"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	// The challenge proved the old password, so even a right code can't finish it
+	if _, err := s.CompleteChallenge(challenge, codes[0]); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("challenge from before the change: got %v, want %v", err, ErrInvalidMFAChallenge)
+	}
+
+	if err := db.First(user, "id = ?", user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	challenge, _, err = s.StartChallenge(user)
+	if err != nil {
+		t.Fatalf("StartChallenge: %v", err)
+	}
+	if _, err := s.CompleteChallenge(challenge, codes[0]); err != nil {
+		t.Fatalf("challenge from after the change: %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Delivery statuses
+const (
+	DeliveryPending  = "pending"
+	DeliveryRetrying = "retrying"
+	DeliverySent     = "sent"
+	DeliveryFailed   = "failed"
+	DeliveryQueued   = "queued"   // held for the user's daily digest
+	DeliveryDigested = "digested" // included in a digest that was sent
+)
+
+// errSecretGone fails a retry whose secrets were only held by a process that
+// has since stopped
+var errSecretGone = &notify.PermanentError{Err: errors.New("message contained a one-time secret that is no longer available; request a new one")}
+
+// NotificationRequest describes a notification to deliver and what it is about
+type NotificationRequest struct {
+	UserID      *uuid.UUID
+	Channel     string
+	Recipient   string
+	Subject     string
+	Body        string
+	Kind        string
+	RelatedType string
+	RelatedID   *uuid.UUID
+	// Secrets are values in Subject and Body, such as one-time codes and
+	// links carrying a token, that the delivery log must not keep. They are
+	// only held in memory until the delivery is sent or fails.
+	Secrets []string
+}
+
+// NotificationService delivers notifications through the configured channels,
+// logging every delivery and retrying failures with exponential backoff
+type NotificationService struct {
+	db          *gorm.DB
+	notifiers   map[string]notify.Notifier
+	maxAttempts int
+	retryBase   time.Duration
+
+	mu        sync.Mutex
+	observers []func(*database.NotificationDelivery)
+	held      map[uuid.UUID]notify.Message // unredacted messages of redacted deliveries
+}
+
+func NewNotificationService(db *gorm.DB, notifiers map[string]notify.Notifier, maxAttempts int, retryBase time.Duration) *NotificationService {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &NotificationService{
+		db:          db,
+		notifiers:   notifiers,
+		maxAttempts: maxAttempts,
+		retryBase:   retryBase,
+		held:        make(map[uuid.UUID]notify.Message),
+	}
+}
+
+// AddObserver registers fn to run after every delivery attempt, so records
+// that a notification is about can track its status
+func (s *NotificationService) AddObserver(fn func(*database.NotificationDelivery)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+// Send records the delivery and makes the first attempt straight away. A
+// failed attempt is not an error for the caller: the delivery is left in the
+// retrying or failed state and can be inspected through the delivery log.
+func (s *NotificationService) Send(req NotificationRequest) (*database.NotificationDelivery, error) {
+	delivery, err := s.record(req, DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attempt(delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Queue records the notification for the user's next digest instead of
+// sending it. Digests are built from the delivery log, so messages with
+// secrets can't be queued.
+func (s *NotificationService) Queue(req NotificationRequest) (*database.NotificationDelivery, error) {
+	if len(req.Secrets) > 0 {
+		return nil, errors.New("notifications with secrets can't be held for a digest")
+	}
+	return s.record(req, DeliveryQueued)
+}
+
+func (s *NotificationService) record(req NotificationRequest, status string) (*database.NotificationDelivery, error) {
+	if _, ok := s.notifiers[req.Channel]; !ok {
+		return nil, fmt.Errorf("unsupported notification channel %q", req.Channel)
+	}
+	if req.Recipient == "" {
+		return nil, fmt.Errorf("%s notification requires a recipient", req.Channel)
+	}
+
+	subject := notify.Redact(req.Subject, req.Secrets)
+	body := notify.Redact(req.Body, req.Secrets)
+	redacted := subject != req.Subject || body != req.Body
+
+	delivery := &database.NotificationDelivery{
+		ID:          uuid.New(),
+		UserID:      req.UserID,
+		Channel:     req.Channel,
+		Recipient:   req.Recipient,
+		Subject:     subject,
+		Body:        body,
+		Redacted:    redacted,
+		Kind:        req.Kind,
+		RelatedType: req.RelatedType,
+		RelatedID:   req.RelatedID,
+		Status:      status,
+		MaxAttempts: s.maxAttempts,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.db.Create(delivery).Error; err != nil {
+		return nil, err
+	}
+	if redacted {
+		s.mu.Lock()
+		s.held[delivery.ID] = notify.Message{To: req.Recipient, Subject: req.Subject, Body: req.Body, Secrets: req.Secrets}
+		s.mu.Unlock()
+	}
+	return delivery, nil
+}
+
+// SendDigest combines the user's queued notifications into one message per
+// channel and recipient, and marks them as digested
+func (s *NotificationService) SendDigest(userID uuid.UUID) error {
+	var queued []database.NotificationDelivery
+	if err := s.db.Where("user_id = ? AND status = ?", userID, DeliveryQueued).
+		Order("created_at ASC").
+		Find(&queued).Error; err != nil {
+		return err
+	}
+
+	type destination struct{ channel, recipient string }
+	groups := make(map[destination][]database.NotificationDelivery)
+	var order []destination
+	for _, delivery := range queued {
+		dest := destination{delivery.Channel, delivery.Recipient}
+		if _, ok := groups[dest]; !ok {
+			order = append(order, dest)
+		}
+		groups[dest] = append(groups[dest], delivery)
+	}
+
+	for _, dest := range order {
+		items := groups[dest]
+		var body strings.Builder
+		ids := make([]uuid.UUID, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+			if dest.channel == notify.ChannelSMS {
+				fmt.Fprintf(&body, "- %s\n", item.Subject)
+			} else {
+				fmt.Fprintf(&body, "%s\n%s\n\n", item.Subject, strings.TrimSpace(item.Body))
+			}
+		}
+
+		uid := userID
+		if _, err := s.Send(NotificationRequest{
+			UserID:    &uid,
+			Channel:   dest.channel,
+			Recipient: dest.recipient,
+			Subject:   fmt.Sprintf("Your daily summary: %d notification(s)", len(items)),
+			Body:      body.String(),
+			Kind:      "digest",
+		}); err != nil {
+			return err
+		}
+		if err := s.db.Model(&database.NotificationDelivery{}).
+			Where("id IN ? AND status = ?", ids, DeliveryQueued).
+			Updates(map[string]interface{}{"status": DeliveryDigested, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attempt makes one delivery attempt and persists the outcome
+func (s *NotificationService) attempt(delivery *database.NotificationDelivery) error {
+	notifier := s.notifiers[delivery.Channel]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	msg := notify.Message{
+		To:      delivery.Recipient,
+		Subject: delivery.Subject,
+		Body:    delivery.Body,
+	}
+	var held bool
+	if delivery.Redacted {
+		s.mu.Lock()
+		msg, held = s.held[delivery.ID]
+		s.mu.Unlock()
+	}
+
+	var providerID string
+	var sendErr error
+	switch {
+	case notifier == nil:
+		sendErr = &notify.PermanentError{Err: fmt.Errorf("no notifier for channel %q", delivery.Channel)}
+	case delivery.Redacted && !held:
+		sendErr = errSecretGone
+	default:
+		providerID, sendErr = notifier.Send(ctx, msg)
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.UpdatedAt = now
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = DeliverySent
+		delivery.SentAt = &now
+		delivery.ProviderMessageID = providerID
+		delivery.NextAttemptAt = nil
+		delivery.Error = ""
+	case notify.IsPermanent(sendErr) || delivery.Attempts >= delivery.MaxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.Error = sendErr.Error()
+	default:
+		next := now.Add(s.backoff(delivery.Attempts))
+		delivery.Status = DeliveryRetrying
+		delivery.NextAttemptAt = &next
+		delivery.Error = sendErr.Error()
+	}
+
+	if err := s.db.Model(delivery).Select(
+		"status", "attempts", "last_attempt_at", "sent_at", "provider_message_id",
+		"next_attempt_at", "error", "updated_at",
+	).Updates(delivery).Error; err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if delivery.Status != DeliveryRetrying {
+		delete(s.held, delivery.ID)
+	}
+	observers := s.observers
+	s.mu.Unlock()
+	for _, observe := range observers {
+		observe(delivery)
+	}
+	return nil
+}
+
+// backoff doubles the wait after each failed attempt
+func (s *NotificationService) backoff(attempts int) time.Duration {
+	wait := s.retryBase
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+	}
+	return wait
+}
+
+// ProcessRetries re-attempts deliveries whose backoff has elapsed
+func (s *NotificationService) ProcessRetries() {
+	var due []database.NotificationDelivery
+	if err := s.db.Where("status = ? AND next_attempt_at <= ?", DeliveryRetrying, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(50).
+		Find(&due).Error; err != nil {
+		log.Printf("Failed to load notification retries: %v", err)
+		return
+	}
+
+	for i := range due {
+		delivery := &due[i]
+		// Claim the row by pushing its next attempt out, so another replica
+		// polling at the same time skips it
+		lease := time.Now().Add(5 * time.Minute)
+		result := s.db.Model(&database.NotificationDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, DeliveryRetrying, delivery.NextAttemptAt).
+			Update("next_attempt_at", lease)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		if err := s.attempt(delivery); err != nil {
+			log.Printf("Failed to record notification attempt %s: %v", delivery.ID, err)
+		}
+	}
+}
+
+// Start polls for due retries in the background
+func (s *NotificationService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.ProcessRetries()
+		}
+	}()
+}
+
+// CancelRetries fails deliveries of kind about relatedID that are waiting for
+// another attempt, e.g. because a newer message replaces them, and forgets
+// any secrets held for them
+func (s *NotificationService) CancelRetries(relatedID uuid.UUID, kind, reason string) error {
+	var ids []uuid.UUID
+	if err := s.db.Model(&database.NotificationDelivery{}).
+		Where("related_id = ? AND kind = ? AND status = ?", relatedID, kind, DeliveryRetrying).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := s.db.Model(&database.NotificationDelivery{}).
+		Where("id IN ? AND status = ?", ids, DeliveryRetrying).
+		Updates(map[string]interface{}{
+			"status":          DeliveryFailed,
+			"error":           reason,
+			"next_attempt_at": nil,
+			"updated_at":      time.Now(),
+		}).Error; err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.held, id)
+	}
+	return nil
+}
+
+// GetDeliveries returns the user's delivery log, newest first, without
+// message bodies
+func (s *NotificationService) GetDeliveries(userID uuid.UUID, limit, offset int) ([]database.NotificationDelivery, int64, error) {
+	var deliveries []database.NotificationDelivery
+	var total int64
+
+	query := s.db.Omit("body").Where("user_id = ?", userID)
+	s.db.Model(&database.NotificationDelivery{}).Where("user_id = ?", userID).Count(&total)
+
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// One-time code purposes
+const (
+	CodePurposeShareAccess = "share_access"
+)
+
+const (
+	codeDigits         = 6
+	maxCodeAttempts    = 5
+	codeResendCooldown = time.Minute
+)
+
+var (
+	ErrInvalidCode  = errors.New("invalid or expired code")
+	ErrCodeCooldown = errors.New("a code was sent recently; wait a minute before requesting another")
+)
+
+// OneTimeCodeService issues and checks short numeric codes sent by email or
+// SMS. A subject has at most one live code per purpose; issuing a new one
+// replaces the old.
+type OneTimeCodeService struct {
+	db *gorm.DB
+}
+
+func NewOneTimeCodeService(db *gorm.DB) *OneTimeCodeService {
+	return &OneTimeCodeService{db: db}
+}
+
+// Issue creates a code for the subject, to be sent to target, and returns it
+// in plain text. It refuses to issue codes more than once a minute.
+func (s *OneTimeCodeService) Issue(purpose string, subjectID uuid.UUID, target string, ttl time.Duration) (string, error) {
+	var recent int64
+	if err := s.db.Model(&database.OneTimeCode{}).
+		Where("purpose = ? AND subject_id = ? AND created_at > ?", purpose, subjectID, time.Now().Add(-codeResendCooldown)).
+		Count(&recent).Error; err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", ErrCodeCooldown
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("purpose = ? AND subject_id = ? AND consumed_at IS NULL", purpose, subjectID).
+			Delete(&database.OneTimeCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.OneTimeCode{
+			ID:        uuid.New(),
+			Purpose:   purpose,
+			SubjectID: subjectID,
+			Target:    target,
+			CodeHash:  hashCode(subjectID, code),
+			ExpiresAt: time.Now().Add(ttl),
+			CreatedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Verify consumes the subject's live code if it matches. Each code allows a
+// few guesses before it is burned. The returned record says where the code
+// was sent.
+func (s *OneTimeCodeService) Verify(purpose string, subjectID uuid.UUID, code string) (*database.OneTimeCode, error) {
+	var otc database.OneTimeCode
+	err := s.db.Where("purpose = ? AND subject_id = ? AND consumed_at IS NULL AND expires_at > ? AND attempts < ?",
+		purpose, subjectID, time.Now(), maxCodeAttempts).
+		Order("created_at DESC").
+		First(&otc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hashCode(subjectID, code) != otc.CodeHash {
+		// A guess that can't be counted must not go unlimited, so fail with
+		// the storage error rather than letting the caller try again freely
+		if err := s.db.Model(&otc).Update("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCode
+	}
+
+	// Consume conditionally so two concurrent requests can't both use it, and
+	// a code whose guesses ran out meanwhile can't be used either
+	now := time.Now()
+	result := s.db.Model(&database.OneTimeCode{}).
+		Where("id = ? AND consumed_at IS NULL AND attempts < ?", otc.ID, maxCodeAttempts).
+		Update("consumed_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidCode
+	}
+	otc.ConsumedAt = &now
+	return &otc, nil
+}
+
+// generateCode returns a uniformly random zero-padded numeric code
+func generateCode() (string, error) {
+	limit := big.NewInt(1)
+	for i := 0; i < codeDigits; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n), nil
+}
+
+// hashCode keys the code with the server secret and binds it to its subject,
+// so a leaked table of six-digit code hashes can't be reversed offline
+func hashCode(subjectID uuid.UUID, code string) string {
+	return auth.HashCode(subjectID.String(), code)
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const resetTokenBytes = 32
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired password reset link")
+	ErrWrongPassword     = errors.New("current password is incorrect")
+)
+
+// PasswordService resets and changes passwords. Either way, every session of
+// the user is signed out.
+type PasswordService struct {
+	db                  *gorm.DB
+	notificationService *NotificationService
+	sessionService      *SessionService
+	frontendURL         string
+	resetTTL            time.Duration
+	cooldown            time.Duration
+	maxPerDay           int
+}
+
+func NewPasswordService(db *gorm.DB, notificationService *NotificationService, sessionService *SessionService, frontendURL string, resetTTL, cooldown time.Duration, maxPerDay int) *PasswordService {
+	return &PasswordService{
+		db:                  db,
+		notificationService: notificationService,
+		sessionService:      sessionService,
+		frontendURL:         frontendURL,
+		resetTTL:            resetTTL,
+		cooldown:            cooldown,
+		maxPerDay:           maxPerDay,
+	}
+}
+
+// RequestReset emails a password reset link if email belongs to an account.
+// It reports nothing about whether it does: the email is sent in the
+// background and failures, including rate limits, are only logged.
+func (s *PasswordService) RequestReset(email string) error {
+	var user database.User
+	if err := s.db.Where("email = ?", strings.TrimSpace(email)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	go func() {
+		if err := s.sendResetLink(&user); err != nil {
+			log.Printf("Failed to send password reset to user %s: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func (s *PasswordService) sendResetLink(user *database.User) error {
+	if err := checkSendLimits(s.db, user.ID, KindPasswordReset, s.cooldown, s.maxPerDay); err != nil {
+		return err
+	}
+
+	token, err := auth.GenerateRandomToken(resetTokenBytes)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Create(&database.PasswordResetToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(s.resetTTL),
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+
+	token = url.QueryEscape(token)
+	msg, err := notify.Render(KindPasswordReset, notify.ChannelEmail, map[string]interface{}{
+		"Name":     ownerGreeting(user),
+		"ResetURL": s.frontendURL + "/reset-password?token=" + token,
+		"Minutes":  int(s.resetTTL / time.Minute),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.notificationService.Send(NotificationRequest{
+		UserID:      &user.ID,
+		Channel:     notify.ChannelEmail,
+		Recipient:   user.Email,
+		Subject:     msg.Subject,
+		Body:        msg.Body,
+		Kind:        KindPasswordReset,
+		RelatedType: "user",
+		RelatedID:   &user.ID,
+		// The link replaces the current password, so it mustn't be readable
+		// from the delivery log by a signed-in session
+		Secrets: []string{token},
+	})
+	return err
+}
+
+// ResetPassword sets a new password with a token from a reset link. The
+// token works once.
+func (s *PasswordService) ResetPassword(token, newPassword string) error {
+	var resetToken database.PasswordResetToken
+	if err := s.db.Where("token_hash = ?", auth.HashToken(token)).First(&resetToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if resetToken.UsedAt != nil || !time.Now().Before(resetToken.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	return s.setPassword(resetToken.UserID, newPassword, &resetToken.ID)
+}
+
+// ChangePassword replaces the user's password after checking the current
+// one
+func (s *PasswordService) ChangePassword(userID uuid.UUID, currentPassword, newPassword string) error {
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	if !auth.CheckPasswordHash(currentPassword, user.PasswordHash) {
+		return ErrWrongPassword
+	}
+	return s.setPassword(userID, newPassword, nil)
+}
+
+// setPassword stores the new password, using up the reset token it was set
+// with if any, discards outstanding reset links and signs the user out of
+// every session. Bumping the password version voids two-factor challenges
+// started with the old password.
+func (s *PasswordService) setPassword(userID uuid.UUID, newPassword string, resetTokenID *uuid.UUID) error {
+	hash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if resetTokenID != nil {
+			// Use the token conditionally so two concurrent requests can't both
+			result := tx.Model(&database.PasswordResetToken{}).
+				Where("id = ? AND used_at IS NULL", *resetTokenID).
+				Update("used_at", time.Now())
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInvalidResetToken
+			}
+		}
+		if err := tx.Model(&database.User{}).
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"password_hash":    hash,
+				"password_version": gorm.Expr("password_version + 1"),
+				"updated_at":       time.Now(),
+			}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&database.PasswordResetToken{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.sessionService.RevokeAll(userID, SessionPasswordChanged)
+}
+
+// Start removes expired reset tokens every interval
+func (s *PasswordService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.Cleanup()
+		}
+	}()
+}
+
+// Cleanup deletes reset tokens that have expired
+func (s *PasswordService) Cleanup() {
+	if err := s.db.Where("expires_at < ?", time.Now()).Delete(&database.PasswordResetToken{}).Error; err != nil {
+		log.Printf("Failed to delete expired password reset tokens: %v", err)
+	}
+}
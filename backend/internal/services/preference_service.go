@@ -0,0 +1,248 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"medical-records-app/internal/recurrence"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notification kinds a user can route to channels
+const (
+	KindReminderDue         = "reminder_due"
+	KindAppointmentUpcoming = "appointment_upcoming"
+	KindMedicationRefill    = "medication_refill"
+	KindShareAccessed       = "share_accessed"
+	KindShareDeactivated    = "share_deactivated"
+	KindShareReceived       = "share_received"
+
+	// KindReminderEscalation goes to emergency contacts, so it isn't routed
+	// through the user's channel preferences
+	KindReminderEscalation = "reminder_escalation"
+	// KindEmailVerification proves the user owns their address, so it always
+	// goes by email
+	KindEmailVerification = "email_verification"
+	// KindPhoneVerification is always texted to the number being verified
+	KindPhoneVerification = "phone_verification"
+	// KindPasswordReset links go to the account's email address
+	KindPasswordReset = "password_reset"
+)
+
+// Delivery modes
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryDigest    = "digest" // critical reminders are still sent immediately
+)
+
+var defaultChannels = map[string][]string{
+	KindReminderDue:         {notify.ChannelEmail},
+	KindAppointmentUpcoming: {notify.ChannelEmail},
+	KindMedicationRefill:    {notify.ChannelEmail},
+	KindShareAccessed:       {notify.ChannelEmail},
+	KindShareDeactivated:    {notify.ChannelEmail},
+	KindShareReceived:       {notify.ChannelEmail},
+}
+
+// PreferenceService manages per-user timezone and notification preferences
+type PreferenceService struct {
+	db *gorm.DB
+}
+
+func NewPreferenceService(db *gorm.DB) *PreferenceService {
+	return &PreferenceService{db: db}
+}
+
+// UpdatePreferencesRequest is a partial update; nil fields are left unchanged
+type UpdatePreferencesRequest struct {
+	Timezone               *string             `json:"timezone"`
+	Channels               map[string][]string `json:"channels"`
+	QuietHoursStart        *string             `json:"quiet_hours_start"`
+	QuietHoursEnd          *string             `json:"quiet_hours_end"`
+	AppointmentLeadMinutes *int                `json:"appointment_lead_minutes"`
+	RefillLeadDays         *int                `json:"refill_lead_days"`
+	DeliveryMode           *string             `json:"delivery_mode"`
+	DigestHour             *int                `json:"digest_hour"`
+}
+
+// GetPreferences returns the user's preferences, or the defaults if they
+// haven't saved any
+func (s *PreferenceService) GetPreferences(userID uuid.UUID) (*database.UserPreference, error) {
+	return loadPreferences(s.db, userID)
+}
+
+func (s *PreferenceService) UpdatePreferences(userID uuid.UUID, req UpdatePreferencesRequest) (*database.UserPreference, error) {
+	prefs, err := loadPreferences(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			return nil, fmt.Errorf("invalid timezone %q", *req.Timezone)
+		}
+		prefs.Timezone = *req.Timezone
+	}
+	if req.Channels != nil {
+		channels := channelsOf(prefs)
+		for kind, list := range req.Channels {
+			if _, ok := defaultChannels[kind]; !ok {
+				return nil, fmt.Errorf("unknown notification kind %q", kind)
+			}
+			for _, channel := range list {
+				if channel != notify.ChannelEmail && channel != notify.ChannelSMS {
+					return nil, fmt.Errorf("unknown channel %q", channel)
+				}
+			}
+			channels[kind] = list
+		}
+		encoded, err := json.Marshal(channels)
+		if err != nil {
+			return nil, err
+		}
+		prefs.Channels = string(encoded)
+	}
+	if req.QuietHoursStart != nil {
+		prefs.QuietHoursStart = *req.QuietHoursStart
+	}
+	if req.QuietHoursEnd != nil {
+		prefs.QuietHoursEnd = *req.QuietHoursEnd
+	}
+	if (prefs.QuietHoursStart == "") != (prefs.QuietHoursEnd == "") {
+		return nil, errors.New("quiet_hours_start and quiet_hours_end must be set together")
+	}
+	for _, v := range []string{prefs.QuietHoursStart, prefs.QuietHoursEnd} {
+		if _, err := parseClock(v); v != "" && err != nil {
+			return nil, fmt.Errorf("invalid quiet hours time %q, expected HH:MM", v)
+		}
+	}
+	if req.AppointmentLeadMinutes != nil {
+		if *req.AppointmentLeadMinutes < 0 || *req.AppointmentLeadMinutes > 14*24*60 {
+			return nil, errors.New("appointment_lead_minutes must be between 0 and 20160")
+		}
+		prefs.AppointmentLeadMinutes = *req.AppointmentLeadMinutes
+	}
+	if req.RefillLeadDays != nil {
+		if *req.RefillLeadDays < 0 || *req.RefillLeadDays > 60 {
+			return nil, errors.New("refill_lead_days must be between 0 and 60")
+		}
+		prefs.RefillLeadDays = *req.RefillLeadDays
+	}
+	if req.DeliveryMode != nil {
+		if *req.DeliveryMode != DeliveryImmediate && *req.DeliveryMode != DeliveryDigest {
+			return nil, errors.New("delivery_mode must be immediate or digest")
+		}
+		prefs.DeliveryMode = *req.DeliveryMode
+	}
+	if req.DigestHour != nil {
+		if *req.DigestHour < 0 || *req.DigestHour > 23 {
+			return nil, errors.New("digest_hour must be between 0 and 23")
+		}
+		prefs.DigestHour = *req.DigestHour
+	}
+
+	prefs.UpdatedAt = time.Now()
+	if prefs.ID == uuid.Nil {
+		prefs.ID = uuid.New()
+		prefs.CreatedAt = time.Now()
+		if err := s.db.Create(prefs).Error; err != nil {
+			return nil, err
+		}
+		return prefs, nil
+	}
+	if err := s.db.Save(prefs).Error; err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// loadPreferences reads a user's preferences, falling back to defaults. The
+// returned value has a nil ID when nothing has been saved yet.
+func loadPreferences(db *gorm.DB, userID uuid.UUID) (*database.UserPreference, error) {
+	var prefs database.UserPreference
+	err := db.Where("user_id = ?", userID).First(&prefs).Error
+	if err == nil {
+		return &prefs, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return defaultPreferences(userID), nil
+}
+
+func defaultPreferences(userID uuid.UUID) *database.UserPreference {
+	encoded, _ := json.Marshal(defaultChannels)
+	return &database.UserPreference{
+		UserID:                 userID,
+		Timezone:               "UTC",
+		Channels:               string(encoded),
+		AppointmentLeadMinutes: 24 * 60,
+		RefillLeadDays:         7,
+		DeliveryMode:           DeliveryImmediate,
+		DigestHour:             8,
+	}
+}
+
+// userLocation returns the user's timezone, defaulting to UTC
+func userLocation(db *gorm.DB, userID uuid.UUID) *time.Location {
+	prefs, err := loadPreferences(db, userID)
+	if err != nil {
+		return time.UTC
+	}
+	return preferenceLocation(prefs)
+}
+
+func preferenceLocation(prefs *database.UserPreference) *time.Location {
+	loc, err := recurrence.LoadLocation(prefs.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// channelsOf decodes the kind -> channels map, filling in defaults
+func channelsOf(prefs *database.UserPreference) map[string][]string {
+	channels := make(map[string][]string, len(defaultChannels))
+	for kind, list := range defaultChannels {
+		channels[kind] = list
+	}
+	if prefs.Channels != "" {
+		var saved map[string][]string
+		if err := json.Unmarshal([]byte(prefs.Channels), &saved); err == nil {
+			for kind, list := range saved {
+				channels[kind] = list
+			}
+		}
+	}
+	return channels
+}
+
+// inQuietHours reports whether t falls in the user's quiet hours. Ranges may
+// wrap midnight, e.g. 22:00-07:00.
+func inQuietHours(prefs *database.UserPreference, t time.Time) bool {
+	start, err1 := parseClock(prefs.QuietHoursStart)
+	end, err2 := parseClock(prefs.QuietHoursEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+	local := t.In(preferenceLocation(prefs))
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// parseClock turns "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
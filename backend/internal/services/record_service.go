@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"medical-records-app/internal/database"
 	"time"
 
@@ -18,6 +19,11 @@ func NewRecordService(db *gorm.DB) *RecordService {
 
 // Prescription methods
 func (s *RecordService) CreatePrescription(userID uuid.UUID, prescription *database.Prescription) error {
+	tags, err := prescription.Tags.Normalize()
+	if err != nil {
+		return err
+	}
+	prescription.Tags = tags
 	prescription.UserID = userID
 	prescription.ID = uuid.New()
 	prescription.CreatedAt = time.Now()
@@ -48,6 +54,9 @@ func (s *RecordService) GetPrescriptionByID(userID, prescriptionID uuid.UUID) (*
 }
 
 func (s *RecordService) UpdatePrescription(userID, prescriptionID uuid.UUID, updates map[string]interface{}) error {
+	if err := normalizeTagUpdate(updates); err != nil {
+		return err
+	}
 	updates["updated_at"] = time.Now()
 	return s.db.Model(&database.Prescription{}).
 		Where("id = ? AND user_id = ?", prescriptionID, userID).
@@ -61,6 +70,11 @@ func (s *RecordService) DeletePrescription(userID, prescriptionID uuid.UUID) err
 
 // Appointment methods
 func (s *RecordService) CreateAppointment(userID uuid.UUID, appointment *database.Appointment) error {
+	tags, err := appointment.Tags.Normalize()
+	if err != nil {
+		return err
+	}
+	appointment.Tags = tags
 	appointment.UserID = userID
 	appointment.ID = uuid.New()
 	appointment.CreatedAt = time.Now()
@@ -94,6 +108,9 @@ func (s *RecordService) GetAppointmentByID(userID, appointmentID uuid.UUID) (*da
 }
 
 func (s *RecordService) UpdateAppointment(userID, appointmentID uuid.UUID, updates map[string]interface{}) error {
+	if err := normalizeTagUpdate(updates); err != nil {
+		return err
+	}
 	updates["updated_at"] = time.Now()
 	return s.db.Model(&database.Appointment{}).
 		Where("id = ? AND user_id = ?", appointmentID, userID).
@@ -107,6 +124,11 @@ func (s *RecordService) DeleteAppointment(userID, appointmentID uuid.UUID) error
 
 // Lab Report methods
 func (s *RecordService) CreateLabReport(userID uuid.UUID, labReport *database.LabReport) error {
+	tags, err := labReport.Tags.Normalize()
+	if err != nil {
+		return err
+	}
+	labReport.Tags = tags
 	labReport.UserID = userID
 	labReport.ID = uuid.New()
 	labReport.CreatedAt = time.Now()
@@ -137,6 +159,9 @@ func (s *RecordService) GetLabReportByID(userID, labReportID uuid.UUID) (*databa
 }
 
 func (s *RecordService) UpdateLabReport(userID, labReportID uuid.UUID, updates map[string]interface{}) error {
+	if err := normalizeTagUpdate(updates); err != nil {
+		return err
+	}
 	updates["updated_at"] = time.Now()
 	return s.db.Model(&database.LabReport{}).
 		Where("id = ? AND user_id = ?", labReportID, userID).
@@ -177,3 +202,30 @@ func (s *RecordService) DeleteHealthInsurance(userID, insuranceID uuid.UUID) err
 		Delete(&database.HealthInsurance{}).Error
 }
 
+
+// normalizeTagUpdate turns the tags in a JSON update into database.Tags, so
+// they are stored as one JSON array
+func normalizeTagUpdate(updates map[string]interface{}) error {
+	raw, ok := updates["tags"]
+	if !ok {
+		return nil
+	}
+	list, ok := raw.([]interface{})
+	if raw != nil && !ok {
+		return errors.New("tags must be a list of strings")
+	}
+	tags := make(database.Tags, 0, len(list))
+	for _, item := range list {
+		tag, ok := item.(string)
+		if !ok {
+			return errors.New("tags must be a list of strings")
+		}
+		tags = append(tags, tag)
+	}
+	normalized, err := tags.Normalize()
+	if err != nil {
+		return err
+	}
+	updates["tags"] = normalized
+	return nil
+}
@@ -0,0 +1,361 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/events"
+	"medical-records-app/internal/notify"
+	"medical-records-app/internal/recurrence"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxAppointmentLead bounds the appointment lead time users can choose, so
+// upcoming appointments can be pre-filtered in SQL
+const maxAppointmentLead = 14 * 24 * time.Hour
+
+// maxZoneOffset covers the widest UTC offset, so wall-clock columns can be
+// pre-filtered in SQL before the exact per-timezone check
+const maxZoneOffset = 14 * time.Hour
+
+const notificationTimeLayout = "Mon Jan 2, 2006 3:04 PM MST"
+
+// ReminderDispatcher sends notifications for reminders that have come due,
+// upcoming appointments and medication refills, following each user's
+// preferences for channels, lead times, quiet hours and digests
+type ReminderDispatcher struct {
+	db                  *gorm.DB
+	notificationService *NotificationService
+	eventService        *EventService
+}
+
+func NewReminderDispatcher(db *gorm.DB, notificationService *NotificationService, eventService *EventService) *ReminderDispatcher {
+	return &ReminderDispatcher{
+		db:                  db,
+		notificationService: notificationService,
+		eventService:        eventService,
+	}
+}
+
+// Start runs the dispatcher in the background
+func (d *ReminderDispatcher) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			d.DispatchDue()
+		}
+	}()
+}
+
+// DispatchDue notifies users about due reminders, upcoming appointments and
+// refills, then sends any digests that are due
+func (d *ReminderDispatcher) DispatchDue() {
+	now := time.Now()
+	prefs := make(map[uuid.UUID]*database.UserPreference)
+	d.dispatchReminders(now, prefs)
+	d.dispatchAppointments(now, prefs)
+	d.dispatchRefills(now, prefs)
+	d.dispatchEscalations(now)
+	d.dispatchDigests(now)
+}
+
+// preferencesFor caches preferences for the duration of one tick
+func (d *ReminderDispatcher) preferencesFor(userID uuid.UUID, cache map[uuid.UUID]*database.UserPreference) *database.UserPreference {
+	if prefs, ok := cache[userID]; ok {
+		return prefs
+	}
+	prefs, err := loadPreferences(d.db, userID)
+	if err != nil {
+		log.Printf("Failed to load preferences for user %s: %v", userID, err)
+		prefs = defaultPreferences(userID)
+	}
+	cache[userID] = prefs
+	return prefs
+}
+
+func (d *ReminderDispatcher) dispatchReminders(now time.Time, cache map[uuid.UUID]*database.UserPreference) {
+	var reminders []database.Reminder
+	if err := d.db.Preload("User").
+		Where("is_completed = ? AND reminder_sent = ? AND dismissed_at IS NULL", false, false).
+		Where("snoozed_until IS NULL OR snoozed_until <= ?", now).
+		Where("reminder_date <= ?", recurrence.WallClock(now.UTC()).Add(maxZoneOffset)).
+		Find(&reminders).Error; err != nil {
+		log.Printf("Failed to load due reminders: %v", err)
+		return
+	}
+
+	for i := range reminders {
+		reminder := &reminders[i]
+		prefs := d.preferencesFor(reminder.UserID, cache)
+		loc := preferenceLocation(prefs)
+		if reminder.Timezone != "" {
+			if l, err := recurrence.LoadLocation(reminder.Timezone); err == nil {
+				loc = l
+			}
+		}
+		dueAt := recurrence.InLocation(reminder.ReminderDate.Time, loc)
+		if dueAt.After(now) || d.deferred(prefs, now) {
+			continue
+		}
+		if !d.claimReminder(reminder, now) {
+			continue
+		}
+
+		d.eventService.Publish(reminder.UserID, events.ReminderDue, map[string]interface{}{
+			"reminder_id":   reminder.ID,
+			"title":         reminder.Title,
+			"reminder_type": reminder.ReminderType,
+			"due_at":        dueAt,
+			"is_critical":   reminder.IsCritical,
+		})
+
+		// Escalation counts from notified_at, which the claim just set, so
+		// critical reminders go out now even for digest users
+		queue := prefs.DeliveryMode == DeliveryDigest && !reminder.IsCritical
+		d.deliver(&reminder.User, prefs, KindReminderDue, NotificationRequest{
+			Subject:     "Reminder: " + reminder.Title,
+			Body:        fmt.Sprintf("Hi %s,\n\nThis is your reminder for \"%s\", due %s.\n\n%s\n", reminder.User.FirstName, reminder.Title, dueAt.Format(notificationTimeLayout), reminder.Description),
+			RelatedType: "reminder",
+			RelatedID:   &reminder.ID,
+		}, queue)
+	}
+}
+
+func (d *ReminderDispatcher) dispatchAppointments(now time.Time, cache map[uuid.UUID]*database.UserPreference) {
+	wallNow := recurrence.WallClock(now.UTC())
+
+	var appointments []database.Appointment
+	if err := d.db.Preload("User").
+		Where("is_completed = ? AND reminder_sent = ?", false, false).
+		Where("appointment_date >= ? AND appointment_date <= ?", wallNow.Add(-maxZoneOffset), wallNow.Add(maxAppointmentLead+maxZoneOffset)).
+		Find(&appointments).Error; err != nil {
+		log.Printf("Failed to load upcoming appointments: %v", err)
+		return
+	}
+
+	for i := range appointments {
+		appointment := &appointments[i]
+		prefs := d.preferencesFor(appointment.UserID, cache)
+		lead := time.Duration(prefs.AppointmentLeadMinutes) * time.Minute
+		startsAt := recurrence.InLocation(appointment.AppointmentDate.Time, preferenceLocation(prefs))
+		if startsAt.Before(now) || startsAt.Sub(now) > lead || d.deferred(prefs, now) {
+			continue
+		}
+		if !d.claim(&database.Appointment{}, appointment.ID) {
+			continue
+		}
+
+		d.notify(&appointment.User, prefs, KindAppointmentUpcoming, NotificationRequest{
+			Subject:     "Upcoming appointment with " + appointment.DoctorName,
+			Body:        fmt.Sprintf("Hi %s,\n\nYou have an appointment with %s on %s at %s.\n", appointment.User.FirstName, appointment.DoctorName, startsAt.Format(notificationTimeLayout), appointment.Hospital),
+			RelatedType: "appointment",
+			RelatedID:   &appointment.ID,
+		})
+	}
+}
+
+func (d *ReminderDispatcher) dispatchRefills(now time.Time, cache map[uuid.UUID]*database.UserPreference) {
+	// Refill lead times are capped at 60 days by the preferences endpoint
+	var medications []database.Medication
+	if err := d.db.Preload("User").
+		Where("is_active = ? AND next_refill_date IS NOT NULL", true).
+		Where("next_refill_date <= ?", now.AddDate(0, 0, 61).Format("2006-01-02")).
+		Where("refill_reminder_sent_for IS NULL OR refill_reminder_sent_for <> next_refill_date").
+		Find(&medications).Error; err != nil {
+		log.Printf("Failed to load medications due for refill: %v", err)
+		return
+	}
+
+	for i := range medications {
+		medication := &medications[i]
+		prefs := d.preferencesFor(medication.UserID, cache)
+		refillOn := medication.NextRefillDate.Time.Format("2006-01-02")
+		if refillOn > refillCutoff(prefs, now) || d.deferred(prefs, now) {
+			continue
+		}
+
+		// Claim by recording which refill date was notified, so the next
+		// refill date gets its own notification
+		result := d.db.Model(&database.Medication{}).
+			Where("id = ? AND (refill_reminder_sent_for IS NULL OR refill_reminder_sent_for <> next_refill_date)", medication.ID).
+			Update("refill_reminder_sent_for", gorm.Expr("next_refill_date"))
+		if result.Error != nil || result.RowsAffected != 1 {
+			continue
+		}
+
+		d.notify(&medication.User, prefs, KindMedicationRefill, NotificationRequest{
+			Subject:     "Refill due: " + medication.MedicineName,
+			Body:        fmt.Sprintf("Hi %s,\n\nYour %s refill is due on %s.\n\nPharmacy: %s %s\n", medication.User.FirstName, medication.MedicineName, medication.NextRefillDate.Time.Format("Mon Jan 2, 2006"), medication.PharmacyName, medication.PharmacyPhone),
+			RelatedType: "medication",
+			RelatedID:   &medication.ID,
+		})
+	}
+}
+
+// dispatchDigests sends the daily digest to digest-mode users whose digest
+// hour has arrived in their timezone and who haven't had one today
+func (d *ReminderDispatcher) dispatchDigests(now time.Time) {
+	var prefs []database.UserPreference
+	if err := d.db.Where("delivery_mode = ?", DeliveryDigest).Find(&prefs).Error; err != nil {
+		log.Printf("Failed to load digest preferences: %v", err)
+		return
+	}
+
+	for i := range prefs {
+		p := &prefs[i]
+		local := now.In(preferenceLocation(p))
+		if local.Hour() < p.DigestHour {
+			continue
+		}
+		if p.LastDigestAt != nil {
+			last := p.LastDigestAt.In(local.Location())
+			if last.Year() == local.Year() && last.YearDay() == local.YearDay() {
+				continue
+			}
+		}
+
+		// Claim today's digest before sending so replicas don't double up
+		query := d.db.Model(&database.UserPreference{}).Where("id = ?", p.ID)
+		if p.LastDigestAt == nil {
+			query = query.Where("last_digest_at IS NULL")
+		} else {
+			query = query.Where("last_digest_at = ?", p.LastDigestAt)
+		}
+		result := query.Update("last_digest_at", now)
+		if result.Error != nil || result.RowsAffected != 1 {
+			continue
+		}
+
+		if err := d.notificationService.SendDigest(p.UserID); err != nil {
+			log.Printf("Failed to send digest to user %s: %v", p.UserID, err)
+		}
+	}
+}
+
+// deferred reports whether immediate notifications should wait for the end of
+// the user's quiet hours. Digest users are never deferred since nothing is
+// sent until their digest.
+func (d *ReminderDispatcher) deferred(prefs *database.UserPreference, now time.Time) bool {
+	return prefs.DeliveryMode != DeliveryDigest && inQuietHours(prefs, now)
+}
+
+// notify sends (or queues, for digest users) the notification on each channel
+// the user chose for this kind
+func (d *ReminderDispatcher) notify(user *database.User, prefs *database.UserPreference, kind string, req NotificationRequest) {
+	d.deliver(user, prefs, kind, req, prefs.DeliveryMode == DeliveryDigest)
+}
+
+// deliver sends the notification on each channel the user chose for this
+// kind, or queues it for their digest when queue is set
+func (d *ReminderDispatcher) deliver(user *database.User, prefs *database.UserPreference, kind string, req NotificationRequest, queue bool) {
+	req.UserID = &user.ID
+	req.Kind = kind
+
+	for _, channel := range channelsOf(prefs)[kind] {
+		req.Channel = channel
+		req.Recipient = userRecipient(user, channel)
+		if req.Recipient == "" {
+			continue
+		}
+
+		var err error
+		if queue {
+			_, err = d.notificationService.Queue(req)
+		} else {
+			_, err = d.notificationService.Send(req)
+		}
+		if err != nil {
+			log.Printf("Failed to send %s %s notification to user %s: %v", kind, channel, user.ID, err)
+		}
+	}
+}
+
+// dispatchEscalations notifies the emergency contacts of users who haven't
+// acknowledged a critical reminder within its escalation threshold. Quiet
+// hours and digests don't apply: escalations always go out immediately.
+func (d *ReminderDispatcher) dispatchEscalations(now time.Time) {
+	var reminders []database.Reminder
+	if err := d.db.Preload("User").
+		Where("is_critical = ? AND is_completed = ?", true, false).
+		Where("acknowledged_at IS NULL AND dismissed_at IS NULL AND escalated_at IS NULL AND notified_at IS NOT NULL").
+		Where("notified_at + escalate_after_minutes * INTERVAL '1 minute' <= ?", now).
+		Find(&reminders).Error; err != nil {
+		log.Printf("Failed to load reminders to escalate: %v", err)
+		return
+	}
+
+	for i := range reminders {
+		reminder := &reminders[i]
+		result := d.db.Model(&database.Reminder{}).
+			Where("id = ? AND escalated_at IS NULL AND acknowledged_at IS NULL", reminder.ID).
+			Update("escalated_at", now)
+		if result.Error != nil || result.RowsAffected != 1 {
+			continue
+		}
+
+		var contacts []database.EmergencyContact
+		if err := d.db.Where("user_id = ? AND notify_on_escalation = ?", reminder.UserID, true).
+			Find(&contacts).Error; err != nil {
+			log.Printf("Failed to load emergency contacts for user %s: %v", reminder.UserID, err)
+			continue
+		}
+
+		name := strings.TrimSpace(reminder.User.FirstName + " " + reminder.User.LastName)
+		notified := 0
+		for _, contact := range contacts {
+			req := NotificationRequest{
+				UserID:      &reminder.UserID,
+				Subject:     fmt.Sprintf("%s hasn't acknowledged a reminder", name),
+				Body:        fmt.Sprintf("Hi %s,\n\n%s hasn't acknowledged their reminder \"%s\", sent %s. You're receiving this because you are listed as their emergency contact.\n", contact.Name, name, reminder.Title, reminder.NotifiedAt.Format(notificationTimeLayout)),
+				Kind:        KindReminderEscalation,
+				RelatedType: "reminder",
+				RelatedID:   &reminder.ID,
+			}
+			for channel, recipient := range map[string]string{notify.ChannelEmail: contact.Email, notify.ChannelSMS: contact.Phone} {
+				if recipient == "" {
+					continue
+				}
+				req.Channel, req.Recipient = channel, recipient
+				if _, err := d.notificationService.Send(req); err != nil {
+					log.Printf("Failed to escalate reminder %s to contact %s: %v", reminder.ID, contact.ID, err)
+					continue
+				}
+				notified++
+			}
+		}
+
+		details := fmt.Sprintf("%d notification(s) sent to %d emergency contact(s)", notified, len(contacts))
+		if err := recordReminderEvent(d.db, reminder, ReminderEscalated, nil, details); err != nil {
+			log.Printf("Failed to record escalation of reminder %s: %v", reminder.ID, err)
+		}
+	}
+}
+
+// claimReminder marks the reminder as notified so it is only sent once, even
+// with several replicas polling
+func (d *ReminderDispatcher) claimReminder(reminder *database.Reminder, now time.Time) bool {
+	result := d.db.Model(&database.Reminder{}).
+		Where("id = ? AND reminder_sent = ?", reminder.ID, false).
+		Updates(map[string]interface{}{"reminder_sent": true, "notified_at": now})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false
+	}
+	if err := recordReminderEvent(d.db, reminder, ReminderNotified, nil, ""); err != nil {
+		log.Printf("Failed to record notification of reminder %s: %v", reminder.ID, err)
+	}
+	return true
+}
+
+// claim flips reminder_sent so each reminder is only sent once, even with
+// several replicas polling
+func (d *ReminderDispatcher) claim(model interface{}, id interface{}) bool {
+	result := d.db.Model(model).
+		Where("id = ? AND reminder_sent = ?", id, false).
+		Update("reminder_sent", true)
+	return result.Error == nil && result.RowsAffected == 1
+}
@@ -1,7 +1,12 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"medical-records-app/internal/database"
+	"medical-records-app/internal/recurrence"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,20 +21,106 @@ func NewReminderService(db *gorm.DB) *ReminderService {
 	return &ReminderService{db: db}
 }
 
+// ReminderOccurrence is a single expanded instance of a reminder
+type ReminderOccurrence struct {
+	ReminderID   uuid.UUID  `json:"reminder_id"`
+	SeriesID     *uuid.UUID `json:"series_id,omitempty"`
+	Title        string     `json:"title"`
+	ReminderType string     `json:"reminder_type"`
+	OccursAt     time.Time  `json:"occurs_at"`
+	Timezone     string     `json:"timezone"`
+	IsRecurring  bool       `json:"is_recurring"`
+	IsCompleted  bool       `json:"is_completed"`
+}
+
+// Reminder history actions
+const (
+	ReminderNotified     = "notified"
+	ReminderSnoozed      = "snoozed"
+	ReminderAcknowledged = "acknowledged"
+	ReminderDismissed    = "dismissed"
+	ReminderCompleted    = "completed"
+	ReminderSkipped      = "skipped"
+	ReminderEscalated    = "escalated"
+)
+
+const (
+	// defaultEscalateAfterMinutes applies to critical reminders created
+	// without an explicit threshold
+	defaultEscalateAfterMinutes = 30
+	maxSnooze                   = 7 * 24 * time.Hour
+)
+
+// ErrNotAnOccurrence is returned when skipping a time that isn't an
+// occurrence of the series, or was already skipped
+var ErrNotAnOccurrence = errors.New("that time is not an occurrence of this reminder, or it was already skipped")
+
+var errLastOccurrence = errors.New("cannot skip the last occurrence of a series; complete or delete it instead")
+
+// exceptionLayout is how skipped occurrences are stored: wall-clock time in the
+// reminder's timezone
+const exceptionLayout = "2006-01-02T15:04:05"
+
 func (s *ReminderService) CreateReminder(userID uuid.UUID, reminder *database.Reminder) error {
 	reminder.UserID = userID
 	reminder.ID = uuid.New()
+	if reminder.Timezone == "" {
+		reminder.Timezone = userLocation(s.db, userID).String()
+	}
+	if reminder.IsCritical && reminder.EscalateAfterMinutes <= 0 {
+		reminder.EscalateAfterMinutes = defaultEscalateAfterMinutes
+	}
+	if reminder.IsRecurring {
+		reminder.SeriesID = &reminder.ID
+		if reminder.SeriesStart.IsZero() {
+			reminder.SeriesStart = reminder.ReminderDate
+		}
+	}
 	reminder.CreatedAt = time.Now()
 	reminder.UpdatedAt = time.Now()
 	return s.db.Create(reminder).Error
 }
 
+// ValidateRecurrence checks the timezone and recurrence settings of a reminder
+// and normalizes them, turning a legacy RecurrenceInterval into an RRULE
+func (s *ReminderService) ValidateRecurrence(reminder *database.Reminder) error {
+	if _, err := recurrence.LoadLocation(reminder.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", reminder.Timezone)
+	}
+
+	if reminder.RecurrenceRule == "" && reminder.IsRecurring {
+		if reminder.RecurrenceInterval == "" {
+			return errors.New("recurring reminders need a recurrence_rule or recurrence_interval")
+		}
+		rule, err := recurrence.FromInterval(reminder.RecurrenceInterval)
+		if err != nil {
+			return err
+		}
+		reminder.RecurrenceRule = rule.String()
+	}
+
+	if reminder.RecurrenceRule != "" {
+		rule, err := recurrence.Parse(reminder.RecurrenceRule)
+		if err != nil {
+			return fmt.Errorf("invalid recurrence_rule: %v", err)
+		}
+		reminder.RecurrenceRule = rule.String()
+		reminder.IsRecurring = true
+	}
+
+	if _, err := parseExceptions(reminder.RecurrenceExceptions); err != nil {
+		return errors.New("recurrence_exceptions must be a JSON array of datetimes")
+	}
+
+	return nil
+}
+
 func (s *ReminderService) GetReminders(userID uuid.UUID, upcomingOnly bool) ([]database.Reminder, error) {
 	var reminders []database.Reminder
 	query := s.db.Where("user_id = ?", userID)
 	
 	if upcomingOnly {
-		query = query.Where("reminder_date >= ? AND is_completed = ?", time.Now(), false)
+		query = query.Where("reminder_date >= ? AND is_completed = ? AND dismissed_at IS NULL", time.Now(), false)
 	}
 	
 	if err := query.Order("reminder_date ASC").Find(&reminders).Error; err != nil {
@@ -62,7 +153,7 @@ func (s *ReminderService) GetUpcomingReminders(userID uuid.UUID, daysAhead int)
 	var reminders []database.Reminder
 	cutoffDate := time.Now().AddDate(0, 0, daysAhead)
 	
-	if err := s.db.Where("user_id = ? AND is_completed = ?", userID, false).
+	if err := s.db.Where("user_id = ? AND is_completed = ? AND dismissed_at IS NULL", userID, false).
 		Where("reminder_date >= ? AND reminder_date <= ?", time.Now(), cutoffDate).
 		Order("reminder_date ASC").
 		Find(&reminders).Error; err != nil {
@@ -72,3 +163,454 @@ func (s *ReminderService) GetUpcomingReminders(userID uuid.UUID, daysAhead int)
 	return reminders, nil
 }
 
+// CompleteReminder marks a reminder as completed. For recurring reminders the
+// next occurrence of the series is created and returned.
+func (s *ReminderService) CompleteReminder(userID, reminderID uuid.UUID) (*database.Reminder, *database.Reminder, error) {
+	var completed database.Reminder
+	var next *database.Reminder
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", reminderID, userID).First(&completed).Error; err != nil {
+			return err
+		}
+		if completed.IsCompleted {
+			return errors.New("reminder is already completed")
+		}
+
+		completed.IsCompleted = true
+		completed.UpdatedAt = time.Now()
+		if err := tx.Model(&completed).Updates(map[string]interface{}{
+			"is_completed": true,
+			"updated_at":   completed.UpdatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		if err := recordReminderEvent(tx, &completed, ReminderCompleted, nil, ""); err != nil {
+			return err
+		}
+
+		loc := reminderLocation(tx, &completed)
+		occursAt, ok, err := nextOccurrence(&completed, loc, time.Now())
+		if err != nil || !ok {
+			return err
+		}
+
+		next = &database.Reminder{
+			ID:                   uuid.New(),
+			UserID:               completed.UserID,
+			Title:                completed.Title,
+			Description:          completed.Description,
+			ReminderDate:         database.DateTime{Time: recurrence.WallClock(occursAt)},
+			ReminderType:         completed.ReminderType,
+			IsRecurring:          true,
+			RecurrenceInterval:   completed.RecurrenceInterval,
+			RecurrenceRule:       completed.RecurrenceRule,
+			RecurrenceExceptions: completed.RecurrenceExceptions,
+			Timezone:             loc.String(),
+			SeriesID:             completed.SeriesID,
+			SeriesStart:          completed.SeriesStart,
+			IsCritical:           completed.IsCritical,
+			EscalateAfterMinutes: completed.EscalateAfterMinutes,
+			CreatedAt:            time.Now(),
+			UpdatedAt:            time.Now(),
+		}
+		if next.SeriesID == nil {
+			next.SeriesID = &completed.ID
+		}
+		if next.SeriesStart.IsZero() {
+			next.SeriesStart = completed.ReminderDate
+		}
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &completed, next, nil
+}
+
+// SkipOccurrence adds an exception for one occurrence of a recurring reminder.
+// Skipping the reminder's current occurrence moves it on to the next one.
+func (s *ReminderService) SkipOccurrence(userID, reminderID uuid.UUID, occurrence time.Time) (*database.Reminder, error) {
+	reminder, err := s.GetReminderByID(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+	if !reminder.IsRecurring || reminder.IsCompleted {
+		return nil, errors.New("only open recurring reminders can skip occurrences")
+	}
+	// The reminder's current date can always be skipped, even if it was
+	// moved off the series; other dates must be occurrences
+	wall := recurrence.WallClock(occurrence)
+	if !wall.Equal(reminder.ReminderDate.Time) {
+		ok, err := isOccurrence(reminder, reminderLocation(s.db, reminder), wall)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrNotAnOccurrence
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := skipOccurrence(tx, reminder, occurrence); err != nil {
+			return err
+		}
+		return recordReminderEvent(tx, reminder, ReminderSkipped, nil, recurrence.WallClock(occurrence).Format(exceptionLayout))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reminder, nil
+}
+
+// skipOccurrence adds the exception and, if it was the reminder's current
+// occurrence, moves the reminder to the next one with a fresh alert state
+func skipOccurrence(db *gorm.DB, reminder *database.Reminder, occurrence time.Time) error {
+	exceptions, err := parseExceptions(reminder.RecurrenceExceptions)
+	if err != nil {
+		return err
+	}
+	wall := recurrence.WallClock(occurrence)
+	exceptions = append(exceptions, wall)
+
+	formatted := make([]string, len(exceptions))
+	for i, ex := range exceptions {
+		formatted[i] = ex.Format(exceptionLayout)
+	}
+	encoded, err := json.Marshal(formatted)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"recurrence_exceptions": string(encoded),
+		"updated_at":            time.Now(),
+	}
+
+	if wall.Equal(reminder.ReminderDate.Time) {
+		occursAt, ok, err := nextOccurrence(reminder, reminderLocation(db, reminder), time.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errLastOccurrence
+		}
+		updates["reminder_date"] = database.DateTime{Time: recurrence.WallClock(occursAt)}
+		updates["reminder_sent"] = false
+		updates["notified_at"] = nil
+		updates["snoozed_until"] = nil
+		updates["acknowledged_at"] = nil
+		updates["escalated_at"] = nil
+	}
+
+	if err := db.Model(reminder).Updates(updates).Error; err != nil {
+		return err
+	}
+	return db.First(reminder, "id = ?", reminder.ID).Error
+}
+
+// SnoozeReminder holds back the reminder's notification until the given time.
+// A snoozed reminder is notified again, and its escalation clock restarts.
+func (s *ReminderService) SnoozeReminder(userID, reminderID uuid.UUID, until time.Time) (*database.Reminder, error) {
+	now := time.Now()
+	if !until.After(now) {
+		return nil, errors.New("snooze time must be in the future")
+	}
+	if until.Sub(now) > maxSnooze {
+		return nil, errors.New("reminders can be snoozed for at most 7 days")
+	}
+
+	reminder, err := s.openReminder(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(reminder).Updates(map[string]interface{}{
+			"snoozed_until":   until,
+			"reminder_sent":   false,
+			"notified_at":     nil,
+			"acknowledged_at": nil,
+			"escalated_at":    nil,
+			"updated_at":      now,
+		}).Error; err != nil {
+			return err
+		}
+		return recordReminderEvent(tx, reminder, ReminderSnoozed, &until, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetReminderByID(userID, reminderID)
+}
+
+// AcknowledgeReminder records that the user has seen the reminder, which
+// stops it from being escalated
+func (s *ReminderService) AcknowledgeReminder(userID, reminderID uuid.UUID) (*database.Reminder, error) {
+	reminder, err := s.openReminder(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+	if reminder.AcknowledgedAt != nil {
+		return reminder, nil
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(reminder).Updates(map[string]interface{}{
+			"acknowledged_at": now,
+			"updated_at":      now,
+		}).Error; err != nil {
+			return err
+		}
+		return recordReminderEvent(tx, reminder, ReminderAcknowledged, nil, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetReminderByID(userID, reminderID)
+}
+
+// DismissReminder closes the reminder's current occurrence without completing
+// it. A recurring reminder moves on to its next occurrence; a one-off reminder
+// (or the last occurrence of a series) is marked dismissed.
+func (s *ReminderService) DismissReminder(userID, reminderID uuid.UUID) (*database.Reminder, error) {
+	reminder, err := s.openReminder(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrence := reminder.ReminderDate.Time.Format(exceptionLayout)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if reminder.IsRecurring {
+			err := skipOccurrence(tx, reminder, reminder.ReminderDate.Time)
+			if err == nil {
+				return recordReminderEvent(tx, reminder, ReminderDismissed, nil, occurrence)
+			}
+			if !errors.Is(err, errLastOccurrence) {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if err := tx.Model(reminder).Updates(map[string]interface{}{
+			"dismissed_at": now,
+			"updated_at":   now,
+		}).Error; err != nil {
+			return err
+		}
+		return recordReminderEvent(tx, reminder, ReminderDismissed, nil, occurrence)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetReminderByID(userID, reminderID)
+}
+
+// GetReminderHistory returns the events of a reminder, or of its whole series
+// for recurring reminders, newest first
+func (s *ReminderService) GetReminderHistory(userID, reminderID uuid.UUID) ([]database.ReminderEvent, error) {
+	reminder, err := s.GetReminderByID(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Where("user_id = ?", userID)
+	if reminder.SeriesID != nil {
+		query = query.Where("reminder_id IN (?)",
+			s.db.Model(&database.Reminder{}).Select("id").Where("series_id = ?", *reminder.SeriesID))
+	} else {
+		query = query.Where("reminder_id = ?", reminder.ID)
+	}
+
+	var events []database.ReminderEvent
+	if err := query.Order("created_at DESC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// openReminder loads a reminder that can still be acted on
+func (s *ReminderService) openReminder(userID, reminderID uuid.UUID) (*database.Reminder, error) {
+	reminder, err := s.GetReminderByID(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+	if reminder.IsCompleted {
+		return nil, errors.New("reminder is already completed")
+	}
+	if reminder.DismissedAt != nil {
+		return nil, errors.New("reminder has been dismissed")
+	}
+	return reminder, nil
+}
+
+func recordReminderEvent(db *gorm.DB, reminder *database.Reminder, action string, snoozedUntil *time.Time, details string) error {
+	return db.Create(&database.ReminderEvent{
+		ID:           uuid.New(),
+		ReminderID:   reminder.ID,
+		UserID:       reminder.UserID,
+		Action:       action,
+		SnoozedUntil: snoozedUntil,
+		Details:      details,
+		CreatedAt:    time.Now(),
+	}).Error
+}
+
+// GetOccurrences expands the user's reminders into the occurrences that fall
+// within [from, to]
+func (s *ReminderService) GetOccurrences(userID uuid.UUID, from, to time.Time) ([]ReminderOccurrence, error) {
+	// Reminder dates are wall-clock times, so widen the window by a day on each
+	// side to cover every timezone before filtering precisely below
+	var reminders []database.Reminder
+	if err := s.db.Where("user_id = ?", userID).
+		Where("reminder_date <= ?", to.Add(24*time.Hour)).
+		Where("((is_recurring = ? AND is_completed = ?) OR reminder_date >= ?)", true, false, from.Add(-24*time.Hour)).
+		Find(&reminders).Error; err != nil {
+		return nil, err
+	}
+
+	userLoc := userLocation(s.db, userID)
+	occurrences := []ReminderOccurrence{}
+	for i := range reminders {
+		reminder := &reminders[i]
+		loc := userLoc
+		if reminder.Timezone != "" {
+			if l, err := recurrence.LoadLocation(reminder.Timezone); err == nil {
+				loc = l
+			}
+		}
+
+		times := []time.Time{recurrence.InLocation(reminder.ReminderDate.Time, loc)}
+		if reminder.IsRecurring && !reminder.IsCompleted {
+			rule, dtstart, exceptions, err := seriesOf(reminder, loc)
+			if err != nil {
+				return nil, err
+			}
+			times = nil
+			current := recurrence.InLocation(reminder.ReminderDate.Time, loc)
+			for _, t := range recurrence.Expand(rule, dtstart, from, to, exceptions) {
+				if !t.Before(current) {
+					times = append(times, t)
+				}
+			}
+		}
+
+		for _, t := range times {
+			if t.Before(from) || t.After(to) {
+				continue
+			}
+			occurrences = append(occurrences, ReminderOccurrence{
+				ReminderID:   reminder.ID,
+				SeriesID:     reminder.SeriesID,
+				Title:        reminder.Title,
+				ReminderType: reminder.ReminderType,
+				OccursAt:     t,
+				Timezone:     loc.String(),
+				IsRecurring:  reminder.IsRecurring,
+				IsCompleted:  reminder.IsCompleted,
+			})
+		}
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].OccursAt.Before(occurrences[j].OccursAt)
+	})
+	return occurrences, nil
+}
+
+// nextOccurrence returns the occurrence of a recurring reminder's series that
+// follows both the reminder's own date and now, so moving an overdue reminder
+// on doesn't leave it overdue
+func nextOccurrence(reminder *database.Reminder, loc *time.Location, now time.Time) (time.Time, bool, error) {
+	if !reminder.IsRecurring {
+		return time.Time{}, false, nil
+	}
+	rule, dtstart, exceptions, err := seriesOf(reminder, loc)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	after := recurrence.InLocation(reminder.ReminderDate.Time, loc)
+	if now.After(after) {
+		after = now
+	}
+	next, ok := recurrence.Next(rule, dtstart, after, exceptions)
+	return next, ok, nil
+}
+
+// isOccurrence reports whether the wall-clock time is an occurrence of the
+// reminder's series that hasn't been skipped
+func isOccurrence(reminder *database.Reminder, loc *time.Location, wall time.Time) (bool, error) {
+	rule, dtstart, exceptions, err := seriesOf(reminder, loc)
+	if err != nil {
+		return false, err
+	}
+	t := recurrence.InLocation(wall, loc)
+	return len(recurrence.Expand(rule, dtstart, t, t, exceptions)) > 0, nil
+}
+
+// reminderLocation is the timezone a reminder's wall-clock time is in.
+// Reminders created before timezones were stored follow the user's timezone.
+func reminderLocation(db *gorm.DB, reminder *database.Reminder) *time.Location {
+	if reminder.Timezone != "" {
+		if loc, err := recurrence.LoadLocation(reminder.Timezone); err == nil {
+			return loc
+		}
+	}
+	return userLocation(db, reminder.UserID)
+}
+
+// seriesOf resolves the rule, DTSTART and exceptions of a recurring reminder
+func seriesOf(reminder *database.Reminder, loc *time.Location) (*recurrence.Rule, time.Time, []time.Time, error) {
+	var rule *recurrence.Rule
+	var err error
+	if reminder.RecurrenceRule != "" {
+		rule, err = recurrence.Parse(reminder.RecurrenceRule)
+	} else {
+		rule, err = recurrence.FromInterval(reminder.RecurrenceInterval)
+	}
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+
+	start := reminder.SeriesStart.Time
+	if start.IsZero() {
+		start = reminder.ReminderDate.Time
+	}
+
+	wallExceptions, err := parseExceptions(reminder.RecurrenceExceptions)
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+	exceptions := make([]time.Time, len(wallExceptions))
+	for i, ex := range wallExceptions {
+		exceptions[i] = recurrence.InLocation(ex, loc)
+	}
+
+	return rule, recurrence.InLocation(start, loc), exceptions, nil
+}
+
+// parseExceptions decodes the JSON array stored in RecurrenceExceptions
+func parseExceptions(raw string) ([]time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, err
+	}
+	exceptions := make([]time.Time, 0, len(values))
+	for _, v := range values {
+		if t, err := time.Parse(exceptionLayout, v); err == nil {
+			exceptions = append(exceptions, t)
+			continue
+		}
+		var dt database.DateTime
+		if err := dt.UnmarshalJSON([]byte(`"` + v + `"`)); err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, recurrence.WallClock(dt.Time))
+	}
+	return exceptions, nil
+}
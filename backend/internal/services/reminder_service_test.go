@@ -0,0 +1,78 @@
+package services
+
+import (
+	"medical-records-app/internal/database"
+	"testing"
+	"time"
+)
+
+func dailyReminder(date time.Time) *database.Reminder {
+	return &database.Reminder{
+		ReminderDate:         database.DateTime{Time: date},
+		SeriesStart:          database.DateTime{Time: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)},
+		IsRecurring:          true,
+		RecurrenceRule:       "FREQ=DAILY",
+		RecurrenceExceptions: `["2024-01-12T09:00:00"]`,
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	tests := []struct {
+		name string
+		date time.Time
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "upcoming reminder moves to the following day",
+			date: time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC),
+			now:  time.Date(2024, 1, 9, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "overdue reminder moves past now",
+			date: time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+			now:  time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "skipped occurrences are passed over",
+			date: time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+			now:  time.Date(2024, 1, 11, 10, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 13, 9, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := nextOccurrence(dailyReminder(tt.date), time.UTC, tt.now)
+			if err != nil || !ok {
+				t.Fatalf("nextOccurrence: %v, %v, %v", got, ok, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextOccurrence = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOccurrence(t *testing.T) {
+	reminder := dailyReminder(time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC))
+	tests := []struct {
+		wall time.Time
+		want bool
+	}{
+		{time.Date(2024, 1, 20, 9, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 1, 20, 9, 30, 0, 0, time.UTC), false},
+		{time.Date(2023, 12, 31, 9, 0, 0, 0, time.UTC), false}, // before the series
+		{time.Date(2024, 1, 12, 9, 0, 0, 0, time.UTC), false},  // already skipped
+	}
+	for _, tt := range tests {
+		got, err := isOccurrence(reminder, time.UTC, tt.wall)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("isOccurrence(%v) = %v, want %v", tt.wall, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Reasons a session was revoked
+const (
+	SessionLogout          = "logout"
+	SessionReuseDetected   = "reuse_detected"
+	SessionPasswordChanged = "password_changed"
+)
+
+const refreshTokenBytes = 32
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked
+	// refresh tokens, and for reused ones after their session is revoked
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrSessionRevoked is returned for access tokens of a revoked or expired
+	// session
+	ErrSessionRevoked = errors.New("session has been signed out")
+)
+
+// TokenPair is what a client holds for one session: a short-lived access
+// token for API calls and a refresh token to get the next pair
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	AccessExpiresAt  time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	SessionID        uuid.UUID `json:"session_id"`
+}
+
+// SessionService signs users in and out. Each sign-in starts a session whose
+// refresh token is rotated on every refresh; presenting a rotated token again
+// means it was copied, so the whole session is revoked.
+type SessionService struct {
+	db         *gorm.DB
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewSessionService(db *gorm.DB, accessTTL, refreshTTL time.Duration) *SessionService {
+	return &SessionService{
+		db:         db,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// StartSession signs the user in on a new device and returns its first
+// token pair. mfaVerified records that a second factor was checked too.
+func (s *SessionService) StartSession(user *database.User, ipAddress, userAgent string, mfaVerified bool) (*TokenPair, error) {
+	now := time.Now()
+	session := &database.AuthSession{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		IPAddress:  ipAddress,
+		UserAgent:  userAgent,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.refreshTTL),
+		CreatedAt:  now,
+	}
+	if mfaVerified {
+		session.MFAVerifiedAt = &now
+	}
+
+	var refreshToken string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, err = issueRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.tokenPair(user, session, refreshToken)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The old token
+// stops working; if it is presented again the session is revoked, signing
+// out both the legitimate client and whoever copied the token.
+func (s *SessionService) Refresh(refreshToken, ipAddress, userAgent string) (*TokenPair, error) {
+	var token database.RefreshToken
+	if err := s.db.Where("token_hash = ?", auth.HashToken(refreshToken)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if token.UsedAt != nil {
+		s.revokeReused(token.SessionID)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var session database.AuthSession
+	if err := s.db.First(&session, "id = ?", token.SessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if session.RevokedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	var user database.User
+	if err := s.db.First(&user, "id = ?", session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	var next string
+	reused := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Only one caller can use the token, even if two race
+		result := tx.Model(&database.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return ErrInvalidRefreshToken
+		}
+
+		session.ExpiresAt = now.Add(s.refreshTTL)
+		session.LastUsedAt = now
+		result = tx.Model(&database.AuthSession{}).
+			Where("id = ? AND revoked_at IS NULL", session.ID).
+			Updates(map[string]interface{}{
+				"expires_at":   session.ExpiresAt,
+				"last_used_at": now,
+				"ip_address":   ipAddress,
+				"user_agent":   userAgent,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefreshToken
+		}
+
+		var err error
+		next, err = issueRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if reused {
+		s.revokeReused(session.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.tokenPair(&user, &session, next)
+}
+
+// Logout revokes the session a refresh token belongs to. Unknown tokens are
+// ignored, so logging out twice is harmless.
+func (s *SessionService) Logout(refreshToken string) error {
+	var token database.RefreshToken
+	if err := s.db.Where("token_hash = ?", auth.HashToken(refreshToken)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.revoke(SessionLogout, "id = ?", token.SessionID)
+}
+
+// RevokeAll signs the user out everywhere, e.g. after a password change
+func (s *SessionService) RevokeAll(userID uuid.UUID, reason string) error {
+	return s.revoke(reason, "user_id = ?", userID)
+}
+
+// CheckSession returns the session, or ErrSessionRevoked unless it is one of
+// the user's and still signed in. AuthMiddleware calls it on every request.
+func (s *SessionService) CheckSession(userID, sessionID uuid.UUID) (*database.AuthSession, error) {
+	var session database.AuthSession
+	if err := s.db.Select("id", "user_id", "expires_at", "revoked_at", "mfa_verified_at").
+		First(&session, "id = ?", sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+	if session.UserID != userID || session.RevokedAt != nil || !time.Now().Before(session.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+	return &session, nil
+}
+
+// MarkMFAVerified records that the user checked a second factor in this
+// session, e.g. to step up before an action that requires one
+func (s *SessionService) MarkMFAVerified(sessionID uuid.UUID) error {
+	return s.db.Model(&database.AuthSession{}).
+		Where("id = ?", sessionID).
+		Update("mfa_verified_at", time.Now()).Error
+}
+
+// Start removes expired refresh tokens and sessions every interval
+func (s *SessionService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.Cleanup()
+		}
+	}()
+}
+
+// Cleanup deletes refresh tokens that have expired, and sessions that
+// expired or were revoked longer ago than an access token lives
+func (s *SessionService) Cleanup() {
+	now := time.Now()
+	if err := s.db.Where("expires_at < ?", now).Delete(&database.RefreshToken{}).Error; err != nil {
+		log.Printf("Failed to delete expired refresh tokens: %v", err)
+	}
+	cutoff := now.Add(-s.accessTTL)
+	if err := s.db.Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff).Delete(&database.AuthSession{}).Error; err != nil {
+		log.Printf("Failed to delete old sessions: %v", err)
+	}
+}
+
+// revoke marks the matching sessions revoked and drops their refresh tokens
+func (s *SessionService) revoke(reason string, where string, args ...interface{}) error {
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var ids []uuid.UUID
+		if err := tx.Model(&database.AuthSession{}).
+			Where(where, args...).
+			Where("revoked_at IS NULL").
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Model(&database.AuthSession{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"revoked_at":     now,
+				"revoked_reason": reason,
+			}).Error; err != nil {
+			return err
+		}
+		// Keep used tokens so a late replay is still recognised as reuse
+		return tx.Where("session_id IN ? AND used_at IS NULL", ids).Delete(&database.RefreshToken{}).Error
+	})
+}
+
+// revokeReused revokes a session whose refresh token was used twice
+func (s *SessionService) revokeReused(sessionID uuid.UUID) {
+	log.Printf("Refresh token reused in session %s; revoking it", sessionID)
+	if err := s.revoke(SessionReuseDetected, "id = ?", sessionID); err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID, err)
+	}
+}
+
+// tokenPair issues an access token to go with a new refresh token
+func (s *SessionService) tokenPair(user *database.User, session *database.AuthSession, refreshToken string) (*TokenPair, error) {
+	accessToken, accessExpiresAt, err := auth.GenerateToken(user.ID, session.ID, user.Email, user.Role, s.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		SessionID:        session.ID,
+	}, nil
+}
+
+// issueRefreshToken stores a new refresh token for the session and returns
+// it in plain text
+func issueRefreshToken(tx *gorm.DB, sessionID uuid.UUID, expiresAt time.Time) (string, error) {
+	token, err := auth.GenerateRandomToken(refreshTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Create(&database.RefreshToken{
+		ID:        uuid.New(),
+		SessionID: sessionID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"log"
+	"medical-records-app/internal/database"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxAnalyticsViewers caps the viewers listed in a share's analytics
+const maxAnalyticsViewers = 100
+
+// AccessCounts totals the audit log of one share or of all a user's shares
+type AccessCounts struct {
+	Views          int64      `json:"views"`
+	Previews       int64      `json:"previews"`
+	Downloads      int64      `json:"downloads"`      // files and packets
+	UniqueViewers  int64      `json:"unique_viewers"` // distinct IP address and user agent pairs
+	FirstViewedAt  *time.Time `json:"first_viewed_at"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+}
+
+// AccessDay is one day of the access timeline, in the owner's timezone
+type AccessDay struct {
+	Day       string `json:"day"` // YYYY-MM-DD
+	Views     int64  `json:"views"`
+	Previews  int64  `json:"previews"`
+	Downloads int64  `json:"downloads"`
+}
+
+// ShareViewer is one IP address and user agent that accessed a share
+type ShareViewer struct {
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Accesses  int64     `json:"accesses"`
+}
+
+// ShareAnalytics describes how one share link has been used
+type ShareAnalytics struct {
+	ShareID uuid.UUID `json:"share_id"`
+	AccessCounts
+	RemainingUses   *int          `json:"remaining_uses"`             // nil when unlimited
+	TimeToFirstView *int64        `json:"time_to_first_view_seconds"` // nil until first viewed
+	Timeline        []AccessDay   `json:"timeline"`
+	Viewers         []ShareViewer `json:"viewers"`
+}
+
+// ShareAccessSummary is one share's line in the overall analytics
+type ShareAccessSummary struct {
+	ShareID     uuid.UUID `json:"share_id"`
+	TokenPrefix string    `json:"token_prefix"`
+	RecordType  string    `json:"record_type"`
+	ShareMethod string    `json:"share_method"`
+	Available   bool      `json:"available"` // not revoked, expired or used up
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	AccessCounts
+	RemainingUses   *int   `json:"remaining_uses"`
+	TimeToFirstView *int64 `json:"time_to_first_view_seconds"`
+}
+
+// SharingAnalytics describes how all of a user's share links have been used
+type SharingAnalytics struct {
+	TotalShares     int64 `json:"total_shares"`
+	AvailableShares int64 `json:"available_shares"`
+	OpenedShares    int64 `json:"opened_shares"`
+	AccessCounts
+	AverageTimeToFirstView *int64               `json:"average_time_to_first_view_seconds"`
+	Timeline               []AccessDay          `json:"timeline"`
+	Shares                 []ShareAccessSummary `json:"shares"`
+}
+
+// GetShareAnalytics summarises the audit log of one of the user's shares. The
+// timeline covers the last days days.
+func (s *SharingService) GetShareAnalytics(userID, shareID uuid.UUID, days int) (*ShareAnalytics, error) {
+	sharedRecord, err := s.GetOwnShare(userID, shareID)
+	if err != nil {
+		return nil, err
+	}
+	logs := func() *gorm.DB {
+		return s.db.Model(&database.AuditLog{}).Where("shared_record_id = ?", shareID)
+	}
+
+	analytics := &ShareAnalytics{ShareID: shareID}
+	if err := selectAccessCounts(logs()).Scan(&analytics.AccessCounts).Error; err != nil {
+		return nil, err
+	}
+	analytics.RemainingUses = remainingUses(sharedRecord)
+	analytics.TimeToFirstView = timeToFirstView(sharedRecord, analytics.FirstViewedAt)
+
+	if analytics.Timeline, err = accessTimeline(logs(), userLocation(s.db, userID), days); err != nil {
+		return nil, err
+	}
+
+	analytics.Viewers = []ShareViewer{}
+	if err := logs().
+		Select("ip_address, user_agent, MIN(accessed_at) AS first_seen, MAX(accessed_at) AS last_seen, COUNT(*) AS accesses").
+		Group("ip_address, user_agent").
+		Order("last_seen DESC").
+		Limit(maxAnalyticsViewers).
+		Scan(&analytics.Viewers).Error; err != nil {
+		return nil, err
+	}
+	return analytics, nil
+}
+
+// GetSharingAnalytics summarises the audit logs of all the user's shares,
+// with a line per share, newest first. The timeline covers the last days days.
+func (s *SharingService) GetSharingAnalytics(userID uuid.UUID, days int) (*SharingAnalytics, error) {
+	var shares []database.SharedRecord
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	logs := func() *gorm.DB {
+		return s.db.Model(&database.AuditLog{}).
+			Where("shared_record_id IN (?)", s.db.Model(&database.SharedRecord{}).Select("id").Where("user_id = ?", userID))
+	}
+
+	analytics := &SharingAnalytics{TotalShares: int64(len(shares)), Shares: []ShareAccessSummary{}}
+	if err := selectAccessCounts(logs()).Scan(&analytics.AccessCounts).Error; err != nil {
+		return nil, err
+	}
+
+	var perShare []struct {
+		SharedRecordID uuid.UUID
+		AccessCounts
+	}
+	if err := selectAccessCounts(logs(), "shared_record_id").Group("shared_record_id").Scan(&perShare).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[uuid.UUID]AccessCounts, len(perShare))
+	for _, row := range perShare {
+		counts[row.SharedRecordID] = row.AccessCounts
+	}
+
+	var totalToFirstView int64
+	for i := range shares {
+		share := &shares[i]
+		summary := ShareAccessSummary{
+			ShareID:       share.ID,
+			TokenPrefix:   share.TokenPrefix,
+			RecordType:    share.RecordType,
+			ShareMethod:   share.ShareMethod,
+			Available:     shareUnavailable(share) == nil,
+			CreatedAt:     share.CreatedAt,
+			ExpiresAt:     share.ExpiresAt,
+			AccessCounts:  counts[share.ID],
+			RemainingUses: remainingUses(share),
+		}
+		summary.TimeToFirstView = timeToFirstView(share, summary.FirstViewedAt)
+		if summary.Available {
+			analytics.AvailableShares++
+		}
+		if summary.TimeToFirstView != nil {
+			analytics.OpenedShares++
+			totalToFirstView += *summary.TimeToFirstView
+		}
+		analytics.Shares = append(analytics.Shares, summary)
+	}
+	if analytics.OpenedShares > 0 {
+		average := totalToFirstView / analytics.OpenedShares
+		analytics.AverageTimeToFirstView = &average
+	}
+
+	var err error
+	if analytics.Timeline, err = accessTimeline(logs(), userLocation(s.db, userID), days); err != nil {
+		return nil, err
+	}
+	return analytics, nil
+}
+
+// selectAccessCounts selects AccessCounts columns from an audit log query,
+// after any extra columns to group by
+func selectAccessCounts(query *gorm.DB, columns ...string) *gorm.DB {
+	sel := "COUNT(*) FILTER (WHERE action = ?) AS views, " +
+		"COUNT(*) FILTER (WHERE action = ?) AS previews, " +
+		"COUNT(*) FILTER (WHERE action IN ?) AS downloads, " +
+		"COUNT(DISTINCT (ip_address, user_agent)) AS unique_viewers, " +
+		"MIN(accessed_at) FILTER (WHERE action = ?) AS first_viewed_at, " +
+		"MAX(accessed_at) AS last_accessed_at"
+	if len(columns) > 0 {
+		sel = strings.Join(columns, ", ") + ", " + sel
+	}
+	return query.Select(sel, AccessViewed, AccessPreviewed, []string{AccessDownloaded, AccessExported}, AccessViewed)
+}
+
+// accessTimeline counts accesses per local day over the last days days,
+// including days without any
+func accessTimeline(query *gorm.DB, loc *time.Location, days int) ([]AccessDay, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	var rows []AccessDay
+	if err := query.
+		Select("to_char(accessed_at AT TIME ZONE ?, 'YYYY-MM-DD') AS day, "+
+			"COUNT(*) FILTER (WHERE action = ?) AS views, "+
+			"COUNT(*) FILTER (WHERE action = ?) AS previews, "+
+			"COUNT(*) FILTER (WHERE action IN ?) AS downloads",
+			loc.String(), AccessViewed, AccessPreviewed, []string{AccessDownloaded, AccessExported}).
+		Where("accessed_at >= ?", since).
+		Group("day").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byDay := make(map[string]AccessDay, len(rows))
+	for _, row := range rows {
+		byDay[row.Day] = row
+	}
+
+	timeline := make([]AccessDay, 0, days)
+	for d := since; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		entry, ok := byDay[day]
+		if !ok {
+			entry = AccessDay{Day: day}
+		}
+		timeline = append(timeline, entry)
+	}
+	return timeline, nil
+}
+
+// remainingUses is how many more times a limited share can be opened
+func remainingUses(sharedRecord *database.SharedRecord) *int {
+	if sharedRecord.MaxAccessCount == 0 {
+		return nil
+	}
+	remaining := sharedRecord.MaxAccessCount - sharedRecord.CurrentAccessCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &remaining
+}
+
+// timeToFirstView is the number of seconds between creating a share and its
+// first view
+func timeToFirstView(sharedRecord *database.SharedRecord, firstViewedAt *time.Time) *int64 {
+	if firstViewedAt == nil {
+		return nil
+	}
+	seconds := int64(firstViewedAt.Sub(sharedRecord.CreatedAt).Seconds())
+	return &seconds
+}
+
+// logAccess writes an audit log entry for a share's file or packet. The
+// share's first download is marked in the same transaction so the owner is
+// only told about it once.
+func (s *SharingService) logAccess(entry *database.AuditLog) error {
+	downloaded := entry.Action == AccessDownloaded || entry.Action == AccessExported
+	var first bool
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		if !downloaded {
+			return nil
+		}
+		var err error
+		first, err = markFirst(tx, entry.SharedRecordID, "first_downloaded_at", entry.AccessedAt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if first {
+		go s.notifyOwnerOfAccess(entry.SharedRecordID, true, entry.IPAddress, entry.UserAgent, entry.AccessedAt)
+	}
+	return nil
+}
+
+// markFirst sets one of a share's first_* timestamps unless it is already
+// set, and reports whether this call set it
+func markFirst(tx *gorm.DB, sharedRecordID uuid.UUID, column string, at time.Time) (bool, error) {
+	result := tx.Model(&database.SharedRecord{}).
+		Where("id = ? AND "+column+" IS NULL", sharedRecordID).
+		Update(column, at)
+	return result.RowsAffected == 1, result.Error
+}
+
+// notifyOwnerOfAccess tells a share's owner that it was opened, or
+// downloaded from, for the first time, if they asked to be told. Errors are
+// only logged since the viewer's request has already been answered.
+func (s *SharingService) notifyOwnerOfAccess(sharedRecordID uuid.UUID, downloaded bool, ipAddress, userAgent string, at time.Time) {
+	var sharedRecord database.SharedRecord
+	if err := s.db.Preload("User").First(&sharedRecord, "id = ?", sharedRecordID).Error; err != nil {
+		log.Printf("Failed to load share %s for access notification: %v", sharedRecordID, err)
+		return
+	}
+	if !sharedRecord.NotifyOwnerOnAccess {
+		return
+	}
+
+	refs, err := s.shareRefs(&sharedRecord)
+	if err != nil {
+		log.Printf("Failed to load records of share %s: %v", sharedRecord.ID, err)
+		return
+	}
+	if len(userAgent) > 120 {
+		userAgent = userAgent[:120] + "..."
+	}
+	s.notifyUser(&sharedRecord.User, KindShareAccessed, sharedRecord.ID, map[string]interface{}{
+		"OwnerName":   ownerGreeting(&sharedRecord.User),
+		"Downloaded":  downloaded,
+		"SharedWith":  sharedWithLabel(&sharedRecord),
+		"RecordCount": len(refs),
+		"RecordLabel": recordLabel(sharedRecord.RecordType, len(refs)),
+		"TokenPrefix": sharedRecord.TokenPrefix,
+		"AccessedAt":  at.In(userLocation(s.db, sharedRecord.UserID)).Format("Jan 2, 2006 3:04 PM MST"),
+		"IPAddress":   ipAddress,
+		"UserAgent":   userAgent,
+		"SharingURL":  s.frontendURL + "/sharing",
+	})
+}
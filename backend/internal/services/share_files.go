@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/storage"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audit log actions for shares and their files
+const (
+	AccessViewed     = "viewed"
+	AccessPreviewed  = "previewed"
+	AccessDownloaded = "downloaded"
+)
+
+var (
+	ErrDownloadNotAllowed = errors.New("downloads are not allowed for this share link")
+	ErrNoAttachment       = errors.New("this record has no file")
+	// ErrShareViewRequired is returned for files of a link with a view limit
+	// when they're fetched without the session of a counted view
+	ErrShareViewRequired = errors.New("open the share link to view its files")
+)
+
+// GetShareForFiles looks up a share for serving its files. Files don't count
+// toward a view limit, so on links that have one they are only served with
+// the viewer session of a counted view. The view that used up a single-use
+// link can still show its files, but nobody can fetch them without using a
+// view.
+func (s *SharingService) GetShareForFiles(token, sessionToken string) (*database.SharedRecord, error) {
+	var sharedRecord database.SharedRecord
+	if err := s.db.Where("token_hash = ? AND share_method <> ?", auth.HashToken(token), ShareMethodUser).First(&sharedRecord).Error; err != nil {
+		return nil, err
+	}
+	switch ShareStatus(&sharedRecord) {
+	case ShareStatusActive:
+		if sharedRecord.MaxAccessCount > 0 && !viewedSession(&sharedRecord, sessionToken) {
+			return nil, ErrShareViewRequired
+		}
+	case ShareStatusExhausted:
+		if !viewedSession(&sharedRecord, sessionToken) {
+			return nil, shareUnavailable(&sharedRecord)
+		}
+	default:
+		return nil, shareUnavailable(&sharedRecord)
+	}
+	return &sharedRecord, nil
+}
+
+// OpenSharedFile opens the attachment of one record in the share. The record
+// must be part of the share and still belong to its owner, and the share's
+// redaction profile must not hide attachments. The caller must close the
+// returned body.
+func (s *SharingService) OpenSharedFile(ctx context.Context, sharedRecord *database.SharedRecord, recordType string, recordID uuid.UUID) (*storage.Object, error) {
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	shared := false
+	for _, ref := range refs {
+		if ref.ID == recordID && (ref.Type == recordType || ref.Type == "") {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		return nil, gorm.ErrRecordNotFound
+	}
+	redaction, err := decodeRedaction(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	var location string
+	switch recordType {
+	case RecordTypePrescription:
+		var prescription database.Prescription
+		if err := s.db.Where("id = ? AND user_id = ?", recordID, sharedRecord.UserID).First(&prescription).Error; err != nil {
+			return nil, err
+		}
+		redaction.apply(recordType, &prescription)
+		location = prescription.AttachmentURL
+	case RecordTypeLabReport:
+		var labReport database.LabReport
+		if err := s.db.Where("id = ? AND user_id = ?", recordID, sharedRecord.UserID).First(&labReport).Error; err != nil {
+			return nil, err
+		}
+		redaction.apply(recordType, &labReport)
+		location = labReport.ReportURL
+	default:
+		return nil, ErrNoAttachment
+	}
+	if location == "" {
+		return nil, ErrNoAttachment
+	}
+
+	return s.store.Open(ctx, location)
+}
+
+// LogFileAccess writes an audit log entry for a previewed or downloaded file.
+// File access doesn't count toward the share's view limit; GetShareForFiles
+// ties it to a counted view instead.
+func (s *SharingService) LogFileAccess(sharedRecordID uuid.UUID, ipAddress, userAgent, action, recordType string, recordID uuid.UUID, viewerID *uuid.UUID) error {
+	return s.logAccess(&database.AuditLog{
+		ID:             uuid.New(),
+		SharedRecordID: sharedRecordID,
+		IPAddress:      ipAddress,
+		UserAgent:      userAgent,
+		AccessedAt:     time.Now(),
+		Action:         action,
+		RecordType:     recordType,
+		RecordID:       &recordID,
+		ViewerUserID:   viewerID,
+	})
+}
+
+// SharedFileURL is the share-scoped link to a record's file. A viewer
+// session, if given, is carried in the link.
+func (s *SharingService) SharedFileURL(token, sessionToken, recordType string, recordID uuid.UUID) string {
+	fileURL := s.publicURL + "/api/v1/share/" + token + "/files/" + recordType + "/" + recordID.String()
+	if sessionToken != "" {
+		fileURL += "?session=" + url.QueryEscape(sessionToken)
+	}
+	return fileURL
+}
+
+// hideFileURLs replaces storage URLs in shared records with share-scoped
+// links from fileURL, so viewers never see where files are stored
+func hideFileURLs(records interface{}, fileURL func(recordType string, recordID uuid.UUID) string) {
+	switch v := records.(type) {
+	case []database.Prescription:
+		for i := range v {
+			if v[i].AttachmentURL != "" {
+				v[i].AttachmentURL = fileURL(RecordTypePrescription, v[i].ID)
+			}
+		}
+	case []database.LabReport:
+		for i := range v {
+			if v[i].ReportURL != "" {
+				v[i].ReportURL = fileURL(RecordTypeLabReport, v[i].ID)
+			}
+		}
+	case map[string]interface{}:
+		for _, group := range v {
+			hideFileURLs(group, fileURL)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"medical-records-app/internal/database"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ShareMethodUser shares records in-app with another registered account,
+// which opens them from its inbox instead of through a token link
+const ShareMethodUser = "user"
+
+// How the recipient of an in-app share answered it
+const (
+	RecipientPending  = "pending"
+	RecipientAccepted = "accepted"
+	RecipientDeclined = "declined"
+)
+
+var (
+	// ErrShareNotAccepted is returned when viewing an in-app share that hasn't
+	// been accepted
+	ErrShareNotAccepted = errors.New("accept this share before viewing it")
+	// ErrInvalidInboxStatus is returned for an unknown inbox filter
+	ErrInvalidInboxStatus = errors.New("status must be pending, accepted, declined or all")
+)
+
+// InboxShare is an in-app share as its recipient sees it. The owner's
+// settings for the share, such as its redaction profile, are left out.
+type InboxShare struct {
+	ID                 uuid.UUID  `json:"id"`
+	OwnerID            uuid.UUID  `json:"owner_id"`
+	OwnerName          string     `json:"owner_name"`
+	OwnerEmail         string     `json:"owner_email"`
+	RecordType         string     `json:"record_type"`
+	RecordCount        int        `json:"record_count"`
+	ShareMode          string     `json:"share_mode"`
+	ExpiresAt          time.Time  `json:"expires_at"`
+	MaxAccessCount     int        `json:"max_access_count"` // 0 = unlimited
+	CurrentAccessCount int        `json:"current_access_count"`
+	AllowDownload      bool       `json:"allow_download"`
+	Status             string     `json:"status"`           // active, expired, exhausted, revoked
+	RecipientStatus    string     `json:"recipient_status"` // pending, accepted, declined
+	RespondedAt        *time.Time `json:"responded_at,omitempty"`
+	SharedAt           time.Time  `json:"shared_at"`
+}
+
+// findRecipient looks up the account an in-app share goes to: the one that
+// has verified email. Without one it returns nil and no error, and the share
+// waits for the address to be verified, so creating a share doesn't reveal
+// whether an email has an account.
+func (s *SharingService) findRecipient(ownerID uuid.UUID, email string) (*database.User, error) {
+	var owner database.User
+	if err := s.db.First(&owner, "id = ?", ownerID).Error; err != nil {
+		return nil, err
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == strings.ToLower(owner.Email) {
+		return nil, errors.New("you can't share records with yourself")
+	}
+	var recipient database.User
+	if err := s.db.Where("LOWER(email) = ? AND is_email_verified = ?", email, true).First(&recipient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &recipient, nil
+}
+
+// claimWaitingShares gives the user the in-app shares sent to their email
+// before they had verified it
+func claimWaitingShares(db *gorm.DB, user *database.User) error {
+	return db.Model(&database.SharedRecord{}).
+		Where("share_method = ? AND recipient_user_id IS NULL AND LOWER(recipient_email) = ? AND user_id <> ?",
+			ShareMethodUser, strings.ToLower(user.Email), user.ID).
+		Updates(map[string]interface{}{
+			"recipient_user_id": user.ID,
+			"updated_at":        time.Now(),
+		}).Error
+}
+
+// GetInbox lists the in-app shares sent to the user, newest first,
+// optionally only those with the given answer (pending, accepted or
+// declined). Revoked and expired shares stay listed with their status.
+func (s *SharingService) GetInbox(userID uuid.UUID, status string) ([]InboxShare, error) {
+	query := s.db.Preload("User").
+		Where("recipient_user_id = ? AND share_method = ?", userID, ShareMethodUser)
+	switch status {
+	case "", "all":
+	case RecipientPending, RecipientAccepted, RecipientDeclined:
+		query = query.Where("recipient_status = ?", status)
+	default:
+		return nil, ErrInvalidInboxStatus
+	}
+
+	var sharedRecords []database.SharedRecord
+	if err := query.Order("created_at DESC").Find(&sharedRecords).Error; err != nil {
+		return nil, err
+	}
+	inbox := make([]InboxShare, 0, len(sharedRecords))
+	for i := range sharedRecords {
+		item, err := s.InboxShareOf(&sharedRecords[i])
+		if err != nil {
+			return nil, err
+		}
+		inbox = append(inbox, *item)
+	}
+	return inbox, nil
+}
+
+// GetInboxShare loads one of the in-app shares sent to the user, with its
+// owner
+func (s *SharingService) GetInboxShare(userID, shareID uuid.UUID) (*database.SharedRecord, error) {
+	var sharedRecord database.SharedRecord
+	if err := s.db.Preload("User").
+		Where("id = ? AND recipient_user_id = ? AND share_method = ?", shareID, userID, ShareMethodUser).
+		First(&sharedRecord).Error; err != nil {
+		return nil, err
+	}
+	return &sharedRecord, nil
+}
+
+// RespondToShare records whether the user accepts or declines an in-app
+// share sent to them. The answer can be changed while the share is still
+// available.
+func (s *SharingService) RespondToShare(userID, shareID uuid.UUID, accept bool) (*InboxShare, error) {
+	sharedRecord, err := s.GetInboxShare(userID, shareID)
+	if err != nil {
+		return nil, err
+	}
+	if err := shareUnavailable(sharedRecord); err != nil {
+		return nil, err
+	}
+
+	status := RecipientDeclined
+	if accept {
+		status = RecipientAccepted
+	}
+	now := time.Now()
+	if err := s.db.Model(sharedRecord).Updates(map[string]interface{}{
+		"recipient_status": status,
+		"responded_at":     now,
+		"updated_at":       now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	sharedRecord.RecipientStatus = status
+	sharedRecord.RespondedAt = &now
+	return s.InboxShareOf(sharedRecord)
+}
+
+// GetAcceptedShare loads an in-app share the user accepted, for viewing its
+// records and files. Like token links, a share that reached its view limit
+// only keeps its files open to the user for a while after their counted
+// view; RecordAccess enforces the limit for opening the share itself.
+func (s *SharingService) GetAcceptedShare(userID, shareID uuid.UUID) (*database.SharedRecord, error) {
+	sharedRecord, err := s.GetInboxShare(userID, shareID)
+	if err != nil {
+		return nil, err
+	}
+	switch ShareStatus(sharedRecord) {
+	case ShareStatusActive:
+	case ShareStatusExhausted:
+		viewed, err := s.viewedRecently(sharedRecord.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !viewed {
+			return nil, shareUnavailable(sharedRecord)
+		}
+	default:
+		return nil, shareUnavailable(sharedRecord)
+	}
+	if sharedRecord.RecipientStatus != RecipientAccepted {
+		return nil, ErrShareNotAccepted
+	}
+	return sharedRecord, nil
+}
+
+// viewedRecently reports whether the user had a view of the share counted
+// within a viewer session's lifetime
+func (s *SharingService) viewedRecently(sharedRecordID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.db.Model(&database.AuditLog{}).
+		Where("shared_record_id = ? AND viewer_user_id = ? AND action = ? AND accessed_at > ?",
+			sharedRecordID, userID, AccessViewed, time.Now().Add(-shareSessionTTL)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetInboxRecords loads an in-app share's records for its recipient, with
+// file URLs pointing at the inbox file routes
+func (s *SharingService) GetInboxRecords(sharedRecord *database.SharedRecord) (interface{}, error) {
+	return s.viewerRecords(sharedRecord, func(recordType string, recordID uuid.UUID) string {
+		return s.InboxFileURL(sharedRecord.ID, recordType, recordID)
+	})
+}
+
+// InboxFileURL is the recipient's link to a file in an in-app share
+func (s *SharingService) InboxFileURL(shareID uuid.UUID, recordType string, recordID uuid.UUID) string {
+	return s.publicURL + "/api/v1/sharing/inbox/" + shareID.String() + "/files/" + recordType + "/" + recordID.String()
+}
+
+// InboxShareOf builds the recipient's view of a share loaded with its owner
+func (s *SharingService) InboxShareOf(sharedRecord *database.SharedRecord) (*InboxShare, error) {
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	owner := &sharedRecord.User
+	return &InboxShare{
+		ID:                 sharedRecord.ID,
+		OwnerID:            sharedRecord.UserID,
+		OwnerName:          strings.TrimSpace(owner.FirstName + " " + owner.LastName),
+		OwnerEmail:         owner.Email,
+		RecordType:         sharedRecord.RecordType,
+		RecordCount:        len(refs),
+		ShareMode:          sharedRecord.ShareMode,
+		ExpiresAt:          sharedRecord.ExpiresAt,
+		MaxAccessCount:     sharedRecord.MaxAccessCount,
+		CurrentAccessCount: sharedRecord.CurrentAccessCount,
+		AllowDownload:      sharedRecord.AllowDownload,
+		Status:             ShareStatus(sharedRecord),
+		RecipientStatus:    sharedRecord.RecipientStatus,
+		RespondedAt:        sharedRecord.RespondedAt,
+		SharedAt:           sharedRecord.CreatedAt,
+	}, nil
+}
+
+// notifyRecipientOfShare tells the recipient of a new in-app share that it
+// is waiting in their inbox
+func (s *SharingService) notifyRecipientOfShare(sharedRecord *database.SharedRecord, recipient *database.User) {
+	var owner database.User
+	if err := s.db.First(&owner, "id = ?", sharedRecord.UserID).Error; err != nil {
+		log.Printf("Failed to load owner of share %s: %v", sharedRecord.ID, err)
+		return
+	}
+	senderName := strings.TrimSpace(owner.FirstName + " " + owner.LastName)
+	if senderName == "" {
+		senderName = owner.Email
+	}
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		log.Printf("Failed to load records of share %s: %v", sharedRecord.ID, err)
+		return
+	}
+
+	s.notifyUser(recipient, KindShareReceived, sharedRecord.ID, map[string]interface{}{
+		"RecipientName": ownerGreeting(recipient),
+		"SenderName":    senderName,
+		"RecordCount":   len(refs),
+		"RecordLabel":   recordLabel(sharedRecord.RecordType, len(refs)),
+		"ExpiresAt":     sharedRecord.ExpiresAt.In(userLocation(s.db, recipient.ID)).Format("Jan 2, 2006 3:04 PM MST"),
+		"InboxURL":      s.frontendURL + "/sharing/inbox",
+	})
+}
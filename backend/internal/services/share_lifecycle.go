@@ -0,0 +1,308 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Share statuses, as listed and filtered on /sharing/my-shares
+const (
+	ShareStatusActive    = "active"
+	ShareStatusExpired   = "expired"
+	ShareStatusExhausted = "exhausted" // reached its maximum number of views
+	ShareStatusRevoked   = "revoked"
+)
+
+// Reasons a share was deactivated
+const (
+	DeactivatedRevoked   = "revoked"
+	DeactivatedExpired   = "expired"
+	DeactivatedExhausted = "exhausted"
+	DeactivatedLocked    = "locked" // too many wrong PINs or codes
+)
+
+// maxShareLifetime caps how far ahead a share's expiry can be moved
+const maxShareLifetime = 365 * 24 * time.Hour
+
+// sweepBatchSize caps how many shares one sweep deactivates
+const sweepBatchSize = 500
+
+var (
+	// ErrShareRevoked is returned when editing a revoked share
+	ErrShareRevoked = errors.New("share link has been revoked; create a new one instead")
+	// ErrInvalidShareStatus is returned for an unknown status filter
+	ErrInvalidShareStatus = errors.New("status must be active, expired, exhausted, revoked or all")
+)
+
+// ShareStatus says whether a share can still be opened and, if not, why
+func ShareStatus(sharedRecord *database.SharedRecord) string {
+	if !sharedRecord.IsActive {
+		switch sharedRecord.DeactivationReason {
+		case DeactivatedExpired:
+			return ShareStatusExpired
+		case DeactivatedExhausted:
+			return ShareStatusExhausted
+		}
+		return ShareStatusRevoked
+	}
+	switch {
+	case !time.Now().Before(sharedRecord.ExpiresAt):
+		return ShareStatusExpired
+	case sharedRecord.MaxAccessCount > 0 && sharedRecord.CurrentAccessCount >= sharedRecord.MaxAccessCount:
+		return ShareStatusExhausted
+	}
+	return ShareStatusActive
+}
+
+// shareStatusScope filters shares by status, matching ShareStatus whether or
+// not the sweeper has deactivated them yet
+func shareStatusScope(status string, now time.Time) (func(*gorm.DB) *gorm.DB, error) {
+	exhausted := "max_access_count > 0 AND current_access_count >= max_access_count"
+	var where string
+	var args []interface{}
+	switch status {
+	case "", "all":
+		return func(db *gorm.DB) *gorm.DB { return db }, nil
+	case ShareStatusActive:
+		where = "is_active AND expires_at > ? AND NOT (" + exhausted + ")"
+		args = []interface{}{now}
+	case ShareStatusExpired:
+		where = "(NOT is_active AND deactivation_reason = ?) OR (is_active AND expires_at <= ?)"
+		args = []interface{}{DeactivatedExpired, now}
+	case ShareStatusExhausted:
+		where = "(NOT is_active AND deactivation_reason = ?) OR (is_active AND expires_at > ? AND " + exhausted + ")"
+		args = []interface{}{DeactivatedExhausted, now}
+	case ShareStatusRevoked:
+		where = "NOT is_active AND (deactivation_reason IS NULL OR deactivation_reason NOT IN ?)"
+		args = []interface{}{[]string{DeactivatedExpired, DeactivatedExhausted}}
+	default:
+		return nil, ErrInvalidShareStatus
+	}
+	return func(db *gorm.DB) *gorm.DB { return db.Where(where, args...) }, nil
+}
+
+// UpdateShareRequest is a partial update of a share; nil fields are left
+// unchanged
+type UpdateShareRequest struct {
+	ExpiresAt      *time.Time `json:"expires_at"`      // new expiry
+	ExtendByHours  *int       `json:"extend_by_hours"` // or move the expiry later, counting from now if it has passed
+	MaxAccessCount *int       `json:"max_access_count"`
+	AllowDownload  *bool      `json:"allow_download"`
+}
+
+// UpdateShareLink changes the expiry, access limit or download permission of
+// one of the user's shares. A link the sweeper deactivated because it expired
+// or was used up becomes active again if the change brings it back within
+// its limits; revoked links can't be edited.
+func (s *SharingService) UpdateShareLink(userID, shareID uuid.UUID, req UpdateShareRequest) (*database.SharedRecord, error) {
+	sharedRecord, err := s.GetOwnShare(userID, shareID)
+	if err != nil {
+		return nil, err
+	}
+	if ShareStatus(sharedRecord) == ShareStatusRevoked {
+		return nil, ErrShareRevoked
+	}
+
+	now := time.Now()
+	if req.ExpiresAt != nil && req.ExtendByHours != nil {
+		return nil, errors.New("use either expires_at or extend_by_hours, not both")
+	}
+	if req.ExtendByHours != nil {
+		if *req.ExtendByHours <= 0 {
+			return nil, errors.New("extend_by_hours must be positive")
+		}
+		from := sharedRecord.ExpiresAt
+		if from.Before(now) {
+			from = now
+		}
+		expiresAt := from.Add(time.Duration(*req.ExtendByHours) * time.Hour)
+		req.ExpiresAt = &expiresAt
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+		if req.ExpiresAt.After(now.Add(maxShareLifetime)) {
+			return nil, errors.New("share links can't be extended more than a year ahead")
+		}
+		sharedRecord.ExpiresAt = *req.ExpiresAt
+	}
+	if req.MaxAccessCount != nil {
+		if *req.MaxAccessCount < 0 {
+			return nil, errors.New("max_access_count can't be negative")
+		}
+		sharedRecord.MaxAccessCount = *req.MaxAccessCount
+	}
+	if req.AllowDownload != nil {
+		sharedRecord.AllowDownload = *req.AllowDownload
+	}
+
+	updates := map[string]interface{}{
+		"expires_at":       sharedRecord.ExpiresAt,
+		"max_access_count": sharedRecord.MaxAccessCount,
+		"allow_download":   sharedRecord.AllowDownload,
+		"updated_at":       now,
+	}
+	if !sharedRecord.IsActive {
+		// Deactivated by the sweeper; reactivate if the edit fixed the cause
+		sharedRecord.IsActive = true
+		if ShareStatus(sharedRecord) == ShareStatusActive {
+			updates["is_active"] = true
+			updates["deactivated_at"] = nil
+			updates["deactivation_reason"] = ""
+		}
+	}
+
+	// Don't undo a revoke that happened since the share was loaded
+	result := s.db.Model(&database.SharedRecord{}).
+		Where("id = ? AND (is_active OR deactivation_reason IN ?)", sharedRecord.ID, []string{DeactivatedExpired, DeactivatedExhausted}).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrShareRevoked
+	}
+	return s.GetOwnShare(userID, shareID)
+}
+
+// Start runs the share sweeper every interval
+func (s *SharingService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.SweepShares()
+		}
+	}()
+}
+
+// SweepShares deactivates links that have expired or reached their maximum
+// number of views, and tells their owners
+func (s *SharingService) SweepShares() {
+	now := time.Now()
+	var shares []database.SharedRecord
+	if err := s.db.Preload("User").
+		Where("is_active = ?", true).
+		Where("expires_at <= ? OR (max_access_count > 0 AND current_access_count >= max_access_count)", now).
+		Limit(sweepBatchSize).
+		Find(&shares).Error; err != nil {
+		log.Printf("Failed to load shares to sweep: %v", err)
+		return
+	}
+
+	for i := range shares {
+		share := &shares[i]
+		reason := DeactivatedExhausted
+		if !now.Before(share.ExpiresAt) {
+			reason = DeactivatedExpired
+		}
+
+		// Only deactivate if still due, so an extension made meanwhile wins
+		result := s.db.Model(&database.SharedRecord{}).
+			Where("id = ? AND is_active = ?", share.ID, true).
+			Where("expires_at <= ? OR (max_access_count > 0 AND current_access_count >= max_access_count)", now).
+			Updates(map[string]interface{}{
+				"is_active":           false,
+				"deactivated_at":      now,
+				"deactivation_reason": reason,
+				"updated_at":          now,
+			})
+		if result.Error != nil {
+			log.Printf("Failed to deactivate share %s: %v", share.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		s.notifyOwnerOfDeactivation(share, reason)
+	}
+}
+
+// notifyOwnerOfDeactivation tells an owner their link was deactivated by the
+// sweeper, or after too many wrong PINs or codes
+func (s *SharingService) notifyOwnerOfDeactivation(sharedRecord *database.SharedRecord, reason string) {
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		log.Printf("Failed to load records of share %s: %v", sharedRecord.ID, err)
+		return
+	}
+	s.notifyUser(&sharedRecord.User, KindShareDeactivated, sharedRecord.ID, map[string]interface{}{
+		"OwnerName":   ownerGreeting(&sharedRecord.User),
+		"Expired":     reason == DeactivatedExpired,
+		"Locked":      reason == DeactivatedLocked,
+		"SharedWith":  sharedWithLabel(sharedRecord),
+		"RecordCount": len(refs),
+		"RecordLabel": recordLabel(sharedRecord.RecordType, len(refs)),
+		"TokenPrefix": sharedRecord.TokenPrefix,
+		"Views":       sharedRecord.CurrentAccessCount,
+		"SharingURL":  s.frontendURL + "/sharing",
+	})
+}
+
+// notifyUser renders a notification about a share for its owner or its
+// in-app recipient and sends it on each channel they chose for kind,
+// queueing it instead for digest users. Errors are only logged.
+func (s *SharingService) notifyUser(user *database.User, kind string, sharedRecordID uuid.UUID, data map[string]interface{}) {
+	prefs, err := loadPreferences(s.db, user.ID)
+	if err != nil {
+		log.Printf("Failed to load preferences of user %s: %v", user.ID, err)
+		return
+	}
+
+	for _, channel := range channelsOf(prefs)[kind] {
+		recipient := userRecipient(user, channel)
+		if recipient == "" {
+			continue
+		}
+		msg, err := notify.Render(kind, channel, data)
+		if err != nil {
+			log.Printf("Failed to render %s %s notification for share %s: %v", kind, channel, sharedRecordID, err)
+			continue
+		}
+
+		req := NotificationRequest{
+			UserID:      &user.ID,
+			Channel:     channel,
+			Recipient:   recipient,
+			Subject:     msg.Subject,
+			Body:        msg.Body,
+			Kind:        kind,
+			RelatedType: "shared_record",
+			RelatedID:   &sharedRecordID,
+		}
+		if prefs.DeliveryMode == DeliveryDigest {
+			_, err = s.notificationService.Queue(req)
+		} else {
+			_, err = s.notificationService.Send(req)
+		}
+		if err != nil {
+			log.Printf("Failed to send %s %s notification for share %s: %v", kind, channel, sharedRecordID, err)
+		}
+	}
+}
+
+// sharedWithLabel describes who a share went to, for owner notifications
+func sharedWithLabel(sharedRecord *database.SharedRecord) string {
+	if sharedRecord.ShareMethod == ShareMethodUser {
+		return "with " + sharedRecord.RecipientEmail
+	}
+	if _, recipient := shareRecipient(sharedRecord); recipient != "" {
+		return "with " + recipient
+	}
+	return "as a link"
+}
+
+// ownerGreeting is the name share notifications address a user by
+func ownerGreeting(owner *database.User) string {
+	if owner.FirstName != "" {
+		return owner.FirstName
+	}
+	return "there"
+}
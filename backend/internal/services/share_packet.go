@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/packet"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Packet formats
+const (
+	PacketPDF = "pdf"
+	PacketZIP = "zip"
+)
+
+// AccessExported is the audit log action for a downloaded packet
+const AccessExported = "exported"
+
+// GetOwnShare returns one of the user's shares
+func (s *SharingService) GetOwnShare(userID, shareID uuid.UUID) (*database.SharedRecord, error) {
+	var sharedRecord database.SharedRecord
+	if err := s.db.Where("id = ? AND user_id = ?", shareID, userID).First(&sharedRecord).Error; err != nil {
+		return nil, err
+	}
+	return &sharedRecord, nil
+}
+
+// BuildPacket gathers a share's records and attachments into a packet
+// document. Attachments are only fetched when the packet is written, using
+// ctx.
+func (s *SharingService) BuildPacket(ctx context.Context, sharedRecord *database.SharedRecord) (*packet.Document, error) {
+	var owner database.User
+	if err := s.db.First(&owner, "id = ?", sharedRecord.UserID).Error; err != nil {
+		return nil, err
+	}
+	loc := userLocation(s.db, owner.ID)
+
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	redaction, err := decodeRedaction(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.GetRecordsByIDs(owner.ID, sharedRecord.RecordType, refs, redaction)
+	if err != nil {
+		return nil, err
+	}
+
+	patient := strings.TrimSpace(owner.FirstName + " " + owner.LastName)
+	if patient == "" {
+		patient = owner.Email
+	}
+	doc := &packet.Document{
+		Title:       "Medical records for " + patient,
+		Patient:     patient,
+		GeneratedAt: time.Now().In(loc),
+		ExpiresAt:   sharedRecord.ExpiresAt.In(loc),
+		Withheld:    redaction.describe(),
+	}
+	if owner.DateOfBirth != nil {
+		doc.DateOfBirth = owner.DateOfBirth.Format("Jan 2, 2006")
+	}
+
+	addAttachment := func(recordType string, recordID uuid.UUID, fileType, location, describes string) {
+		if location == "" {
+			return
+		}
+		ext := strings.ToLower(strings.TrimPrefix(fileType, "."))
+		if ext == "" {
+			ext = strings.TrimPrefix(path.Ext(location), ".")
+		}
+		name := recordType + "-" + recordID.String()
+		if ext != "" {
+			name += "." + ext
+		}
+		doc.Attachments = append(doc.Attachments, packet.Attachment{
+			Name: name,
+			For:  describes,
+			Open: func() (io.ReadCloser, string, error) {
+				file, err := s.store.Open(ctx, location)
+				if err != nil {
+					return nil, "", err
+				}
+				return file.Body, file.ContentType, nil
+			},
+		})
+	}
+
+	prescriptions, appointments, labReports, insurance := splitRecords(records)
+	include := func(recordType string) bool {
+		return sharedRecord.RecordType == RecordTypeBundle || sharedRecord.RecordType == recordType
+	}
+
+	if include(RecordTypePrescription) {
+		section := packet.Section{Title: "Prescriptions"}
+		for _, p := range prescriptions {
+			section.Items = append(section.Items, packet.Item{
+				Heading: choose(p.MedicineName != "", p.MedicineName, "Prescription"),
+				Date:    formatDate(p.PrescriptionDate.Time),
+				Fields: []packet.Field{
+					{Label: "Dosage", Value: p.Dosage},
+					{Label: "Instructions", Value: p.Instructions},
+					{Label: "Prescribed by", Value: p.PrescribingDoctor},
+					{Label: "Specialty", Value: p.DoctorSpecialty},
+					{Label: "Hospital", Value: p.Hospital},
+					{Label: "Status", Value: choose(p.IsActive, "Active", "Inactive")},
+				},
+			})
+			addAttachment(RecordTypePrescription, p.ID, p.AttachmentType, p.AttachmentURL, "prescription for "+p.MedicineName)
+		}
+		doc.Sections = append(doc.Sections, section)
+		doc.RecordTypes = append(doc.RecordTypes, "Prescriptions")
+	}
+	if include(RecordTypeAppointment) {
+		section := packet.Section{Title: "Appointments"}
+		for _, a := range appointments {
+			section.Items = append(section.Items, packet.Item{
+				Heading: choose(a.DoctorName != "", a.DoctorName, "Appointment"),
+				Date:    formatDateTime(a.AppointmentDate.Time),
+				Fields: []packet.Field{
+					{Label: "Specialty", Value: a.Specialty},
+					{Label: "Hospital", Value: a.Hospital},
+					{Label: "Location", Value: a.Location},
+					{Label: "Notes", Value: a.Notes},
+					{Label: "Status", Value: choose(a.IsCompleted, "Completed", "Scheduled")},
+				},
+			})
+		}
+		doc.Sections = append(doc.Sections, section)
+		doc.RecordTypes = append(doc.RecordTypes, "Appointments")
+	}
+	if include(RecordTypeLabReport) {
+		section := packet.Section{Title: "Lab reports"}
+		for _, r := range labReports {
+			section.Items = append(section.Items, packet.Item{
+				Heading: choose(r.TestType != "", r.TestType, "Lab report"),
+				Date:    formatDate(r.TestDate.Time),
+				Fields: []packet.Field{
+					{Label: "Lab", Value: r.LabName},
+					{Label: "Notes", Value: r.Notes},
+				},
+			})
+			addAttachment(RecordTypeLabReport, r.ID, r.ReportType, r.ReportURL, r.TestType+" lab report")
+		}
+		doc.Sections = append(doc.Sections, section)
+		doc.RecordTypes = append(doc.RecordTypes, "Lab reports")
+	}
+	if include(RecordTypeInsurance) {
+		section := packet.Section{Title: "Insurance"}
+		for _, i := range insurance {
+			var dates []string
+			if i.EffectiveDate != nil {
+				dates = append(dates, "from "+formatDate(i.EffectiveDate.Time))
+			}
+			if i.ExpirationDate != nil {
+				dates = append(dates, "until "+formatDate(i.ExpirationDate.Time))
+			}
+			section.Items = append(section.Items, packet.Item{
+				Heading: choose(i.InsuranceProvider != "", i.InsuranceProvider, "Insurance policy"),
+				Date:    strings.Join(dates, " "),
+				Fields: []packet.Field{
+					{Label: "Policy number", Value: i.PolicyNumber},
+					{Label: "Group number", Value: i.GroupNumber},
+					{Label: "Member ID", Value: i.MemberID},
+					{Label: "Notes", Value: i.Notes},
+				},
+			})
+		}
+		doc.Sections = append(doc.Sections, section)
+		doc.RecordTypes = append(doc.RecordTypes, "Insurance")
+	}
+
+	return doc, nil
+}
+
+// WritePacket renders the document in the given format
+func WritePacket(w io.Writer, doc *packet.Document, format string) error {
+	switch format {
+	case PacketPDF:
+		return packet.WritePDF(w, doc)
+	case PacketZIP:
+		return packet.WriteZIP(w, doc)
+	}
+	return fmt.Errorf("unknown packet format %q", format)
+}
+
+// splitRecords unpacks what GetRecordsByIDs returned
+func splitRecords(records interface{}) ([]database.Prescription, []database.Appointment, []database.LabReport, []database.HealthInsurance) {
+	switch v := records.(type) {
+	case []database.Prescription:
+		return v, nil, nil, nil
+	case []database.Appointment:
+		return nil, v, nil, nil
+	case []database.LabReport:
+		return nil, nil, v, nil
+	case []database.HealthInsurance:
+		return nil, nil, nil, v
+	case map[string]interface{}:
+		prescriptions, _ := v[bundleKeys[RecordTypePrescription]].([]database.Prescription)
+		appointments, _ := v[bundleKeys[RecordTypeAppointment]].([]database.Appointment)
+		labReports, _ := v[bundleKeys[RecordTypeLabReport]].([]database.LabReport)
+		insurance, _ := v[bundleKeys[RecordTypeInsurance]].([]database.HealthInsurance)
+		return prescriptions, appointments, labReports, insurance
+	}
+	return nil, nil, nil, nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("Jan 2, 2006")
+}
+
+func formatDateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("Jan 2, 2006 3:04 PM")
+}
+
+func choose(cond bool, yes, no string) string {
+	if cond {
+		return yes
+	}
+	return no
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Share protection modes
+const (
+	ShareProtectionNone = "none"
+	ShareProtectionPIN  = "pin"
+	ShareProtectionOTP  = "otp"
+)
+
+// KindShareCode is the notification kind for share access codes
+const KindShareCode = "share_code"
+
+const (
+	maxShareVerifyAttempts = 5
+	shareLockout           = 15 * time.Minute // the first lockout; each one after is twice as long
+	maxShareLockouts       = 5                // the link is disabled instead of locked a sixth time
+	minSharePINLength      = 6
+	shareCodeTTL           = 10 * time.Minute
+	shareSessionTTL        = 30 * time.Minute
+)
+
+var (
+	ErrShareVerificationRequired = errors.New("this share link requires verification")
+	ErrShareLocked               = errors.New("too many failed attempts; try again later")
+)
+
+// shareProtection validates the protection options and returns the PIN hash
+// to store
+func shareProtection(opts *ShareLinkOptions) (string, error) {
+	if opts.Protection == "" {
+		opts.Protection = ShareProtectionNone
+	}
+	switch opts.Protection {
+	case ShareProtectionNone:
+		return "", nil
+	case ShareProtectionPIN:
+		if len(opts.PIN) < minSharePINLength || len(opts.PIN) > 12 || strings.Trim(opts.PIN, "0123456789") != "" {
+			return "", fmt.Errorf("pin must be %d to 12 digits", minSharePINLength)
+		}
+		return auth.HashPassword(opts.PIN)
+	case ShareProtectionOTP:
+		if opts.RecipientEmail == "" && opts.RecipientPhone == "" {
+			return "", errors.New("otp protection needs a recipient_email or recipient_phone to send codes to")
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("invalid protection %q, expected none, pin or otp", opts.Protection)
+}
+
+// SendAccessCode sends a one-time code for an OTP-protected share to its
+// recipient and returns the channel and a masked address
+func (s *SharingService) SendAccessCode(sharedRecord *database.SharedRecord) (string, string, error) {
+	if sharedRecord.Protection != ShareProtectionOTP {
+		return "", "", errors.New("this share link does not use one-time codes")
+	}
+	if shareLocked(sharedRecord) {
+		return "", "", ErrShareLocked
+	}
+
+	channel, recipient := codeRecipient(sharedRecord)
+	code, err := s.codeService.Issue(CodePurposeShareAccess, sharedRecord.ID, recipient, shareCodeTTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	msg, err := notify.Render(KindShareCode, channel, map[string]interface{}{
+		"Code":    code,
+		"Minutes": int(shareCodeTTL / time.Minute),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	userID := sharedRecord.UserID
+	if _, err := s.notificationService.Send(NotificationRequest{
+		UserID:      &userID,
+		Channel:     channel,
+		Recipient:   recipient,
+		Subject:     msg.Subject,
+		Body:        msg.Body,
+		Kind:        KindShareCode,
+		RelatedType: "shared_record",
+		RelatedID:   &sharedRecord.ID,
+		Secrets:     []string{code}, // the owner can read the log, and must not read the code
+	}); err != nil {
+		return "", "", err
+	}
+	return channel, maskRecipient(channel, recipient), nil
+}
+
+// VerifyShareAccess checks a PIN or one-time code and returns a viewer
+// session token. Repeated failures lock the share for a while, and too many
+// lockouts disable it.
+func (s *SharingService) VerifyShareAccess(sharedRecord *database.SharedRecord, code string) (string, time.Time, error) {
+	if sharedRecord.Protection == ShareProtectionNone || sharedRecord.Protection == "" {
+		return "", time.Time{}, errors.New("this share link is not protected")
+	}
+	if shareLocked(sharedRecord) {
+		return "", time.Time{}, ErrShareLocked
+	}
+
+	var valid bool
+	switch sharedRecord.Protection {
+	case ShareProtectionPIN:
+		valid = code != "" && auth.CheckPasswordHash(code, sharedRecord.PINHash)
+	case ShareProtectionOTP:
+		_, err := s.codeService.Verify(CodePurposeShareAccess, sharedRecord.ID, code)
+		if err != nil && !errors.Is(err, ErrInvalidCode) {
+			return "", time.Time{}, err
+		}
+		valid = err == nil
+	}
+
+	if !valid {
+		return "", time.Time{}, s.recordFailedVerification(sharedRecord)
+	}
+
+	if err := s.db.Model(&database.SharedRecord{}).
+		Where("id = ?", sharedRecord.ID).
+		Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return auth.GenerateShareSessionToken(sharedRecord.ID, false, shareSessionTTL)
+}
+
+// IssueViewSession returns a viewer session for a view RecordAccess has just
+// counted. It also unlocks a protected share, and keeps the share's files
+// open for a while after the view used up its limit.
+func (s *SharingService) IssueViewSession(sharedRecord *database.SharedRecord) (string, time.Time, error) {
+	return auth.GenerateShareSessionToken(sharedRecord.ID, true, shareSessionTTL)
+}
+
+// viewedSession reports whether sessionToken was issued by a counted view of
+// the share
+func viewedSession(sharedRecord *database.SharedRecord, sessionToken string) bool {
+	if sessionToken == "" {
+		return false
+	}
+	claims, err := auth.ValidateShareSessionToken(sessionToken)
+	return err == nil && claims.ShareID == sharedRecord.ID && claims.Viewed
+}
+
+// CheckViewerSession lets unprotected shares through and requires a valid
+// session token for this share otherwise
+func (s *SharingService) CheckViewerSession(sharedRecord *database.SharedRecord, sessionToken string) error {
+	if sharedRecord.Protection == ShareProtectionNone || sharedRecord.Protection == "" {
+		return nil
+	}
+	if sessionToken == "" {
+		return ErrShareVerificationRequired
+	}
+	claims, err := auth.ValidateShareSessionToken(sessionToken)
+	if err != nil || claims.ShareID != sharedRecord.ID {
+		return ErrShareVerificationRequired
+	}
+	return nil
+}
+
+// recordFailedVerification counts a wrong PIN or code and locks the share
+// once the limit is reached. Each lockout lasts twice as long as the one
+// before, and after maxShareLockouts the share is disabled and its owner
+// told, so a PIN can't be worked through a few guesses at a time. Lockouts
+// aren't forgiven by a right PIN, since whoever is guessing may not be the
+// one who knows it.
+func (s *SharingService) recordFailedVerification(sharedRecord *database.SharedRecord) error {
+	if err := s.db.Model(&database.SharedRecord{}).
+		Where("id = ?", sharedRecord.ID).
+		Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error; err != nil {
+		return err
+	}
+	if err := s.db.First(sharedRecord, "id = ?", sharedRecord.ID).Error; err != nil {
+		return err
+	}
+	if sharedRecord.FailedAttempts < maxShareVerifyAttempts {
+		return ErrInvalidCode
+	}
+
+	if sharedRecord.Lockouts >= maxShareLockouts {
+		return s.disableGuessedShare(sharedRecord)
+	}
+
+	lockedUntil := time.Now().Add(shareLockout << sharedRecord.Lockouts)
+	sharedRecord.FailedAttempts = 0
+	sharedRecord.Lockouts++
+	sharedRecord.LockedUntil = &lockedUntil
+	if err := s.db.Model(&database.SharedRecord{}).
+		Where("id = ?", sharedRecord.ID).
+		Updates(map[string]interface{}{
+			"failed_attempts": 0,
+			"lockouts":        gorm.Expr("lockouts + 1"),
+			"locked_until":    lockedUntil,
+		}).Error; err != nil {
+		return err
+	}
+	return ErrShareLocked
+}
+
+// disableGuessedShare deactivates a share whose PIN or codes kept being
+// guessed wrong and tells its owner
+func (s *SharingService) disableGuessedShare(sharedRecord *database.SharedRecord) error {
+	now := time.Now()
+	result := s.db.Model(&database.SharedRecord{}).
+		Where("id = ? AND is_active = ?", sharedRecord.ID, true).
+		Updates(map[string]interface{}{
+			"is_active":           false,
+			"deactivated_at":      now,
+			"deactivation_reason": DeactivatedLocked,
+			"updated_at":          now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if err := s.db.Preload("User").First(sharedRecord, "id = ?", sharedRecord.ID).Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 1 {
+		s.notifyOwnerOfDeactivation(sharedRecord, DeactivatedLocked)
+	}
+	return shareUnavailable(sharedRecord)
+}
+
+func shareLocked(sharedRecord *database.SharedRecord) bool {
+	return sharedRecord.LockedUntil != nil && time.Now().Before(*sharedRecord.LockedUntil)
+}
+
+// codeRecipient picks where access codes go: the share's own channel when it
+// was sent by SMS, otherwise email if known
+func codeRecipient(sharedRecord *database.SharedRecord) (string, string) {
+	if sharedRecord.ShareMethod == "sms" || sharedRecord.RecipientEmail == "" {
+		return notify.ChannelSMS, sharedRecord.RecipientPhone
+	}
+	return notify.ChannelEmail, sharedRecord.RecipientEmail
+}
+
+// maskRecipient hides most of an address, e.g. j***@example.com or ***1234
+func maskRecipient(channel, recipient string) string {
+	if channel == notify.ChannelEmail {
+		at := strings.LastIndex(recipient, "@")
+		if at < 1 {
+			return "***"
+		}
+		return recipient[:1] + "***" + recipient[at:]
+	}
+	if len(recipient) <= 4 {
+		return "***"
+	}
+	return "***" + recipient[len(recipient)-4:]
+}
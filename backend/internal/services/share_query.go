@@ -0,0 +1,159 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"medical-records-app/internal/database"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Share modes
+const (
+	ShareModeStatic = "static" // the records chosen when the share was created
+	ShareModeQuery  = "query"  // the owner's records matching a ShareQuery when viewed
+)
+
+// maxQueryShareRecords caps how many records of each type a query share
+// shows, newest first
+const maxQueryShareRecords = 500
+
+// queryableTypes are the record types a query share can match, and the date
+// each is filtered on
+var queryableTypes = map[string]string{
+	RecordTypePrescription: "prescription_date",
+	RecordTypeAppointment:  "appointment_date",
+	RecordTypeLabReport:    "test_date",
+}
+
+// ShareQuery selects the records of a query share. It is evaluated against
+// the owner's records each time the share is viewed, so records added later
+// are included while they match.
+type ShareQuery struct {
+	RecordTypes []string       `json:"record_types"`        // prescription, appointment, lab_report; all of them when empty
+	From        *database.Date `json:"from,omitempty"`      // first date included
+	To          *database.Date `json:"to,omitempty"`        // last date included
+	LastDays    int            `json:"last_days,omitempty"` // rolling window ending on the day of viewing, instead of from/to
+	Tags        database.Tags  `json:"tags,omitempty"`      // records with any of these tags
+}
+
+// normalize fills in defaults and checks the query
+func (q *ShareQuery) normalize() error {
+	if len(q.RecordTypes) == 0 {
+		q.RecordTypes = []string{RecordTypePrescription, RecordTypeAppointment, RecordTypeLabReport}
+	}
+	seen := make(map[string]bool)
+	var recordTypes []string
+	for _, recordType := range q.RecordTypes {
+		if _, ok := queryableTypes[recordType]; !ok {
+			return fmt.Errorf("query shares can't include record type %q", recordType)
+		}
+		if !seen[recordType] {
+			seen[recordType] = true
+			recordTypes = append(recordTypes, recordType)
+		}
+	}
+	q.RecordTypes = recordTypes
+
+	if q.LastDays < 0 || q.LastDays > 3650 {
+		return errors.New("last_days must be between 1 and 3650")
+	}
+	if q.LastDays > 0 && (q.From != nil || q.To != nil) {
+		return errors.New("use either last_days or from/to, not both")
+	}
+	if q.From != nil && q.To != nil && q.To.Before(q.From.Time) {
+		return errors.New("to must not be before from")
+	}
+
+	tags, err := q.Tags.Normalize()
+	if err != nil {
+		return err
+	}
+	q.Tags = tags
+	return nil
+}
+
+// recordType is the share record type for the query: the single type it
+// matches, or a bundle
+func (q *ShareQuery) recordType() string {
+	if len(q.RecordTypes) == 1 {
+		return q.RecordTypes[0]
+	}
+	return RecordTypeBundle
+}
+
+// window returns the dates the query covers on the given day, as
+// YYYY-MM-DD; either may be empty for an open end
+func (q *ShareQuery) window(today time.Time) (string, string) {
+	if q.LastDays > 0 {
+		return today.AddDate(0, 0, -(q.LastDays - 1)).Format("2006-01-02"), today.Format("2006-01-02")
+	}
+	var from, to string
+	if q.From != nil {
+		from = q.From.Format("2006-01-02")
+	}
+	if q.To != nil {
+		to = q.To.Format("2006-01-02")
+	}
+	return from, to
+}
+
+// resolve finds the owner's records matching the query right now. Dates are
+// the owner's local dates.
+func (q *ShareQuery) resolve(db *gorm.DB, ownerID uuid.UUID, loc *time.Location) ([]RecordRef, error) {
+	now := time.Now().In(loc)
+	from, to := q.window(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc))
+
+	var refs []RecordRef
+	for _, recordType := range shareableTypes {
+		if !contains(q.RecordTypes, recordType) {
+			continue
+		}
+		dateColumn := queryableTypes[recordType]
+		query := db.Model(modelFor(recordType)).Where("user_id = ?", ownerID)
+		if from != "" {
+			query = query.Where(dateColumn+" >= ?", from)
+		}
+		if to != "" {
+			// Appointments have a time, so compare against the next day
+			query = query.Where(dateColumn+" < (?::date + 1)", to)
+		}
+		if len(q.Tags) > 0 {
+			query = query.Where("EXISTS (SELECT 1 FROM jsonb_array_elements_text(tags) AS tag WHERE tag IN ?)", []string(q.Tags))
+		}
+
+		var ids []uuid.UUID
+		if err := query.Order(dateColumn+" DESC").Limit(maxQueryShareRecords).Pluck("id", &ids).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			refs = append(refs, RecordRef{Type: recordType, ID: id})
+		}
+	}
+	return refs, nil
+}
+
+// shareRefs returns the records a share shows: its stored references, or for
+// query shares whatever currently matches the query
+func (s *SharingService) shareRefs(sharedRecord *database.SharedRecord) ([]RecordRef, error) {
+	if sharedRecord.ShareMode != ShareModeQuery {
+		return decodeRecordRefs(sharedRecord)
+	}
+	var query ShareQuery
+	if err := json.Unmarshal([]byte(sharedRecord.QueryFilter), &query); err != nil {
+		return nil, errors.New("share has an invalid query")
+	}
+	return query.resolve(s.db, sharedRecord.UserID, userLocation(s.db, sharedRecord.UserID))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
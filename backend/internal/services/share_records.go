@@ -0,0 +1,173 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"medical-records-app/internal/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Shareable record types
+const (
+	RecordTypePrescription = "prescription"
+	RecordTypeAppointment  = "appointment"
+	RecordTypeLabReport    = "lab_report"
+	RecordTypeInsurance    = "insurance"
+	RecordTypeBundle       = "bundle"
+)
+
+// shareableTypes are the record types a bundle can hold, in display order
+var shareableTypes = []string{RecordTypePrescription, RecordTypeAppointment, RecordTypeLabReport, RecordTypeInsurance}
+
+// bundleKeys name each record type's list in a bundle's records
+var bundleKeys = map[string]string{
+	RecordTypePrescription: "prescriptions",
+	RecordTypeAppointment:  "appointments",
+	RecordTypeLabReport:    "lab_reports",
+	RecordTypeInsurance:    "insurance",
+}
+
+// RecordRef points at one shared record
+type RecordRef struct {
+	Type string    `json:"type"`
+	ID   uuid.UUID `json:"id"`
+}
+
+// RecordRefError explains why one record can't be shared
+type RecordRefError struct {
+	Type  string    `json:"type,omitempty"`
+	ID    uuid.UUID `json:"id"`
+	Error string    `json:"error"`
+}
+
+// ShareRecordsError lists the records in a share request that the caller
+// can't share
+type ShareRecordsError struct {
+	Errors []RecordRefError
+}
+
+func (e *ShareRecordsError) Error() string {
+	return fmt.Sprintf("%d record(s) cannot be shared", len(e.Errors))
+}
+
+// resolveShareRefs turns a share request into typed references to records
+// the owner has. Single-type shares take plain IDs; bundles take typed refs,
+// or plain IDs whose type is looked up once here.
+func resolveShareRefs(db *gorm.DB, ownerID uuid.UUID, recordType string, ids []uuid.UUID, refs []RecordRef) ([]RecordRef, error) {
+	if recordType != RecordTypeBundle && modelFor(recordType) == nil {
+		return nil, fmt.Errorf("invalid record type %q", recordType)
+	}
+
+	var requested []RecordRef
+	var problems []RecordRefError
+	for _, id := range ids {
+		if recordType == RecordTypeBundle {
+			requested = append(requested, RecordRef{ID: id})
+		} else {
+			requested = append(requested, RecordRef{Type: recordType, ID: id})
+		}
+	}
+	for _, ref := range refs {
+		switch {
+		case modelFor(ref.Type) == nil:
+			problems = append(problems, RecordRefError{Type: ref.Type, ID: ref.ID, Error: "unknown record type"})
+		case recordType != RecordTypeBundle && ref.Type != recordType:
+			problems = append(problems, RecordRefError{Type: ref.Type, ID: ref.ID, Error: "record type does not match the share's record_type"})
+		default:
+			requested = append(requested, ref)
+		}
+	}
+	if len(requested) == 0 && len(problems) == 0 {
+		return nil, fmt.Errorf("at least one record is required")
+	}
+
+	// Find which of the requested IDs the owner has, per type
+	owned := make(map[RecordRef]bool)
+	for _, recordType := range shareableTypes {
+		var candidates []uuid.UUID
+		for _, ref := range requested {
+			if ref.Type == recordType || ref.Type == "" {
+				candidates = append(candidates, ref.ID)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		var found []uuid.UUID
+		if err := db.Model(modelFor(recordType)).
+			Where("id IN ? AND user_id = ?", candidates, ownerID).
+			Pluck("id", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range found {
+			owned[RecordRef{Type: recordType, ID: id}] = true
+		}
+	}
+
+	var resolved []RecordRef
+	seen := make(map[RecordRef]bool)
+	for _, ref := range requested {
+		if ref.Type == "" {
+			for _, recordType := range shareableTypes {
+				if owned[RecordRef{Type: recordType, ID: ref.ID}] {
+					ref.Type = recordType
+					break
+				}
+			}
+		}
+		if ref.Type == "" || !owned[ref] {
+			// Don't reveal whether the record exists for someone else
+			problems = append(problems, RecordRefError{Type: ref.Type, ID: ref.ID, Error: "record not found"})
+			continue
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			resolved = append(resolved, ref)
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ShareRecordsError{Errors: problems}
+	}
+	return resolved, nil
+}
+
+// decodeRecordRefs reads a share's record references. Shares created before
+// references were typed store a plain ID list; those take the share's record
+// type, and bundle entries are left untyped.
+func decodeRecordRefs(sharedRecord *database.SharedRecord) ([]RecordRef, error) {
+	var refs []RecordRef
+	if err := json.Unmarshal([]byte(sharedRecord.RecordIDs), &refs); err == nil {
+		return refs, nil
+	}
+
+	var ids []uuid.UUID
+	if err := json.Unmarshal([]byte(sharedRecord.RecordIDs), &ids); err != nil {
+		return nil, err
+	}
+	refs = make([]RecordRef, len(ids))
+	for i, id := range ids {
+		refs[i] = RecordRef{ID: id}
+		if sharedRecord.RecordType != RecordTypeBundle {
+			refs[i].Type = sharedRecord.RecordType
+		}
+	}
+	return refs, nil
+}
+
+// modelFor returns the model for a shareable record type, or nil
+func modelFor(recordType string) interface{} {
+	switch recordType {
+	case RecordTypePrescription:
+		return &database.Prescription{}
+	case RecordTypeAppointment:
+		return &database.Appointment{}
+	case RecordTypeLabReport:
+		return &database.LabReport{}
+	case RecordTypeInsurance:
+		return &database.HealthInsurance{}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"medical-records-app/internal/database"
+	"reflect"
+	"strings"
+)
+
+// RedactionProfile says which fields of the shared records the viewer can't
+// see. It is applied when the records are loaded, so the viewer's JSON,
+// shared files and packets all leave the hidden fields out.
+type RedactionProfile struct {
+	HideNotes       bool `json:"hide_notes"`       // notes and prescription instructions
+	HideAttachments bool `json:"hide_attachments"` // prescription attachments and lab report files
+	HideProviders   bool `json:"hide_providers"`   // doctors, specialties, hospitals, labs and locations
+	MaskIdentifiers bool `json:"mask_identifiers"` // policy, group and member numbers keep their last 4 characters
+	// Fields limits record types to the listed JSON fields, e.g.
+	// {"prescription": ["medicine_name", "dosage"]}. The id is always shown.
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+// Fields each profile option covers, by record type
+var (
+	noteFields = map[string][]string{
+		RecordTypePrescription: {"instructions"},
+		RecordTypeAppointment:  {"notes"},
+		RecordTypeLabReport:    {"notes"},
+		RecordTypeInsurance:    {"notes"},
+	}
+	attachmentFields = map[string][]string{
+		RecordTypePrescription: {"attachment_url", "attachment_type"},
+		RecordTypeLabReport:    {"report_url", "report_type"},
+	}
+	providerFields = map[string][]string{
+		RecordTypePrescription: {"prescribing_doctor", "doctor_specialty", "hospital"},
+		RecordTypeAppointment:  {"doctor_name", "specialty", "hospital", "location"},
+		RecordTypeLabReport:    {"lab_name"},
+	}
+	identifierFields = map[string][]string{
+		RecordTypeInsurance: {"policy_number", "group_number", "member_id"},
+	}
+)
+
+// unselectableFields are never part of a shared record's JSON worth choosing
+var unselectableFields = map[string]bool{"id": true, "user": true}
+
+// IsZero reports whether the profile hides nothing
+func (p RedactionProfile) IsZero() bool {
+	return !p.HideNotes && !p.HideAttachments && !p.HideProviders && !p.MaskIdentifiers && len(p.Fields) == 0
+}
+
+// describe summarises the profile for packet cover pages, or "" when
+// nothing is hidden
+func (p RedactionProfile) describe() string {
+	var parts []string
+	for _, option := range []struct {
+		on   bool
+		text string
+	}{
+		{p.HideNotes, "notes"},
+		{p.HideAttachments, "attachments"},
+		{p.HideProviders, "doctors and facilities"},
+		{len(p.Fields) > 0, "fields not selected for sharing"},
+	} {
+		if option.on {
+			parts = append(parts, option.text)
+		}
+	}
+	text := strings.Join(parts, ", ")
+	if p.MaskIdentifiers {
+		if text != "" {
+			text += "; "
+		}
+		text += "identifiers masked"
+	}
+	return text
+}
+
+// validate checks the field selections against the record types' fields
+func (p RedactionProfile) validate() error {
+	for recordType, fields := range p.Fields {
+		model := modelFor(recordType)
+		if model == nil {
+			return fmt.Errorf("redaction: unknown record type %q", recordType)
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("redaction: fields for %s must list at least one field", recordType)
+		}
+		known := jsonFields(reflect.TypeOf(model).Elem())
+		for _, field := range fields {
+			if _, ok := known[field]; !ok || unselectableFields[field] {
+				return fmt.Errorf("redaction: %s has no field %q", recordType, field)
+			}
+		}
+	}
+	return nil
+}
+
+// hidden returns the JSON fields of a record type the viewer can't see
+func (p RedactionProfile) hidden(recordType string) map[string]bool {
+	hidden := make(map[string]bool)
+	add := func(option bool, fields map[string][]string) {
+		if option {
+			for _, field := range fields[recordType] {
+				hidden[field] = true
+			}
+		}
+	}
+	add(p.HideNotes, noteFields)
+	add(p.HideAttachments, attachmentFields)
+	add(p.HideProviders, providerFields)
+
+	if selected, ok := p.Fields[recordType]; ok {
+		shown := make(map[string]bool, len(selected))
+		for _, field := range selected {
+			shown[field] = true
+		}
+		if model := modelFor(recordType); model != nil {
+			for field := range jsonFields(reflect.TypeOf(model).Elem()) {
+				if !shown[field] && field != "id" {
+					hidden[field] = true
+				}
+			}
+		}
+	}
+	return hidden
+}
+
+// apply clears the hidden fields of one record and masks its identifiers.
+// record must be a pointer to the model of recordType.
+func (p RedactionProfile) apply(recordType string, record interface{}) {
+	if p.IsZero() {
+		return
+	}
+	hidden := p.hidden(recordType)
+	masked := make(map[string]bool)
+	if p.MaskIdentifiers {
+		for _, field := range identifierFields[recordType] {
+			masked[field] = true
+		}
+	}
+
+	v := reflect.ValueOf(record).Elem()
+	for field, index := range jsonFields(v.Type()) {
+		f := v.Field(index)
+		switch {
+		case hidden[field]:
+			f.Set(reflect.Zero(f.Type()))
+		case masked[field] && f.Kind() == reflect.String:
+			f.SetString(maskIdentifier(f.String()))
+		}
+	}
+}
+
+// applyAll redacts every record in a slice of records of recordType
+func (p RedactionProfile) applyAll(recordType string, records interface{}) {
+	if p.IsZero() {
+		return
+	}
+	v := reflect.ValueOf(records)
+	for i := 0; i < v.Len(); i++ {
+		p.apply(recordType, v.Index(i).Addr().Interface())
+	}
+}
+
+// stripJSON turns records of recordType into JSON objects without the hidden
+// keys, so the viewer can't tell an empty field from a hidden one
+func (p RedactionProfile) stripJSON(recordType string, records interface{}) (interface{}, error) {
+	hidden := p.hidden(recordType)
+	if len(hidden) == 0 {
+		return records, nil
+	}
+	encoded, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(encoded, &objects); err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		for field := range hidden {
+			delete(object, field)
+		}
+	}
+	return objects, nil
+}
+
+// maskIdentifier keeps the last 4 characters of an identifier
+func maskIdentifier(s string) string {
+	if s == "" {
+		return ""
+	}
+	runes := []rune(s)
+	keep := 4
+	if len(runes) <= keep {
+		keep = 0
+	}
+	return strings.Repeat("•", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+// jsonFields maps the JSON names of a struct's fields to their index
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// decodeRedaction reads a share's redaction profile; shares without one
+// hide nothing
+func decodeRedaction(sharedRecord *database.SharedRecord) (RedactionProfile, error) {
+	var profile RedactionProfile
+	if sharedRecord.Redaction == "" {
+		return profile, nil
+	}
+	if err := json.Unmarshal([]byte(sharedRecord.Redaction), &profile); err != nil {
+		return profile, errors.New("share has an invalid redaction profile")
+	}
+	return profile, nil
+}
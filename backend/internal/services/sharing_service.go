@@ -3,152 +3,674 @@ package services
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"medical-records-app/internal/auth"
 	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"medical-records-app/internal/storage"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// KindShareLink is the notification kind for share links sent to recipients
+const KindShareLink = "share_link"
+
+const (
+	shareTokenBytes     = 32
+	shareTokenPrefixLen = 8
+)
+
+// maxShareSends caps how often one share link can be emailed or texted, so a
+// share can't be used to spam a recipient
+const maxShareSends = 5
+
+var (
+	// ErrShareSendLimit is returned when a share link has been sent too often
+	ErrShareSendLimit = fmt.Errorf("share link has already been sent %d times", maxShareSends)
+	// ErrShareGone is returned for links that were revoked, expired or used up
+	ErrShareGone = errors.New("share link is no longer available")
+)
+
 type SharingService struct {
-	db *gorm.DB
+	db                  *gorm.DB
+	notificationService *NotificationService
+	codeService         *OneTimeCodeService
+	store               *storage.Store
+	frontendURL         string
+	publicURL           string
 }
 
-func NewSharingService(db *gorm.DB) *SharingService {
-	return &SharingService{db: db}
+func NewSharingService(db *gorm.DB, notificationService *NotificationService, codeService *OneTimeCodeService, store *storage.Store, frontendURL, publicURL string) *SharingService {
+	return &SharingService{
+		db:                  db,
+		notificationService: notificationService,
+		codeService:         codeService,
+		store:               store,
+		frontendURL:         frontendURL,
+		publicURL:           publicURL,
+	}
 }
 
-func (s *SharingService) CreateShareLink(userID uuid.UUID, recordType string, recordIDs []uuid.UUID, expiresInHours int, maxAccessCount int, allowDownload bool, recipientEmail, recipientPhone, shareMethod string) (*database.SharedRecord, error) {
-	// Generate unique share token
-	shareToken := uuid.New().String()
+// ShareURL is the absolute link a recipient opens in the web app
+func (s *SharingService) ShareURL(token string) string {
+	return s.frontendURL + "/share/" + token
+}
 
-	// Calculate expiration
-	expiresAt := time.Now().Add(time.Duration(expiresInHours) * time.Hour)
+// ShareLinkOptions describes a new share link
+type ShareLinkOptions struct {
+	RecordType          string
+	RecordIDs           []uuid.UUID // records of RecordType, or of any type for bundles
+	Records             []RecordRef // typed references, mainly for bundles
+	ExpiresInHours      int
+	MaxAccessCount      int
+	AllowDownload       bool
+	RecipientEmail      string
+	RecipientPhone      string
+	ShareMethod         string      // email, sms, link
+	Protection          string      // none, pin, otp
+	PIN                 string      // required when Protection is pin
+	Redaction           RedactionProfile
+	Query               *ShareQuery // set for query shares instead of RecordIDs/Records
+	NotifyOwnerOnAccess bool
+}
 
-	// Convert record IDs to JSON
-	recordIDsJSON, err := json.Marshal(recordIDs)
+// CreateShareLink stores a new share and returns it with its token. Only a
+// hash of the token is kept, so this is the one time it is available.
+func (s *SharingService) CreateShareLink(userID uuid.UUID, opts ShareLinkOptions) (*database.SharedRecord, string, error) {
+	if err := validateShareMethod(opts.ShareMethod, opts.RecipientEmail, opts.RecipientPhone); err != nil {
+		return nil, "", err
+	}
+	pinHash, err := shareProtection(&opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	var recipient *database.User
+	var recipientStatus string
+	if opts.ShareMethod == ShareMethodUser {
+		if opts.Protection != ShareProtectionNone {
+			return nil, "", errors.New("in-app shares can't use a pin or otp; the recipient signs in instead")
+		}
+		if recipient, err = s.findRecipient(userID, opts.RecipientEmail); err != nil {
+			return nil, "", err
+		}
+		recipientStatus = RecipientPending
+	}
+	shareMode := ShareModeStatic
+	var refs []RecordRef
+	var queryFilter string
+	if opts.Query != nil {
+		if len(opts.RecordIDs) > 0 || len(opts.Records) > 0 {
+			return nil, "", errors.New("query shares can't also list records")
+		}
+		if len(opts.Query.RecordTypes) == 0 && opts.RecordType != "" && opts.RecordType != RecordTypeBundle {
+			opts.Query.RecordTypes = []string{opts.RecordType}
+		}
+		if err := opts.Query.normalize(); err != nil {
+			return nil, "", err
+		}
+		encoded, err := json.Marshal(opts.Query)
+		if err != nil {
+			return nil, "", err
+		}
+		shareMode = ShareModeQuery
+		queryFilter = string(encoded)
+		opts.RecordType = opts.Query.recordType()
+	} else {
+		refs, err = resolveShareRefs(s.db, userID, opts.RecordType, opts.RecordIDs, opts.Records)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	var redaction string
+	if !opts.Redaction.IsZero() {
+		if err := opts.Redaction.validate(); err != nil {
+			return nil, "", err
+		}
+		encoded, err := json.Marshal(opts.Redaction)
+		if err != nil {
+			return nil, "", err
+		}
+		redaction = string(encoded)
+	}
+
+	shareToken, err := auth.GenerateRandomToken(shareTokenBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Calculate expiration
+	expiresAt := time.Now().Add(time.Duration(opts.ExpiresInHours) * time.Hour)
+
+	// Convert record references to JSON
+	var recordIDsJSON []byte
+	if shareMode == ShareModeStatic {
+		if recordIDsJSON, err = json.Marshal(refs); err != nil {
+			return nil, "", err
+		}
 	}
 
 	sharedRecord := &database.SharedRecord{
 		ID:                uuid.New(),
 		UserID:            userID,
-		ShareToken:        shareToken,
-		RecordType:        recordType,
+		TokenHash:         auth.HashToken(shareToken),
+		TokenPrefix:       shareToken[:shareTokenPrefixLen],
+		RecordType:        opts.RecordType,
 		RecordIDs:         string(recordIDsJSON),
+		ShareMode:         shareMode,
+		QueryFilter:       queryFilter,
 		ExpiresAt:         expiresAt,
-		MaxAccessCount:    maxAccessCount,
+		MaxAccessCount:    opts.MaxAccessCount,
 		CurrentAccessCount: 0,
-		AllowDownload:     allowDownload,
-		RecipientEmail:    recipientEmail,
-		RecipientPhone:    recipientPhone,
-		ShareMethod:       shareMethod,
+		AllowDownload:     opts.AllowDownload,
+		RecipientEmail:    opts.RecipientEmail,
+		RecipientPhone:    opts.RecipientPhone,
+		RecipientStatus:   recipientStatus,
+		ShareMethod:       opts.ShareMethod,
+		Protection:        opts.Protection,
+		PINHash:           pinHash,
+		Redaction:         redaction,
+		NotifyOwnerOnAccess: opts.NotifyOwnerOnAccess,
 		IsActive:          true,
 		CreatedAt:         time.Now(),
 		UpdatedAt:         time.Now(),
 	}
 
+	if recipient != nil {
+		sharedRecord.RecipientUserID = &recipient.ID
+	}
+
 	if err := s.db.Create(sharedRecord).Error; err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if recipient != nil {
+		go s.notifyRecipientOfShare(sharedRecord, recipient)
 	}
 
-	return sharedRecord, nil
+	return sharedRecord, shareToken, nil
 }
 
+// GetSharedRecordByToken looks up a share by its token. Revoked, expired and
+// used-up links return ErrShareGone (wrapped with the reason) rather than
+// not-found, so viewers can be told the link no longer works. In-app shares
+// can only be opened from the recipient's inbox.
 func (s *SharingService) GetSharedRecordByToken(token string) (*database.SharedRecord, error) {
 	var sharedRecord database.SharedRecord
-	if err := s.db.Where("share_token = ? AND is_active = ?", token, true).First(&sharedRecord).Error; err != nil {
+	if err := s.db.Where("token_hash = ? AND share_method <> ?", auth.HashToken(token), ShareMethodUser).First(&sharedRecord).Error; err != nil {
 		return nil, err
 	}
-
-	// Check if expired
-	if time.Now().After(sharedRecord.ExpiresAt) {
-		return nil, errors.New("share link has expired")
-	}
-
-	// Check access count limit
-	if sharedRecord.MaxAccessCount > 0 && sharedRecord.CurrentAccessCount >= sharedRecord.MaxAccessCount {
-		return nil, errors.New("share link has reached maximum access count")
+	if err := shareUnavailable(&sharedRecord); err != nil {
+		return nil, err
 	}
-
 	return &sharedRecord, nil
 }
 
-func (s *SharingService) RecordAccess(sharedRecordID uuid.UUID, ipAddress, userAgent, action string) error {
-	auditLog := &database.AuditLog{
-		ID:             uuid.New(),
-		SharedRecordID: sharedRecordID,
-		IPAddress:      ipAddress,
-		UserAgent:      userAgent,
-		AccessedAt:     time.Now(),
-		Action:         action,
+// RecordAccess counts one access and writes its audit log entry in a single
+// transaction. The count is only incremented while the link is active,
+// unexpired and under its limit, so concurrent viewers can't exceed it. The
+// returned share reflects the new count. viewerID is the signed-in recipient
+// of an in-app share, nil for token links. Views and packet exports are
+// counted; a packet holds everything the share does.
+func (s *SharingService) RecordAccess(sharedRecordID uuid.UUID, ipAddress, userAgent, action string, viewerID *uuid.UUID) (*database.SharedRecord, error) {
+	var sharedRecord database.SharedRecord
+	var first, firstDownload bool
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&database.SharedRecord{}).
+			Where("id = ? AND is_active = ? AND expires_at > ?", sharedRecordID, true, now).
+			Where("max_access_count = 0 OR current_access_count < max_access_count").
+			Updates(map[string]interface{}{
+				"current_access_count": gorm.Expr("current_access_count + 1"),
+				"updated_at":           now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.First(&sharedRecord, "id = ?", sharedRecordID).Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			if err := shareUnavailable(&sharedRecord); err != nil {
+				return err
+			}
+			return ErrShareGone
+		}
+
+		var err error
+		if first, err = markFirst(tx, sharedRecordID, "first_accessed_at", now); err != nil {
+			return err
+		}
+		if first {
+			sharedRecord.FirstAccessedAt = &now
+		}
+		if action == AccessExported {
+			if firstDownload, err = markFirst(tx, sharedRecordID, "first_downloaded_at", now); err != nil {
+				return err
+			}
+			if firstDownload {
+				sharedRecord.FirstDownloadedAt = &now
+			}
+		}
+
+		return tx.Create(&database.AuditLog{
+			ID:             uuid.New(),
+			SharedRecordID: sharedRecordID,
+			IPAddress:      ipAddress,
+			UserAgent:      userAgent,
+			AccessedAt:     now,
+			Action:         action,
+			ViewerUserID:   viewerID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.db.Create(auditLog).Error; err != nil {
-		return err
+	if first && sharedRecord.NotifyOwnerOnAccess {
+		go s.notifyOwnerOfAccess(sharedRecord.ID, false, ipAddress, userAgent, *sharedRecord.FirstAccessedAt)
+	}
+	if firstDownload {
+		go s.notifyOwnerOfAccess(sharedRecord.ID, true, ipAddress, userAgent, *sharedRecord.FirstDownloadedAt)
 	}
+	return &sharedRecord, nil
+}
 
-	// Increment access count
-	return s.db.Model(&database.SharedRecord{}).
-		Where("id = ?", sharedRecordID).
-		Update("current_access_count", gorm.Expr("current_access_count + 1")).Error
+// shareUnavailable says why a share can no longer be opened, or nil
+func shareUnavailable(sharedRecord *database.SharedRecord) error {
+	switch ShareStatus(sharedRecord) {
+	case ShareStatusRevoked:
+		if sharedRecord.DeactivationReason == DeactivatedLocked {
+			return fmt.Errorf("%w: it was disabled after too many wrong attempts", ErrShareGone)
+		}
+		return fmt.Errorf("%w: it has been revoked", ErrShareGone)
+	case ShareStatusExpired:
+		return fmt.Errorf("%w: it has expired", ErrShareGone)
+	case ShareStatusExhausted:
+		return fmt.Errorf("%w: it has reached its maximum number of views", ErrShareGone)
+	}
+	return nil
 }
 
-func (s *SharingService) GetSharedRecordsByUser(userID uuid.UUID) ([]database.SharedRecord, error) {
+// GetSharedRecordsByUser lists the user's shares, newest first, optionally
+// only those with the given status (active, expired, exhausted or revoked)
+func (s *SharingService) GetSharedRecordsByUser(userID uuid.UUID, status string) ([]database.SharedRecord, error) {
+	scope, err := shareStatusScope(status, time.Now())
+	if err != nil {
+		return nil, err
+	}
 	var sharedRecords []database.SharedRecord
 	if err := s.db.Where("user_id = ?", userID).
+		Scopes(scope).
 		Preload("AccessLogs").
 		Order("created_at DESC").
 		Find(&sharedRecords).Error; err != nil {
 		return nil, err
 	}
+	for i := range sharedRecords {
+		sharedRecords[i].Status = ShareStatus(&sharedRecords[i])
+	}
 	return sharedRecords, nil
 }
 
+// RevokeShareLink deactivates one of the user's shares for good. Revoking
+// a link the sweeper already deactivated records that it was revoked.
 func (s *SharingService) RevokeShareLink(userID, shareID uuid.UUID) error {
-	return s.db.Model(&database.SharedRecord{}).
+	now := time.Now()
+	result := s.db.Model(&database.SharedRecord{}).
 		Where("id = ? AND user_id = ?", shareID, userID).
-		Update("is_active", false).Error
+		Where("is_active OR deactivation_reason <> ?", DeactivatedRevoked).
+		Updates(map[string]interface{}{
+			"is_active":           false,
+			"deactivated_at":      now,
+			"deactivation_reason": DeactivatedRevoked,
+			"updated_at":          now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Already revoked, or not the user's share
+		if _, err := s.GetOwnShare(userID, shareID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *SharingService) GetRecordsByIDs(recordType string, recordIDs []uuid.UUID) (interface{}, error) {
-	switch recordType {
-	case "prescription":
-		var prescriptions []database.Prescription
-		if err := s.db.Where("id IN ?", recordIDs).Find(&prescriptions).Error; err != nil {
-			return nil, err
+// LinkShare is a share as the holder of its link sees it. Who it was sent
+// to, its redaction profile and the owner's delivery and lockout details are
+// left out.
+type LinkShare struct {
+	RecordType    string    `json:"record_type"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	RemainingUses *int      `json:"remaining_uses"` // null = unlimited
+	AllowDownload bool      `json:"allow_download"`
+	Protection    string    `json:"protection"` // none, pin, otp
+}
+
+// LinkShareOf builds the viewer's projection of a share
+func LinkShareOf(sharedRecord *database.SharedRecord) *LinkShare {
+	share := &LinkShare{
+		RecordType:    sharedRecord.RecordType,
+		ExpiresAt:     sharedRecord.ExpiresAt,
+		AllowDownload: sharedRecord.AllowDownload,
+		Protection:    sharedRecord.Protection,
+	}
+	if sharedRecord.MaxAccessCount > 0 {
+		remaining := sharedRecord.MaxAccessCount - sharedRecord.CurrentAccessCount
+		if remaining < 0 {
+			remaining = 0
 		}
-		return prescriptions, nil
-	case "appointment":
-		var appointments []database.Appointment
-		if err := s.db.Where("id IN ?", recordIDs).Find(&appointments).Error; err != nil {
-			return nil, err
+		share.RemainingUses = &remaining
+	}
+	return share
+}
+
+// GetSharedRecords loads the records a share points at. Ownership is checked
+// again, and records deleted since the share was created are left out.
+// Attachment URLs are replaced with share-scoped links built from token and
+// the viewer session, and fields hidden by the share's redaction profile are
+// left out of the JSON.
+func (s *SharingService) GetSharedRecords(sharedRecord *database.SharedRecord, token, sessionToken string) (interface{}, error) {
+	return s.viewerRecords(sharedRecord, func(recordType string, recordID uuid.UUID) string {
+		return s.SharedFileURL(token, sessionToken, recordType, recordID)
+	})
+}
+
+// viewerRecords loads a share's records as its viewer sees them, with file
+// URLs from fileURL
+func (s *SharingService) viewerRecords(sharedRecord *database.SharedRecord, fileURL func(string, uuid.UUID) string) (interface{}, error) {
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	redaction, err := decodeRedaction(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.GetRecordsByIDs(sharedRecord.UserID, sharedRecord.RecordType, refs, redaction)
+	if err != nil {
+		return nil, err
+	}
+	hideFileURLs(records, fileURL)
+
+	if bundle, ok := records.(map[string]interface{}); ok {
+		for recordType, key := range bundleKeys {
+			if bundle[key], err = redaction.stripJSON(recordType, bundle[key]); err != nil {
+				return nil, err
+			}
 		}
-		return appointments, nil
-	case "lab_report":
-		var labReports []database.LabReport
-		if err := s.db.Where("id IN ?", recordIDs).Find(&labReports).Error; err != nil {
+		return bundle, nil
+	}
+	return redaction.stripJSON(sharedRecord.RecordType, records)
+}
+
+// GetRecordsByIDs loads the owner's records for the given references, with
+// the redaction profile applied. Untyped references (from shares made before
+// bundles were typed) are looked up in every table.
+func (s *SharingService) GetRecordsByIDs(ownerID uuid.UUID, recordType string, refs []RecordRef, redaction RedactionProfile) (interface{}, error) {
+	idsOf := func(refType string) []uuid.UUID {
+		var ids []uuid.UUID
+		for _, ref := range refs {
+			if ref.Type == refType || ref.Type == "" {
+				ids = append(ids, ref.ID)
+			}
+		}
+		return ids
+	}
+
+	prescriptions := []database.Prescription{}
+	appointments := []database.Appointment{}
+	labReports := []database.LabReport{}
+	insurance := []database.HealthInsurance{}
+	loads := map[string]interface{}{
+		RecordTypePrescription: &prescriptions,
+		RecordTypeAppointment:  &appointments,
+		RecordTypeLabReport:    &labReports,
+		RecordTypeInsurance:    &insurance,
+	}
+	for _, loadType := range shareableTypes {
+		if recordType != RecordTypeBundle && recordType != loadType {
+			continue
+		}
+		ids := idsOf(loadType)
+		if len(ids) == 0 {
+			continue
+		}
+		if err := s.db.Where("id IN ? AND user_id = ?", ids, ownerID).Find(loads[loadType]).Error; err != nil {
 			return nil, err
 		}
+		redaction.applyAll(loadType, reflect.ValueOf(loads[loadType]).Elem().Interface())
+	}
+
+	switch recordType {
+	case RecordTypePrescription:
+		return prescriptions, nil
+	case RecordTypeAppointment:
+		return appointments, nil
+	case RecordTypeLabReport:
 		return labReports, nil
-	case "bundle":
-		// Return all types
-		var prescriptions []database.Prescription
-		var appointments []database.Appointment
-		var labReports []database.LabReport
-		
-		s.db.Where("id IN ?", recordIDs).Find(&prescriptions)
-		s.db.Where("id IN ?", recordIDs).Find(&appointments)
-		s.db.Where("id IN ?", recordIDs).Find(&labReports)
-		
+	case RecordTypeInsurance:
+		return insurance, nil
+	case RecordTypeBundle:
 		return map[string]interface{}{
-			"prescriptions": prescriptions,
-			"appointments":  appointments,
-			"lab_reports":   labReports,
+			bundleKeys[RecordTypePrescription]: prescriptions,
+			bundleKeys[RecordTypeAppointment]:  appointments,
+			bundleKeys[RecordTypeLabReport]:    labReports,
+			bundleKeys[RecordTypeInsurance]:    insurance,
 		}, nil
 	default:
 		return nil, errors.New("invalid record type")
 	}
 }
 
+// SendShareLink emails or texts the link for token to the share's recipient.
+// Link-only shares have nothing to send and return a nil delivery. A failed
+// first attempt is retried in the background; the share's delivery status
+// follows it through HandleDelivery.
+func (s *SharingService) SendShareLink(sharedRecord *database.SharedRecord, token string) (*database.NotificationDelivery, error) {
+	channel, recipient := shareRecipient(sharedRecord)
+	if channel == "" {
+		return nil, nil
+	}
+	if sharedRecord.SendCount >= maxShareSends {
+		return nil, ErrShareSendLimit
+	}
+
+	var owner database.User
+	if err := s.db.First(&owner, "id = ?", sharedRecord.UserID).Error; err != nil {
+		return nil, err
+	}
+	senderName := strings.TrimSpace(owner.FirstName + " " + owner.LastName)
+	if senderName == "" {
+		senderName = "A Medical Records App user"
+	}
+
+	refs, err := s.shareRefs(sharedRecord)
+	if err != nil {
+		return nil, err
+	}
+	codeChannel, _ := codeRecipient(sharedRecord)
+
+	msg, err := notify.Render(KindShareLink, channel, map[string]interface{}{
+		"SenderName":     senderName,
+		"RecordCount":    len(refs),
+		"RecordLabel":    recordLabel(sharedRecord.RecordType, len(refs)),
+		"ShareURL":       s.ShareURL(token),
+		"ExpiresAt":      sharedRecord.ExpiresAt.In(userLocation(s.db, owner.ID)).Format("Jan 2, 2006 3:04 PM MST"),
+		"MaxAccessCount": sharedRecord.MaxAccessCount,
+		"AllowDownload":  sharedRecord.AllowDownload,
+		"Protection":     sharedRecord.Protection,
+		"CodeChannel":    codeChannel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the send before attempting it so concurrent resends can't exceed
+	// the cap
+	result := s.db.Model(&database.SharedRecord{}).
+		Where("id = ? AND send_count < ?", sharedRecord.ID, maxShareSends).
+		Updates(map[string]interface{}{
+			"send_count": gorm.Expr("send_count + 1"),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrShareSendLimit
+	}
+
+	userID := sharedRecord.UserID
+	delivery, err := s.notificationService.Send(NotificationRequest{
+		UserID:      &userID,
+		Channel:     channel,
+		Recipient:   recipient,
+		Subject:     msg.Subject,
+		Body:        msg.Body,
+		Kind:        KindShareLink,
+		RelatedType: "shared_record",
+		RelatedID:   &sharedRecord.ID,
+		Secrets:     []string{token}, // only the hash is stored; the log mustn't undo that
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.First(sharedRecord, "id = ?", sharedRecord.ID).Error; err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ResendShareLink sends an active share's link again. Tokens aren't stored,
+// so the share gets a new token and the previously sent link stops working.
+// A non-empty email or phone replaces the stored recipient for the share's
+// method, e.g. to fix a typo. The new token is returned alongside the share.
+func (s *SharingService) ResendShareLink(userID, shareID uuid.UUID, recipientEmail, recipientPhone string) (*database.SharedRecord, string, *database.NotificationDelivery, error) {
+	var sharedRecord database.SharedRecord
+	if err := s.db.Where("id = ? AND user_id = ?", shareID, userID).First(&sharedRecord).Error; err != nil {
+		return nil, "", nil, err
+	}
+	if !sharedRecord.IsActive {
+		return nil, "", nil, errors.New("share link has been revoked")
+	}
+	if time.Now().After(sharedRecord.ExpiresAt) {
+		return nil, "", nil, errors.New("share link has expired")
+	}
+	if sharedRecord.ShareMethod != "email" && sharedRecord.ShareMethod != "sms" {
+		return nil, "", nil, errors.New("only email and sms shares can be resent")
+	}
+	if sharedRecord.SendCount >= maxShareSends {
+		return nil, "", nil, ErrShareSendLimit
+	}
+
+	token, err := auth.GenerateRandomToken(shareTokenBytes)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	updates := map[string]interface{}{
+		"token_hash":   auth.HashToken(token),
+		"token_prefix": token[:shareTokenPrefixLen],
+		"updated_at":   time.Now(),
+	}
+	if recipientEmail != "" && sharedRecord.ShareMethod == "email" {
+		updates["recipient_email"] = recipientEmail
+	}
+	if recipientPhone != "" && sharedRecord.ShareMethod == "sms" {
+		updates["recipient_phone"] = recipientPhone
+	}
+	if err := s.db.Model(&sharedRecord).Updates(updates).Error; err != nil {
+		return nil, "", nil, err
+	}
+
+	// Earlier sends still being retried carry the old link; stop them and
+	// drop their copy of it
+	if err := s.notificationService.CancelRetries(sharedRecord.ID, KindShareLink, "superseded by a resend"); err != nil {
+		return nil, "", nil, err
+	}
+
+	delivery, err := s.SendShareLink(&sharedRecord, token)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return &sharedRecord, token, delivery, nil
+}
+
+// HandleDelivery copies the status of a share link's email/SMS delivery onto
+// the share. Retries of an older delivery don't overwrite a newer resend.
+func (s *SharingService) HandleDelivery(delivery *database.NotificationDelivery) {
+	if delivery.Kind != KindShareLink || delivery.RelatedID == nil {
+		return
+	}
+
+	query := s.db.Model(&database.SharedRecord{}).Where("id = ?", *delivery.RelatedID)
+	if delivery.Attempts > 1 {
+		query = query.Where("delivery_id = ?", delivery.ID)
+	}
+	if err := query.Updates(map[string]interface{}{
+		"delivery_id":     delivery.ID,
+		"delivery_status": delivery.Status,
+		"delivery_error":  delivery.Error,
+		"delivered_at":    delivery.SentAt,
+		"updated_at":      time.Now(),
+	}).Error; err != nil {
+		log.Printf("Failed to update delivery status of share %s: %v", *delivery.RelatedID, err)
+	}
+}
+
+// validateShareMethod checks that email and SMS shares have somewhere to go
+func validateShareMethod(shareMethod, email, phone string) error {
+	switch shareMethod {
+	case "link":
+	case "email":
+		if email == "" {
+			return errors.New("recipient_email is required for email shares")
+		}
+	case "sms":
+		if phone == "" {
+			return errors.New("recipient_phone is required for sms shares")
+		}
+	case ShareMethodUser:
+		if email == "" {
+			return errors.New("recipient_email is required for in-app shares")
+		}
+	default:
+		return fmt.Errorf("invalid share method %q, expected email, sms, link or user", shareMethod)
+	}
+	return nil
+}
+
+// shareRecipient picks the notification channel and address for a share
+func shareRecipient(sharedRecord *database.SharedRecord) (string, string) {
+	switch sharedRecord.ShareMethod {
+	case "email":
+		return notify.ChannelEmail, sharedRecord.RecipientEmail
+	case "sms":
+		return notify.ChannelSMS, sharedRecord.RecipientPhone
+	}
+	return "", ""
+}
+
+// recordLabel describes the shared records for messages, e.g. "lab reports"
+func recordLabel(recordType string, count int) string {
+	labels := map[string][2]string{
+		"prescription": {"prescription", "prescriptions"},
+		"appointment":  {"appointment", "appointments"},
+		"lab_report":   {"lab report", "lab reports"},
+		"insurance":    {"insurance policy", "insurance policies"},
+	}
+	label, ok := labels[recordType]
+	if !ok {
+		label = [2]string{"medical record", "medical records"}
+	}
+	if count == 1 {
+		return label[0]
+	}
+	return label[1]
+}
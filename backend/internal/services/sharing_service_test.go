@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestRecordAccessConcurrentSingleUse opens a single-use link from many
+// goroutines at once, the way the share handler does, and checks that
+// exactly one of them gets in
+func TestRecordAccessConcurrentSingleUse(t *testing.T) {
+	db := testDB(t)
+	owner := createTestUser(t, db, "owner-password")
+	s := NewSharingService(db, nil, nil, nil, "http://frontend.test", "http://api.test")
+
+	token, err := auth.GenerateRandomToken(shareTokenBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := &database.SharedRecord{
+		ID:             uuid.New(),
+		UserID:         owner.ID,
+		TokenHash:      auth.HashToken(token),
+		TokenPrefix:    token[:shareTokenPrefixLen],
+		RecordType:     RecordTypePrescription,
+		RecordIDs:      "[]",
+		ShareMode:      ShareModeStatic,
+		ExpiresAt:      time.Now().Add(time.Hour),
+		MaxAccessCount: 1,
+		ShareMethod:    "link",
+		IsActive:       true,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := db.Create(share).Error; err != nil {
+		t.Fatalf("create share: %v", err)
+	}
+
+	const viewers = 50
+	var (
+		wg        sync.WaitGroup
+		start     = make(chan struct{})
+		mu        sync.Mutex
+		succeeded int
+		failures  []error
+	)
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			sharedRecord, err := s.GetSharedRecordByToken(token)
+			if err == nil {
+				_, err = s.RecordAccess(sharedRecord.ID, "127.0.0.1", "test", AccessViewed, nil)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+			} else {
+				failures = append(failures, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Errorf("%d of %d viewers got in, want exactly 1", succeeded, viewers)
+	}
+	exhausted := shareUnavailable(&database.SharedRecord{IsActive: true, ExpiresAt: share.ExpiresAt, MaxAccessCount: 1, CurrentAccessCount: 1})
+	for _, err := range failures {
+		if !errors.Is(err, ErrShareGone) || err.Error() != exhausted.Error() {
+			t.Errorf("viewer got %v, want %v", err, exhausted)
+		}
+	}
+
+	var stored database.SharedRecord
+	if err := db.First(&stored, "id = ?", share.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.CurrentAccessCount != 1 {
+		t.Errorf("access count = %d, want 1", stored.CurrentAccessCount)
+	}
+	var logged int64
+	db.Model(&database.AuditLog{}).Where("shared_record_id = ?", share.ID).Count(&logged)
+	if logged != 1 {
+		t.Errorf("%d audit log entries, want 1", logged)
+	}
+}
+
+func TestLinkShareOf(t *testing.T) {
+	sharedRecord := &database.SharedRecord{
+		RecordType:         RecordTypeBundle,
+		ExpiresAt:          time.Now().Add(time.Hour),
+		MaxAccessCount:     3,
+		CurrentAccessCount: 1,
+		AllowDownload:      true,
+		RecipientEmail:     "doctor@example.com",
+		RecipientPhone:     "+15555550100",
+		Protection:         ShareProtectionPIN,
+		FailedAttempts:     2,
+		Redaction:          `{"prescription":["notes"]}`,
+		QueryFilter:        `{"types":["prescription"]}`,
+		DeliveryStatus:     "sent",
+	}
+	encoded, err := json.Marshal(LinkShareOf(sharedRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"record_type", "expires_at", "remaining_uses", "allow_download", "protection"}
+	if len(got) != len(want) {
+		t.Errorf("viewer sees %s, want only %v", encoded, want)
+	}
+	for _, key := range want {
+		if _, ok := got[key]; !ok {
+			t.Errorf("viewer doesn't see %s in %s", key, encoded)
+		}
+	}
+	if got["remaining_uses"] != float64(2) {
+		t.Errorf("remaining_uses = %v, want 2", got["remaining_uses"])
+	}
+
+	sharedRecord.MaxAccessCount = 0
+	if remaining := LinkShareOf(sharedRecord).RemainingUses; remaining != nil {
+		t.Errorf("unlimited share has %d remaining uses, want nil", *remaining)
+	}
+}
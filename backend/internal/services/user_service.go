@@ -25,6 +25,11 @@ func (s *UserService) Register(email, password, firstName, lastName, phone strin
 		return nil, errors.New("user with this email already exists")
 	}
 
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := auth.HashPassword(password)
 	if err != nil {
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"errors"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/notify"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CodePurposePhoneVerification is the one-time code purpose for proving a
+// user owns their phone number
+const CodePurposePhoneVerification = "phone_verification"
+
+var (
+	ErrEmailAlreadyVerified    = errors.New("email address is already verified")
+	ErrPhoneAlreadyVerified    = errors.New("phone number is already verified")
+	ErrNoPhone                 = errors.New("add a phone number to your account first")
+	ErrInvalidPhone            = errors.New("phone number must be 7 to 15 digits, optionally starting with +")
+	ErrVerificationCooldown    = errors.New("a verification message was sent recently; wait a minute before requesting another")
+	ErrVerificationLimit       = errors.New("too many verification messages today; try again tomorrow")
+	ErrInvalidVerificationLink = errors.New("invalid or expired verification link")
+)
+
+// VerificationService proves that users control the email address and phone
+// number on their account
+type VerificationService struct {
+	db                  *gorm.DB
+	notificationService *NotificationService
+	codeService         *OneTimeCodeService
+	frontendURL         string
+	emailTokenTTL       time.Duration
+	phoneCodeTTL        time.Duration
+	cooldown            time.Duration
+	maxPerDay           int
+}
+
+func NewVerificationService(db *gorm.DB, notificationService *NotificationService, codeService *OneTimeCodeService, frontendURL string, emailTokenTTL, phoneCodeTTL, cooldown time.Duration, maxPerDay int) *VerificationService {
+	return &VerificationService{
+		db:                  db,
+		notificationService: notificationService,
+		codeService:         codeService,
+		frontendURL:         frontendURL,
+		emailTokenTTL:       emailTokenTTL,
+		phoneCodeTTL:        phoneCodeTTL,
+		cooldown:            cooldown,
+		maxPerDay:           maxPerDay,
+	}
+}
+
+// SendEmailVerification emails the user a signed link that verifies their
+// address. Sends are limited to one per cooldown and maxPerDay a day.
+func (s *VerificationService) SendEmailVerification(user *database.User) (*database.NotificationDelivery, error) {
+	if user.IsEmailVerified {
+		return nil, ErrEmailAlreadyVerified
+	}
+	if err := checkSendLimits(s.db, user.ID, KindEmailVerification, s.cooldown, s.maxPerDay); err != nil {
+		return nil, err
+	}
+
+	token, err := auth.GenerateEmailVerificationToken(user.ID, user.Email, s.emailTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	token = url.QueryEscape(token)
+	msg, err := notify.Render(KindEmailVerification, notify.ChannelEmail, map[string]interface{}{
+		"Name":      ownerGreeting(user),
+		"VerifyURL": s.frontendURL + "/verify-email?token=" + token,
+		"Hours":     int(s.emailTokenTTL / time.Hour),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.notificationService.Send(NotificationRequest{
+		UserID:      &user.ID,
+		Channel:     notify.ChannelEmail,
+		Recipient:   user.Email,
+		Subject:     msg.Subject,
+		Body:        msg.Body,
+		Kind:        KindEmailVerification,
+		RelatedType: "user",
+		RelatedID:   &user.ID,
+		Secrets:     []string{token}, // the link alone verifies the address
+	})
+}
+
+// VerifyEmail checks a verification link token and marks the address it was
+// sent to as verified, which also delivers in-app shares waiting for it.
+// Links for an address the user has since changed don't work.
+func (s *VerificationService) VerifyEmail(token string) (*database.User, error) {
+	claims, err := auth.ValidateEmailVerificationToken(token)
+	if err != nil {
+		return nil, ErrInvalidVerificationLink
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidVerificationLink
+	}
+
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidVerificationLink
+		}
+		return nil, err
+	}
+	if user.Email != claims.Email {
+		return nil, ErrInvalidVerificationLink
+	}
+	if user.IsEmailVerified {
+		return &user, nil
+	}
+
+	if err := s.db.Model(&user).Updates(map[string]interface{}{
+		"is_email_verified": true,
+		"updated_at":        time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+	user.IsEmailVerified = true
+	if err := claimWaitingShares(s.db, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SendPhoneCode texts the user a one-time code that verifies their phone
+// number, and returns the number masked. Sends are limited like email
+// verification; each code expires and allows a few guesses.
+func (s *VerificationService) SendPhoneCode(user *database.User) (string, error) {
+	if user.Phone == "" {
+		return "", ErrNoPhone
+	}
+	if user.IsPhoneVerified {
+		return "", ErrPhoneAlreadyVerified
+	}
+	if err := checkSendLimits(s.db, user.ID, KindPhoneVerification, s.cooldown, s.maxPerDay); err != nil {
+		return "", err
+	}
+
+	code, err := s.codeService.Issue(CodePurposePhoneVerification, user.ID, user.Phone, s.phoneCodeTTL)
+	if err != nil {
+		return "", err
+	}
+	msg, err := notify.Render(KindPhoneVerification, notify.ChannelSMS, map[string]interface{}{
+		"Code":    code,
+		"Minutes": int(s.phoneCodeTTL / time.Minute),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.notificationService.Send(NotificationRequest{
+		UserID:      &user.ID,
+		Channel:     notify.ChannelSMS,
+		Recipient:   user.Phone,
+		Body:        msg.Body,
+		Kind:        KindPhoneVerification,
+		RelatedType: "user",
+		RelatedID:   &user.ID,
+		Secrets:     []string{code},
+	}); err != nil {
+		return "", err
+	}
+	return maskRecipient(notify.ChannelSMS, user.Phone), nil
+}
+
+// VerifyPhone checks a code from SendPhoneCode and marks the number it was
+// sent to as verified. Codes sent to a number the user has since replaced
+// don't count.
+func (s *VerificationService) VerifyPhone(userID uuid.UUID, code string) (*database.User, error) {
+	otc, err := s.codeService.Verify(CodePurposePhoneVerification, userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.db.Model(&database.User{}).
+		Where("id = ? AND phone = ?", userID, otc.Target).
+		Updates(map[string]interface{}{
+			"is_phone_verified": true,
+			"updated_at":        time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidCode
+	}
+
+	var user database.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangePhone replaces the user's phone number. A new number has to be
+// verified again before SMS notifications go to it, and codes sent to the
+// old one stop working, as do texts to it that haven't gone out yet. An
+// empty phone removes the number.
+func (s *VerificationService) ChangePhone(userID uuid.UUID, phone string) (*database.User, error) {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	var user database.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		if user.Phone == phone {
+			return nil
+		}
+		oldPhone := user.Phone
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"phone":             phone,
+			"is_phone_verified": false,
+			"updated_at":        time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		// Don't send texts still waiting for delivery or a digest to the old number
+		if oldPhone != "" {
+			if err := tx.Model(&database.NotificationDelivery{}).
+				Where("user_id = ? AND channel = ? AND recipient = ? AND status IN ?", userID, notify.ChannelSMS, oldPhone,
+					[]string{DeliveryPending, DeliveryRetrying, DeliveryQueued}).
+				Updates(map[string]interface{}{
+					"status":          DeliveryFailed,
+					"error":           "phone number changed",
+					"next_attempt_at": nil,
+					"updated_at":      time.Now(),
+				}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("purpose = ? AND subject_id = ? AND consumed_at IS NULL", CodePurposePhoneVerification, userID).
+			Delete(&database.OneTimeCode{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// userRecipient is where a notification on channel reaches the user. SMS
+// only goes to a verified phone number; an empty result means skip the
+// channel.
+func userRecipient(user *database.User, channel string) string {
+	switch channel {
+	case notify.ChannelEmail:
+		return user.Email
+	case notify.ChannelSMS:
+		if user.IsPhoneVerified {
+			return user.Phone
+		}
+	}
+	return ""
+}
+
+// normalizePhone drops spacing and punctuation from a phone number and
+// checks what's left looks like one
+func normalizePhone(phone string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+	normalized := b.String()
+	if normalized == "" {
+		return "", nil
+	}
+	if digits := len(strings.TrimPrefix(normalized, "+")); digits < 7 || digits > 15 {
+		return "", ErrInvalidPhone
+	}
+	return normalized, nil
+}
+
+// checkSendLimits enforces a cooldown and daily cap on account messages of
+// one kind, such as verification codes, counted from the delivery log
+func checkSendLimits(db *gorm.DB, userID uuid.UUID, kind string, cooldown time.Duration, maxPerDay int) error {
+	var recent []time.Time
+	if err := db.Model(&database.NotificationDelivery{}).
+		Where("user_id = ? AND kind = ? AND created_at > ?", userID, kind, time.Now().Add(-24*time.Hour)).
+		Order("created_at DESC").
+		Pluck("created_at", &recent).Error; err != nil {
+		return err
+	}
+	if len(recent) > 0 && time.Since(recent[0]) < cooldown {
+		return ErrVerificationCooldown
+	}
+	if len(recent) >= maxPerDay {
+		return ErrVerificationLimit
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"medical-records-app/internal/auth"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/events"
+	"medical-records-app/internal/webhook"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = "pending"
+	WebhookRetrying  = "retrying"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead" // gave up after MaxAttempts; can be redelivered by hand
+)
+
+// WebhookTestEvent is the event type sent by the test-fire endpoint
+const WebhookTestEvent = "webhook.test"
+
+// maxResponseBody bounds how much of a receiver's response is kept
+const maxResponseBody = 1024
+
+// WebhookEndpointRequest creates or replaces an endpoint's settings
+type WebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	EventTypes  []string `json:"event_types" binding:"required"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// webhookPayload is the JSON body POSTed to endpoints
+type webhookPayload struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	UserID    uuid.UUID       `json:"user_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookService manages webhook endpoints and delivers events to them with
+// HMAC signatures, retrying failures with exponential backoff
+type WebhookService struct {
+	db          *gorm.DB
+	client      *http.Client
+	maxAttempts int
+	retryBase   time.Duration
+}
+
+func NewWebhookService(db *gorm.DB, client *http.Client, maxAttempts int, retryBase time.Duration) *WebhookService {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &WebhookService{
+		db:          db,
+		client:      client,
+		maxAttempts: maxAttempts,
+		retryBase:   retryBase,
+	}
+}
+
+// CreateEndpoint registers an endpoint for ownerID, or a global endpoint when
+// ownerID is nil. The signing secret is returned once.
+func (s *WebhookService) CreateEndpoint(ownerID *uuid.UUID, req WebhookEndpointRequest) (*database.WebhookEndpoint, string, error) {
+	eventTypes, err := encodeEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := webhook.ValidateURL(req.URL); err != nil {
+		return nil, "", err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	endpoint := &database.WebhookEndpoint{
+		ID:          uuid.New(),
+		UserID:      ownerID,
+		URL:         req.URL,
+		Description: req.Description,
+		EventTypes:  eventTypes,
+		Secret:      secret,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.db.Create(endpoint).Error; err != nil {
+		return nil, "", err
+	}
+	return endpoint, secret, nil
+}
+
+func (s *WebhookService) GetEndpoints(ownerID *uuid.UUID) ([]database.WebhookEndpoint, error) {
+	var endpoints []database.WebhookEndpoint
+	if err := scopeEndpoints(s.db, ownerID).Order("created_at DESC").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (s *WebhookService) GetEndpoint(ownerID *uuid.UUID, endpointID uuid.UUID) (*database.WebhookEndpoint, error) {
+	var endpoint database.WebhookEndpoint
+	if err := scopeEndpoints(s.db, ownerID).Where("id = ?", endpointID).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (s *WebhookService) UpdateEndpoint(ownerID *uuid.UUID, endpointID uuid.UUID, req WebhookEndpointRequest) (*database.WebhookEndpoint, error) {
+	endpoint, err := s.GetEndpoint(ownerID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	eventTypes, err := encodeEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+	if err := webhook.ValidateURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	endpoint.URL = req.URL
+	endpoint.Description = req.Description
+	endpoint.EventTypes = eventTypes
+	if req.IsActive != nil {
+		endpoint.IsActive = *req.IsActive
+	}
+	endpoint.UpdatedAt = time.Now()
+	if err := s.db.Model(endpoint).
+		Select("url", "description", "event_types", "is_active", "updated_at").
+		Updates(endpoint).Error; err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+func (s *WebhookService) DeleteEndpoint(ownerID *uuid.UUID, endpointID uuid.UUID) error {
+	result := scopeEndpoints(s.db, ownerID).Where("id = ?", endpointID).Delete(&database.WebhookEndpoint{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RotateSecret replaces the endpoint's signing secret and returns it once
+func (s *WebhookService) RotateSecret(ownerID *uuid.UUID, endpointID uuid.UUID) (string, error) {
+	endpoint, err := s.GetEndpoint(ownerID, endpointID)
+	if err != nil {
+		return "", err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Model(endpoint).Updates(map[string]interface{}{
+		"secret":     secret,
+		"updated_at": time.Now(),
+	}).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// HandleEvent queues a delivery for every active endpoint subscribed to the
+// event: the owner's endpoints and global ones. It is registered as an event
+// sink, so it runs once per event on the replica that published it.
+func (s *WebhookService) HandleEvent(e events.Event) {
+	var endpoints []database.WebhookEndpoint
+	if err := s.db.Where("is_active = ? AND (user_id = ? OR user_id IS NULL)", true, e.UserID).
+		Find(&endpoints).Error; err != nil {
+		log.Printf("Failed to load webhook endpoints for event %d: %v", e.ID, err)
+		return
+	}
+
+	for i := range endpoints {
+		if !subscribedTo(&endpoints[i], e.Type) {
+			continue
+		}
+		delivery, err := s.queue(&endpoints[i], e)
+		if err != nil {
+			log.Printf("Failed to queue webhook delivery for event %d: %v", e.ID, err)
+			continue
+		}
+		go s.deliver(delivery.ID)
+	}
+}
+
+// SendTest sends a webhook.test event to the endpoint and waits for the
+// first attempt, so the caller sees the receiver's response straight away
+func (s *WebhookService) SendTest(ownerID *uuid.UUID, endpointID, actorID uuid.UUID) (*database.WebhookDelivery, error) {
+	endpoint, err := s.GetEndpoint(ownerID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"message":     "This is a test delivery",
+		"endpoint_id": endpoint.ID,
+	})
+	delivery, err := s.queue(endpoint, events.Event{
+		UserID:    actorID,
+		Type:      WebhookTestEvent,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.deliver(delivery.ID)
+
+	if err := s.db.First(delivery, "id = ?", delivery.ID).Error; err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// GetDeliveries returns an endpoint's delivery history, newest first
+func (s *WebhookService) GetDeliveries(ownerID *uuid.UUID, endpointID uuid.UUID, status string, limit, offset int) ([]database.WebhookDelivery, int64, error) {
+	if _, err := s.GetEndpoint(ownerID, endpointID); err != nil {
+		return nil, 0, err
+	}
+
+	query := s.db.Model(&database.WebhookDelivery{}).Where("endpoint_id = ?", endpointID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var deliveries []database.WebhookDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// Redeliver resets a delivery, typically a dead-lettered one, for another
+// full round of attempts
+func (s *WebhookService) Redeliver(ownerID *uuid.UUID, endpointID, deliveryID uuid.UUID) (*database.WebhookDelivery, error) {
+	if _, err := s.GetEndpoint(ownerID, endpointID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := s.db.Model(&database.WebhookDelivery{}).
+		Where("id = ? AND endpoint_id = ? AND status IN ?", deliveryID, endpointID, []string{WebhookDead, WebhookDelivered}).
+		Updates(map[string]interface{}{
+			"status":          WebhookPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"error":           "",
+			"updated_at":      now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("only delivered or dead deliveries can be redelivered")
+	}
+
+	s.deliver(deliveryID)
+
+	var delivery database.WebhookDelivery
+	if err := s.db.First(&delivery, "id = ?", deliveryID).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ProcessRetries attempts deliveries whose next attempt is due, including
+// pending ones whose first attempt never ran (e.g. after a restart)
+func (s *WebhookService) ProcessRetries() {
+	var due []database.WebhookDelivery
+	if err := s.db.Select("id").
+		Where("status IN ? AND next_attempt_at <= ?", []string{WebhookPending, WebhookRetrying}, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(50).
+		Find(&due).Error; err != nil {
+		log.Printf("Failed to load webhook retries: %v", err)
+		return
+	}
+	for _, delivery := range due {
+		s.deliver(delivery.ID)
+	}
+}
+
+// Start polls for due retries in the background
+func (s *WebhookService) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.ProcessRetries()
+		}
+	}()
+}
+
+func (s *WebhookService) queue(endpoint *database.WebhookEndpoint, e events.Event) (*database.WebhookDelivery, error) {
+	payload, err := json.Marshal(webhookPayload{
+		ID:        e.ID,
+		Type:      e.Type,
+		UserID:    e.UserID,
+		CreatedAt: e.CreatedAt,
+		Data:      e.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	delivery := &database.WebhookDelivery{
+		ID:            uuid.New(),
+		EndpointID:    endpoint.ID,
+		EventID:       e.ID,
+		EventType:     e.Type,
+		Payload:       string(payload),
+		Status:        WebhookPending,
+		MaxAttempts:   s.maxAttempts,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := s.db.Create(delivery).Error; err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// deliver claims the delivery and makes one attempt. Claiming pushes the next
+// attempt out by a lease, so the background worker and other replicas skip it
+// while the request is in flight.
+func (s *WebhookService) deliver(deliveryID uuid.UUID) {
+	lease := time.Now().Add(5 * time.Minute)
+	result := s.db.Model(&database.WebhookDelivery{}).
+		Where("id = ? AND status IN ? AND next_attempt_at <= ?", deliveryID, []string{WebhookPending, WebhookRetrying}, time.Now()).
+		Update("next_attempt_at", lease)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	var delivery database.WebhookDelivery
+	if err := s.db.First(&delivery, "id = ?", deliveryID).Error; err != nil {
+		log.Printf("Failed to load webhook delivery %s: %v", deliveryID, err)
+		return
+	}
+	if err := s.attempt(&delivery); err != nil {
+		log.Printf("Failed to record webhook attempt %s: %v", deliveryID, err)
+	}
+}
+
+func (s *WebhookService) attempt(delivery *database.WebhookDelivery) error {
+	var endpoint database.WebhookEndpoint
+	var status int
+	var body string
+	var sendErr error
+	permanent := false // dead-letter without further retries
+
+	if err := s.db.First(&endpoint, "id = ?", delivery.EndpointID).Error; err != nil {
+		sendErr, permanent = errors.New("endpoint was deleted"), true
+	} else if !endpoint.IsActive && delivery.EventType != WebhookTestEvent {
+		sendErr, permanent = errors.New("endpoint is disabled"), true
+	} else {
+		status, body, sendErr = s.post(&endpoint, delivery)
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = status
+	delivery.ResponseBody = body
+	delivery.UpdatedAt = now
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = WebhookDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.Error = ""
+	case permanent || delivery.Attempts >= delivery.MaxAttempts:
+		delivery.Status = WebhookDead
+		delivery.NextAttemptAt = nil
+		delivery.Error = sendErr.Error()
+	default:
+		next := now.Add(s.backoff(delivery.Attempts))
+		delivery.Status = WebhookRetrying
+		delivery.NextAttemptAt = &next
+		delivery.Error = sendErr.Error()
+	}
+
+	return s.db.Model(delivery).Select(
+		"status", "attempts", "last_attempt_at", "delivered_at", "next_attempt_at",
+		"response_status", "response_body", "error", "updated_at",
+	).Updates(delivery).Error
+}
+
+// post sends the signed payload. Any 2xx response counts as delivered.
+func (s *WebhookService) post(endpoint *database.WebhookEndpoint, delivery *database.WebhookDelivery) (int, string, error) {
+	payload := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "medical-records-app-webhooks/1.0")
+	req.Header.Set(webhook.HeaderID, delivery.ID.String())
+	req.Header.Set(webhook.HeaderEvent, delivery.EventType)
+	req.Header.Set(webhook.HeaderTimestamp, fmt.Sprint(timestamp))
+	req.Header.Set(webhook.HeaderSignature, webhook.Sign(endpoint.Secret, timestamp, payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(body), fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// backoff doubles the wait after each failed attempt
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	wait := s.retryBase
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+	}
+	return wait
+}
+
+// scopeEndpoints limits a query to the owner's endpoints, or to global
+// endpoints when ownerID is nil
+func scopeEndpoints(db *gorm.DB, ownerID *uuid.UUID) *gorm.DB {
+	if ownerID == nil {
+		return db.Where("user_id IS NULL")
+	}
+	return db.Where("user_id = ?", *ownerID)
+}
+
+func subscribedTo(endpoint *database.WebhookEndpoint, eventType string) bool {
+	var types []string
+	if err := json.Unmarshal([]byte(endpoint.EventTypes), &types); err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeEventTypes(types []string) (string, error) {
+	if len(types) == 0 {
+		return "", errors.New("at least one event type is required")
+	}
+	known := map[string]bool{"*": true}
+	for _, t := range events.Types {
+		known[t] = true
+	}
+	for _, t := range types {
+		if !known[t] {
+			return "", fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	encoded, err := json.Marshal(types)
+	return string(encoded), err
+}
+
+func newWebhookSecret() (string, error) {
+	token, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + token, nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"io"
+	"medical-records-app/internal/database"
+	"medical-records-app/internal/webhook"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestWebhookPostHeaders checks that a delivery carries the headers a
+// receiver needs and that its signature verifies against the endpoint secret
+func TestWebhookPostHeaders(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := NewWebhookService(nil, server.Client(), 3, time.Second)
+	endpoint := &database.WebhookEndpoint{URL: server.URL, Secret: "whsec_test"}
+	delivery := &database.WebhookDelivery{
+		ID:        uuid.New(),
+		EventType: "record.created",
+		Payload:   `{"event":"record.created"}`,
+	}
+
+	status, _, err := s.post(endpoint, delivery)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if status != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", status, http.StatusNoContent)
+	}
+	if string(gotBody) != delivery.Payload {
+		t.Errorf("body = %q, want %q", gotBody, delivery.Payload)
+	}
+	if h := got.Header.Get(webhook.HeaderID); h != delivery.ID.String() {
+		t.Errorf("%s = %q, want %q", webhook.HeaderID, h, delivery.ID)
+	}
+	if h := got.Header.Get(webhook.HeaderEvent); h != delivery.EventType {
+		t.Errorf("%s = %q, want %q", webhook.HeaderEvent, h, delivery.EventType)
+	}
+	if h := got.Header.Get("Content-Type"); h != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", h)
+	}
+	signature := got.Header.Get(webhook.HeaderSignature)
+	timestamp := got.Header.Get(webhook.HeaderTimestamp)
+	if err := webhook.Verify(endpoint.Secret, signature, timestamp, gotBody, time.Minute); err != nil {
+		t.Errorf("signature %q with timestamp %q doesn't verify: %v", signature, timestamp, err)
+	}
+}
@@ -0,0 +1,124 @@
+// Package storage reads record attachments from where their URLs point:
+// objects in the configured S3 bucket are fetched with signed requests, other
+// http(s) URLs with a plain GET. Callers stream the result so the underlying
+// URL never has to reach a client.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"medical-records-app/internal/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("file not found")
+	ErrUnsupported = errors.New("unsupported file location")
+)
+
+// Object is an open file. Close the body when done.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64 // -1 when unknown
+}
+
+// Store opens attachment URLs
+type Store struct {
+	aws    config.AWSConfig
+	client *http.Client
+}
+
+// New returns a store that signs requests for cfg.S3Bucket and fetches other
+// URLs through client, which should refuse internal addresses in production
+func New(cfg config.AWSConfig, client *http.Client) *Store {
+	return &Store{aws: cfg, client: client}
+}
+
+// Open fetches the file at location
+func (s *Store) Open(ctx context.Context, location string) (*Object, error) {
+	u, err := url.Parse(location)
+	if err != nil || location == "" {
+		return nil, ErrUnsupported
+	}
+
+	var req *http.Request
+	if key, ok := s.bucketKey(u); ok {
+		if s.aws.AccessKeyID == "" || s.aws.SecretAccessKey == "" {
+			return nil, errors.New("S3 credentials are not configured")
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+		if err != nil {
+			return nil, err
+		}
+		signS3Request(req, s.aws, time.Now())
+	} else {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, ErrUnsupported
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		resp.Body.Close()
+		return nil, fmt.Errorf("storage returned %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Object{Body: resp.Body, ContentType: contentType, Size: resp.ContentLength}, nil
+}
+
+// bucketKey returns the object key when u points into the configured bucket,
+// as s3://bucket/key or a virtual-hosted or path-style S3 URL
+func (s *Store) bucketKey(u *url.URL) (string, bool) {
+	bucket := s.aws.S3Bucket
+	if bucket == "" {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	path := strings.TrimPrefix(u.Path, "/")
+
+	switch {
+	case u.Scheme == "s3" && u.Host == bucket:
+		return path, path != ""
+	case u.Scheme == "https" && strings.HasPrefix(host, bucket+".s3.") && strings.HasSuffix(host, ".amazonaws.com"):
+		return path, path != ""
+	case u.Scheme == "https" && strings.HasPrefix(host, "s3.") && strings.HasSuffix(host, ".amazonaws.com") &&
+		strings.HasPrefix(path, bucket+"/"):
+		key := strings.TrimPrefix(path, bucket+"/")
+		return key, key != ""
+	}
+	return "", false
+}
+
+func (s *Store) objectURL(key string) string {
+	return "https://" + s.aws.S3Bucket + ".s3." + s.aws.Region + ".amazonaws.com/" + escapeKey(key)
+}
+
+// escapeKey URI-encodes each path segment of an object key, as SigV4 expects
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
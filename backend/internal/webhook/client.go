@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateTarget is returned when a webhook URL resolves to a loopback,
+// private or link-local address and private targets aren't allowed
+var ErrPrivateTarget = errors.New("webhook target resolves to a private address")
+
+// NewClient returns the HTTP client used for deliveries. Unless allowPrivate
+// is set, connections to internal addresses are refused at dial time, which
+// also covers DNS names that resolve to them and redirects.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && isPrivate(ip) {
+				return ErrPrivateTarget
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// ValidateURL checks that a webhook URL is an absolute http(s) URL
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("webhook URL must use http or https")
+	}
+	if u.Host == "" {
+		return errors.New("webhook URL must include a host")
+	}
+	if u.User != nil {
+		return errors.New("webhook URL must not contain credentials")
+	}
+	return nil
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
@@ -0,0 +1,54 @@
+// Package webhook signs outbound webhook requests and provides the HTTP
+// client used to deliver them.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers set on every delivery
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign returns the X-Webhook-Signature value for a body sent at timestamp
+// (Unix seconds). The timestamp is part of the signed message so a captured
+// request can't be replayed later with a fresh timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature and rejects timestamps further than tolerance
+// from now. Receivers can use it as-is.
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return errors.New("timestamp outside tolerance")
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return errors.New("unsupported signature scheme")
+	}
+	expected := Sign(secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
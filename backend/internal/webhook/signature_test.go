@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// Computed independently with:
+	//   printf '1700000000.{"event":"test"}' | openssl dgst -sha256 -hmac whsec_test
+	const want = "sha256=21d2d3606ebbdbf9307ee15e83085df2b83c83dd87cc2e6d2ea6b1cb61afdc3c"
+	if got := Sign("whsec_test", 1700000000, []byte(`{"event":"test"}`)); got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+
+	// The timestamp and secret are both part of the signature
+	base := Sign("whsec_test", 1700000000, []byte(`{"event":"test"}`))
+	if Sign("whsec_test", 1700000001, []byte(`{"event":"test"}`)) == base {
+		t.Error("signature doesn't depend on the timestamp")
+	}
+	if Sign("whsec_other", 1700000000, []byte(`{"event":"test"}`)) == base {
+		t.Error("signature doesn't depend on the secret")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"event":"record.created","id":"42"}`)
+	now := time.Now().Unix()
+	stamp := strconv.FormatInt(now, 10)
+	valid := Sign(secret, now, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		wantErr   string
+	}{
+		{"valid", secret, valid, stamp, body, ""},
+		{"tampered body", secret, valid, stamp, []byte(`{"event":"record.created","id":"43"}`), "signature mismatch"},
+		{"wrong secret", "whsec_other", valid, stamp, body, "signature mismatch"},
+		{"timestamp changed", secret, valid, strconv.FormatInt(now-1, 10), body, "signature mismatch"},
+		{"missing prefix", secret, valid[len(signaturePrefix):], stamp, body, "unsupported signature scheme"},
+		{"other scheme", secret, "sha1=" + valid[len(signaturePrefix):], stamp, body, "unsupported signature scheme"},
+		{"stale", secret, Sign(secret, now-600, body), strconv.FormatInt(now-600, 10), body, "timestamp outside tolerance"},
+		{"future", secret, Sign(secret, now+600, body), strconv.FormatInt(now+600, 10), body, "timestamp outside tolerance"},
+		{"bad timestamp", secret, valid, "yesterday", body, "invalid timestamp"},
+		{"empty timestamp", secret, valid, "", body, "invalid timestamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.signature, tt.timestamp, tt.body, 5*time.Minute)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Verify: got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
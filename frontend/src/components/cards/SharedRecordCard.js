@@ -5,7 +5,7 @@ const SharedRecordCard = ({ sharedRecord, onRevoke }) => {
   return (
     <div className="card">
       <div className="card-header">
-        <h3>Share Link: {sharedRecord.shareToken?.substring(0, 20)}...</h3>
+        <h3>Share Link: {sharedRecord.tokenPrefix}...</h3>
         {sharedRecord.isActive && onRevoke && (
           <button
             className="btn btn-danger btn-sm"
@@ -23,7 +23,9 @@ const SharedRecordCard = ({ sharedRecord, onRevoke }) => {
           {sharedRecord.maxAccessCount || '∞'}
         </p>
         <p><strong>Status:</strong> {sharedRecord.isActive ? 'Active' : 'Revoked'}</p>
-        <p><strong>Share URL:</strong> {sharedRecord.shareUrl}</p>
+        {sharedRecord.shareUrl && (
+          <p><strong>Share URL:</strong> {sharedRecord.shareUrl}</p>
+        )}
       </div>
     </div>
   );
@@ -62,9 +62,10 @@ const ShareLinkForm = ({ formData, onChange, onSubmit, onCancel }) => {
             <option value="link">Link</option>
             <option value="email">Email</option>
             <option value="sms">SMS</option>
+            <option value="user">App user</option>
           </select>
         </div>
-        {formData.share_method === 'email' && (
+        {(formData.share_method === 'email' || formData.share_method === 'user') && (
           <div className="form-group">
             <label>Recipient Email *</label>
             <input
@@ -96,6 +97,16 @@ const ShareLinkForm = ({ formData, onChange, onSubmit, onCancel }) => {
             Allow Download
           </label>
         </div>
+        <div className="form-group">
+          <label>
+            <input
+              type="checkbox"
+              checked={formData.notify_owner_on_access || false}
+              onChange={(e) => handleChange('notify_owner_on_access', e.target.checked)}
+            />
+            Notify me when the link is first opened or downloaded from
+          </label>
+        </div>
         <div className="form-actions">
           <button type="submit" className="btn btn-primary">Create Share Link</button>
           <button type="button" className="btn btn-secondary" onClick={onCancel}>Cancel</button>
@@ -1,5 +1,5 @@
 import React, { createContext, useState, useContext, useEffect } from 'react';
-import api from '../services/api';
+import api, { setTokens, clearTokens } from '../services/api';
 
 const AuthContext = createContext();
 
@@ -41,12 +41,15 @@ export const AuthProvider = ({ children }) => {
   const login = async (email, password) => {
     try {
       const response = await api.post('/auth/login', { email, password });
+      if (response.data.mfa_required) {
+        // Second step: the caller asks for a code and calls verifyMfa
+        return { success: false, mfaRequired: true, mfaToken: response.data.mfa_token };
+      }
       const { token: newToken, user: userData } = response.data;
       
-      localStorage.setItem('token', newToken);
+      setTokens(response.data);
       setToken(newToken);
       setUser(userData);
-      api.defaults.headers.common['Authorization'] = `Bearer ${newToken}`;
       
       return { success: true };
     } catch (error) {
@@ -57,6 +60,24 @@ export const AuthProvider = ({ children }) => {
     }
   };
 
+  const verifyMfa = async (mfaToken, code) => {
+    try {
+      const response = await api.post('/auth/mfa/verify', { mfa_token: mfaToken, code });
+      const { token: newToken, user: userData } = response.data;
+
+      setTokens(response.data);
+      setToken(newToken);
+      setUser(userData);
+
+      return { success: true };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Verification failed',
+      };
+    }
+  };
+
   const register = async (email, password, firstName, lastName, phone) => {
     try {
       const response = await api.post('/auth/register', {
@@ -68,10 +89,9 @@ export const AuthProvider = ({ children }) => {
       });
       const { token: newToken, user: userData } = response.data;
       
-      localStorage.setItem('token', newToken);
+      setTokens(response.data);
       setToken(newToken);
       setUser(userData);
-      api.defaults.headers.common['Authorization'] = `Bearer ${newToken}`;
       
       return { success: true };
     } catch (error) {
@@ -82,11 +102,62 @@ export const AuthProvider = ({ children }) => {
     }
   };
 
+  const changePassword = async (currentPassword, newPassword) => {
+    try {
+      // Changing the password signs out every session; this one continues
+      // with the tokens in the response
+      const response = await api.post('/auth/password/change', {
+        current_password: currentPassword,
+        new_password: newPassword,
+      });
+      setTokens(response.data);
+      setToken(response.data.token);
+      setUser(response.data.user);
+      return { success: true };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Failed to change password',
+      };
+    }
+  };
+
+  const verifyEmail = async (verificationToken) => {
+    try {
+      await api.post('/auth/verify-email', { token: verificationToken });
+      if (user) {
+        setUser({ ...user, is_email_verified: true });
+      }
+      return { success: true };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Verification failed',
+      };
+    }
+  };
+
+  const resendEmailVerification = async () => {
+    try {
+      await api.post('/auth/verify-email/resend');
+      return { success: true };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Failed to send verification email',
+      };
+    }
+  };
+
   const logout = () => {
-    localStorage.removeItem('token');
+    const refreshToken = localStorage.getItem('refresh_token');
+    if (refreshToken) {
+      // Sign the session out on the server too; the local logout doesn't wait
+      api.post('/auth/logout', { refresh_token: refreshToken }).catch(() => {});
+    }
+    clearTokens();
     setToken(null);
     setUser(null);
-    delete api.defaults.headers.common['Authorization'];
   };
 
   const value = {
@@ -94,7 +165,11 @@ export const AuthProvider = ({ children }) => {
     token,
     loading,
     login,
+    verifyMfa,
     register,
+    changePassword,
+    verifyEmail,
+    resendEmailVerification,
     logout,
     isAuthenticated: !!token,
   };
@@ -2,9 +2,11 @@ import api from '../services/api';
 import { SharedRecord } from '../models';
 
 class SharingController {
-  async fetchMyShares() {
+  async fetchMyShares(status) {
     try {
-      const response = await api.get('/sharing/my-shares');
+      const response = await api.get('/sharing/my-shares', {
+        params: status ? { status } : {},
+      });
       return {
         success: true,
         data: response.data.data.map(item => new SharedRecord(item)),
@@ -47,6 +49,59 @@ class SharingController {
     }
   }
 
+  async updateShareLink(id, changes) {
+    try {
+      const response = await api.put(`/sharing/${id}`, changes);
+      return {
+        success: true,
+        data: new SharedRecord(response.data),
+      };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Failed to update share link',
+      };
+    }
+  }
+
+  async fetchInbox(status) {
+    try {
+      const response = await api.get('/sharing/inbox', {
+        params: status ? { status } : {},
+      });
+      return { success: true, data: response.data.data };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Failed to fetch shares sent to you',
+      };
+    }
+  }
+
+  async respondToShare(id, accept) {
+    try {
+      const response = await api.post(`/sharing/inbox/${id}/${accept ? 'accept' : 'decline'}`);
+      return { success: true, data: response.data };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Failed to answer share',
+      };
+    }
+  }
+
+  async fetchInboxShare(id) {
+    try {
+      const response = await api.get(`/sharing/inbox/${id}`);
+      return { success: true, data: response.data };
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || 'Failed to open share',
+      };
+    }
+  }
+
   async revokeShareLink(id) {
     try {
       await api.post(`/sharing/${id}/revoke`);
@@ -2,7 +2,9 @@ export class SharedRecord {
   constructor(data = {}) {
     this.id = data.id;
     this.userId = data.user_id;
+    // Only present right after creation; listings carry just the prefix
     this.shareToken = data.share_token;
+    this.tokenPrefix = data.token_prefix;
     this.recordType = data.record_type;
     this.recordIds = data.record_ids;
     this.expiresAt = data.expires_at;
@@ -11,8 +13,16 @@ export class SharedRecord {
     this.allowDownload = data.allow_download || false;
     this.recipientEmail = data.recipient_email;
     this.recipientPhone = data.recipient_phone;
+    // In-app shares: the recipient account and whether they accepted
+    this.recipientStatus = data.recipient_status;
     this.shareMethod = data.share_method;
+    this.notifyOwnerOnAccess = data.notify_owner_on_access || false;
+    this.firstAccessedAt = data.first_accessed_at;
     this.isActive = data.is_active;
+    // active, expired, exhausted or revoked
+    this.status = data.status;
+    this.deactivatedAt = data.deactivated_at;
+    this.deactivationReason = data.deactivation_reason;
     this.createdAt = data.created_at;
     this.updatedAt = data.updated_at;
   }
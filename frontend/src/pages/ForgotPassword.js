@@ -0,0 +1,59 @@
+import React, { useState } from 'react';
+import { Link } from 'react-router-dom';
+import api from '../services/api';
+import './Auth.css';
+
+const ForgotPassword = () => {
+  const [email, setEmail] = useState('');
+  const [message, setMessage] = useState('');
+  const [error, setError] = useState('');
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    setMessage('');
+    setLoading(true);
+
+    try {
+      const response = await api.post('/auth/password/forgot', { email });
+      setMessage(response.data.message);
+    } catch (err) {
+      setError(err.response?.data?.error || 'Failed to request a reset link');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="auth-container">
+      <div className="auth-card">
+        <h2>Forgot Password</h2>
+        {error && <div className="error-message">{error}</div>}
+        {message ? (
+          <p>{message}</p>
+        ) : (
+          <form onSubmit={handleSubmit}>
+            <div className="form-group">
+              <label>Email</label>
+              <input
+                type="email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+              />
+            </div>
+            <button type="submit" className="btn btn-primary" disabled={loading}>
+              {loading ? 'Sending...' : 'Send reset link'}
+            </button>
+          </form>
+        )}
+        <p className="auth-link">
+          <Link to="/login">Back to login</Link>
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default ForgotPassword;
@@ -8,7 +8,9 @@ const Login = () => {
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
-  const { login } = useAuth();
+  const [mfaToken, setMfaToken] = useState('');
+  const [code, setCode] = useState('');
+  const { login, verifyMfa } = useAuth();
   const navigate = useNavigate();
 
   const handleSubmit = async (e) => {
@@ -21,11 +23,60 @@ const Login = () => {
 
     if (result.success) {
       navigate('/');
+    } else if (result.mfaRequired) {
+      setMfaToken(result.mfaToken);
     } else {
       setError(result.error);
     }
   };
 
+  const handleMfaSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    setLoading(true);
+
+    const result = await verifyMfa(mfaToken, code);
+    setLoading(false);
+
+    if (result.success) {
+      navigate('/');
+    } else {
+      setError(result.error);
+    }
+  };
+
+  if (mfaToken) {
+    return (
+      <div className="auth-container">
+        <div className="auth-card">
+          <h2>Two-Factor Authentication</h2>
+          {error && <div className="error-message">{error}</div>}
+          <form onSubmit={handleMfaSubmit}>
+            <div className="form-group">
+              <label>Code from your authenticator app, or a recovery code</label>
+              <input
+                type="text"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                autoComplete="one-time-code"
+                autoFocus
+                required
+              />
+            </div>
+            <button type="submit" className="btn btn-primary" disabled={loading}>
+              {loading ? 'Verifying...' : 'Verify'}
+            </button>
+          </form>
+          <p className="auth-link">
+            <button type="button" className="btn btn-secondary" onClick={() => { setMfaToken(''); setCode(''); }}>
+              Back to login
+            </button>
+          </p>
+        </div>
+      </div>
+    );
+  }
+
   return (
     <div className="auth-container">
       <div className="auth-card">
@@ -54,6 +105,9 @@ const Login = () => {
             {loading ? 'Logging in...' : 'Login'}
           </button>
         </form>
+        <p className="auth-link">
+          <Link to="/forgot-password">Forgot your password?</Link>
+        </p>
         <p className="auth-link">
           Don't have an account? <Link to="/register">Register here</Link>
         </p>
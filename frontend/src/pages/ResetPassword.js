@@ -0,0 +1,78 @@
+import React, { useState } from 'react';
+import { Link, useSearchParams } from 'react-router-dom';
+import api from '../services/api';
+import './Auth.css';
+
+const ResetPassword = () => {
+  const [searchParams] = useSearchParams();
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [error, setError] = useState('');
+  const [done, setDone] = useState(false);
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    if (password !== confirmPassword) {
+      setError('Passwords do not match');
+      return;
+    }
+    setLoading(true);
+
+    try {
+      await api.post('/auth/password/reset', {
+        token: searchParams.get('token') || '',
+        password,
+      });
+      setDone(true);
+    } catch (err) {
+      setError(err.response?.data?.error || 'Failed to reset password');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="auth-container">
+      <div className="auth-card">
+        <h2>Reset Password</h2>
+        {error && <div className="error-message">{error}</div>}
+        {done ? (
+          <p>Your password has been reset. You have been signed out on every device.</p>
+        ) : (
+          <form onSubmit={handleSubmit}>
+            <div className="form-group">
+              <label>New password</label>
+              <input
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                minLength={8}
+                required
+              />
+            </div>
+            <div className="form-group">
+              <label>Confirm new password</label>
+              <input
+                type="password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                minLength={8}
+                required
+              />
+            </div>
+            <button type="submit" className="btn btn-primary" disabled={loading}>
+              {loading ? 'Saving...' : 'Set new password'}
+            </button>
+          </form>
+        )}
+        <p className="auth-link">
+          <Link to="/login">Back to login</Link>
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default ResetPassword;
@@ -14,6 +14,7 @@ const Sharing = () => {
     expires_in_hours: 24,
     max_access_count: 0,
     allow_download: false,
+    notify_owner_on_access: false,
     recipient_email: '',
     recipient_phone: '',
     share_method: 'link',
@@ -44,6 +45,8 @@ const Sharing = () => {
         expires_in_hours: 24,
         max_access_count: 0,
         allow_download: false,
+        notify_owner_on_access: false,
+    notify_owner_on_access: false,
         recipient_email: '',
         recipient_phone: '',
         share_method: 'link',
@@ -0,0 +1,64 @@
+import React, { useEffect, useRef, useState } from 'react';
+import { Link, useSearchParams } from 'react-router-dom';
+import { useAuth } from '../contexts/AuthContext';
+import './Auth.css';
+
+const VerifyEmail = () => {
+  const [searchParams] = useSearchParams();
+  const [status, setStatus] = useState('verifying');
+  const [error, setError] = useState('');
+  const [resendMessage, setResendMessage] = useState('');
+  const { verifyEmail, resendEmailVerification, isAuthenticated } = useAuth();
+  const attempted = useRef(false);
+
+  useEffect(() => {
+    // Verify once, even if the effect runs twice in development
+    if (attempted.current) {
+      return;
+    }
+    attempted.current = true;
+
+    const token = searchParams.get('token');
+    if (!token) {
+      setStatus('failed');
+      setError('This verification link is incomplete.');
+      return;
+    }
+    verifyEmail(token).then((result) => {
+      if (result.success) {
+        setStatus('verified');
+      } else {
+        setStatus('failed');
+        setError(result.error);
+      }
+    });
+  }, [searchParams, verifyEmail]);
+
+  const handleResend = async () => {
+    setResendMessage('');
+    const result = await resendEmailVerification();
+    setResendMessage(result.success ? 'A new verification email is on its way.' : result.error);
+  };
+
+  return (
+    <div className="auth-container">
+      <div className="auth-card">
+        <h2>Verify Email</h2>
+        {status === 'verifying' && <p>Verifying your email address...</p>}
+        {status === 'verified' && <p>Your email address is verified.</p>}
+        {status === 'failed' && <div className="error-message">{error}</div>}
+        {status === 'failed' && isAuthenticated && (
+          <button type="button" className="btn btn-primary" onClick={handleResend}>
+            Send a new link
+          </button>
+        )}
+        {resendMessage && <p>{resendMessage}</p>}
+        <p className="auth-link">
+          {isAuthenticated ? <Link to="/">Go to dashboard</Link> : <Link to="/login">Log in</Link>}
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default VerifyEmail;
@@ -13,14 +13,60 @@ if (token) {
   api.defaults.headers.common['Authorization'] = `Bearer ${token}`;
 }
 
+// Stores the tokens from a login, registration or refresh
+export const setTokens = ({ token, refresh_token }) => {
+  localStorage.setItem('token', token);
+  if (refresh_token) {
+    localStorage.setItem('refresh_token', refresh_token);
+  }
+  api.defaults.headers.common['Authorization'] = `Bearer ${token}`;
+};
+
+export const clearTokens = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  delete api.defaults.headers.common['Authorization'];
+};
+
+// Refresh tokens are single-use, so concurrent 401s share one refresh
+let refreshing = null;
+
+const refreshTokens = () => {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshing = (refreshToken
+      ? axios.post(`${api.defaults.baseURL}/auth/refresh`, { refresh_token: refreshToken })
+      : Promise.reject(new Error('No refresh token'))
+    )
+      .then((response) => {
+        setTokens(response.data);
+        return response.data.token;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+};
+
 // Response interceptor for handling errors
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
-    if (error.response?.status === 401) {
-      // Unauthorized - clear token and redirect to login
-      localStorage.removeItem('token');
-      window.location.href = '/login';
+  async (error) => {
+    const request = error.config;
+    const isAuthCall = ['/auth/login', '/auth/register', '/auth/refresh', '/auth/logout', '/auth/mfa/verify'].includes(request?.url);
+    if (error.response?.status === 401 && request && !request._retried && !isAuthCall) {
+      // Access token expired - get a new one and retry once
+      request._retried = true;
+      try {
+        const token = await refreshTokens();
+        request.headers['Authorization'] = `Bearer ${token}`;
+        return api(request);
+      } catch (refreshError) {
+        // Session is over - clear tokens and redirect to login
+        clearTokens();
+        window.location.href = '/login';
+      }
     }
     return Promise.reject(error);
   }